@@ -103,13 +103,7 @@ func chkUserTwrpChanged(checked bool) {
 }
 
 func selectDefaultTwrp() {
-	if get.A1.Archive.Override_twrp.Img.Href != "" {
-		get.A1.User.Twrp = get.A1.Archive.Override_twrp
-	} else if get.A1.Upstream.Twrp.Img.Href != "" {
-		get.A1.User.Twrp = get.A1.Upstream.Twrp
-	} else if get.A1.Archive.Twrp.Img.Href != "" {
-		get.A1.User.Twrp = get.A1.Archive.Twrp
-	} else {
+	if !get.A1.SelectDefaultTwrp() {
 		Lbl_user_twrp.SetText("No TWRP available")
 		return
 	}
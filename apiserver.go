@@ -0,0 +1,683 @@
+package main
+
+import (
+	"github.com/amo13/anarchy-droid/get"
+	"github.com/amo13/anarchy-droid/device"
+	"github.com/amo13/anarchy-droid/device/errs"
+	"github.com/amo13/anarchy-droid/lookup"
+	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/helpers"
+	"github.com/amo13/anarchy-droid/flashplan"
+
+	"fmt"
+	"flag"
+	"sync"
+	"time"
+	"strings"
+	"net/http"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Local HTTP JSON api so that dashboards and scripts can drive flashing stations
+// Started with the "serve" subcommand
+//
+//   GET  /api/devices                   connected devices
+//   GET  /api/devices/<serial>          device with its ADB props and fastboot vars
+//   GET  /api/roms?codename=X           available roms of a codename
+//   GET  /api/lookup/codenames?model=X  codename candidates of a model
+//   GET  /api/lookup/brand?codename=X   brand of a codename
+//   GET  /api/job                       current or last flash job
+//   POST /api/job                       start a flash job (apiFlashRequest)
+//   POST /api/job/unlock-code           continue a job waiting for {"code": "..."}
+//   POST /api/job/cancel                cancel the running job
+//   GET  /api/events                    server-sent events: device, job, flash and log
+//
+// Every request needs the header "Authorization: Bearer <token>",
+// POSTs need "Content-Type: application/json" even without a body
+
+// Body of POST /api/job, missing fields keep their defaults
+type apiFlashRequest struct {
+	Rom string `json:"rom"`	// Name of an available rom or path to a zip file on the station
+	Build string `json:"build"`	// Date or file name of an older build
+	Twrp string `json:"twrp"`	// Path to a TWRP image file on the station
+	Update bool `json:"update"`	// Update the installed rom without wiping data
+	Resume bool `json:"resume"`	// Resume the interrupted installation of the device, other fields are ignored
+	Options FlashOptions `json:"options"`
+}
+
+type apiDevice struct {
+	Serial string `json:"serial"`
+	State string `json:"state"`
+	State_request string `json:"state_request"`
+	Scanning bool `json:"scanning"`
+	Flashing bool `json:"flashing"`
+	Brand string `json:"brand"`
+	Model string `json:"model"`
+	Codename string `json:"codename"`
+	Codename_ambiguous bool `json:"codename_ambiguous"`
+	Codename_confidence float64 `json:"codename_confidence"`
+	Name string `json:"name"`
+	Arch string `json:"arch"`
+	Is_ab bool `json:"is_ab"`
+	Is_unlocked bool `json:"is_unlocked"`
+	Is_supported bool `json:"is_supported"`
+	Twrp_version string `json:"twrp_version,omitempty"`
+	Installed_rom string `json:"installed_rom,omitempty"`
+	Installed_rom_version string `json:"installed_rom_version,omitempty"`
+	Installed_gapps string `json:"installed_gapps,omitempty"`
+	Resumable bool `json:"resumable"`	// An interrupted installation can be resumed
+	Adb_props map[string]string `json:"adb_props,omitempty"`
+	Fastboot_vars map[string]string `json:"fastboot_vars,omitempty"`
+}
+
+type apiJob struct {
+	Id int `json:"id"`
+	Kind string `json:"kind"`	// "flash", "update" or "resume"
+	State string `json:"state"`	// "running", "unlock_code_needed", "finished", "failed" or "cancelled"
+	Serial string `json:"serial"`
+	Codename string `json:"codename"`
+	Rom string `json:"rom"`
+	Step int `json:"step"`	// Number of the running step of the flash plan
+	Action string `json:"action,omitempty"`
+	Progress string `json:"progress"`
+	Instructions string `json:"instructions"`
+	Busy bool `json:"busy"`
+	Error string `json:"error,omitempty"`
+	Started time.Time `json:"started"`
+	Ended *time.Time `json:"ended,omitempty"`
+}
+
+type apiEvent struct {
+	name string
+	data []byte
+}
+
+// Fans out events to all connected event stream clients
+type apiBroker struct {
+	mutex sync.Mutex
+	clients map[chan apiEvent]bool
+}
+
+var api_broker = &apiBroker{clients: make(map[chan apiEvent]bool)}
+
+// Only one device can be connected, so there is at most one running job
+var api_job *apiJob
+var api_job_count int
+var api_job_mutex sync.Mutex
+
+// PopulateForApi replaces get.A1, so only look up roms one at a time
+// and never while a job populates get.A1 for itself
+var api_roms_mutex sync.Mutex
+
+func (b *apiBroker) subscribe() chan apiEvent {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	c := make(chan apiEvent, 64)
+	b.clients[c] = true
+	return c
+}
+
+func (b *apiBroker) unsubscribe(c chan apiEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.clients, c)
+}
+
+func (b *apiBroker) publish(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for c := range b.clients {
+		select {
+		case c <- apiEvent{name, data}:
+		default:
+			// Drop events for clients not keeping up
+		}
+	}
+}
+
+// Reports the events of the flash plan engine to the api clients
+type apiFlashUi struct {}
+
+// Receives the unlock code posted to /api/job/unlock-code
+var api_unlock_code = make(chan string)
+
+func (u *apiFlashUi) Event(e flashplan.Event) {
+	api_broker.publish("flash", e)
+
+	switch e.Kind {
+	case flashplan.EventProgress:
+		updateApiJob(func(j *apiJob) { j.Progress = e.Text })
+	case flashplan.EventInstructions:
+		updateApiJob(func(j *apiJob) { j.Instructions = e.Text })
+	case flashplan.EventBusy, flashplan.EventIdle:
+		updateApiJob(func(j *apiJob) { j.Busy = e.Kind == flashplan.EventBusy })
+	case flashplan.EventStepStarted:
+		updateApiJob(func(j *apiJob) { j.Step = e.Step; j.Action = e.Action })
+	case flashplan.EventFinished:
+		updateApiJob(func(j *apiJob) { j.State = "finished" })
+	}
+}
+
+func (u *apiFlashUi) UnlockCode(brand string) (string, error) {
+	updateApiJob(func(j *apiJob) {
+		j.State = "unlock_code_needed"
+		j.Instructions = brand + " devices need an unlock code from the manufacturer, please post it to /api/job/unlock-code."
+	})
+
+	unlock_code := <-api_unlock_code
+	if !device.D1.Flashing {
+		return "", errs.ErrCancelled
+	}
+	return unlock_code, nil
+}
+
+// Modifies the current job and sends it to the event stream clients
+func updateApiJob(update func(j *apiJob)) {
+	api_job_mutex.Lock()
+	if api_job == nil {
+		api_job_mutex.Unlock()
+		return
+	}
+	update(api_job)
+	job := *api_job
+	api_job_mutex.Unlock()
+
+	api_broker.publish("job", job)
+}
+
+func apiJobActive() bool {
+	api_job_mutex.Lock()
+	defer api_job_mutex.Unlock()
+	return api_job != nil && helpers.IsStringInSlice(api_job.State, []string{"running", "unlock_code_needed"})
+}
+
+// Called when the flashing procedure returned
+func endApiJob(err error) {
+	updateApiJob(func(j *apiJob) {
+		if err != nil {
+			if j.State != "cancelled" {
+				j.State = "failed"
+			}
+			j.Error = err.Error()
+		} else if j.State == "running" {
+			j.State = "finished"
+		}
+		j.Busy = false
+		now := time.Now()
+		j.Ended = &now
+	})
+
+	// Get ready for the next device
+	device.D1.StartOver()
+	get.A1 = get.NewAvailable()
+}
+
+func apiDeviceFromD1(with_props bool) apiDevice {
+	d := device.D1
+	a := apiDevice{
+		Serial: d.SerialNumber,
+		State: d.State,
+		State_request: d.State_request,
+		Scanning: d.Scanning,
+		Flashing: d.Flashing,
+		Brand: d.Brand,
+		Model: d.Model,
+		Codename: d.Codename,
+		Codename_ambiguous: d.Codename_ambiguous,
+		Codename_confidence: d.Codename_confidence,
+		Name: d.Name,
+		Arch: d.Arch,
+		Is_ab: d.IsAB,
+		Is_unlocked: d.IsUnlocked,
+		Is_supported: d.IsSupported,
+		Twrp_version: d.TwrpVersionConnected,
+		Installed_rom: d.InstalledRom,
+		Installed_rom_version: d.InstalledRomVersion,
+		Installed_gapps: d.InstalledGapps,
+		Resumable: flashplan.HasCheckpoint(d.SerialNumber),
+	}
+	if with_props {
+		a.Adb_props = d.AdbProps
+		a.Fastboot_vars = d.FastbootVars
+	}
+
+	return a
+}
+
+func writeApiJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.LogError("API-Server: Unable to write response:", err)
+	}
+}
+
+func writeApiError(w http.ResponseWriter, status int, message string) {
+	writeApiJson(w, status, map[string]string{"error": message})
+}
+
+// Wraps a handler with the method check and the token authentication
+// Websites open in the browser must not be able to start a flash job,
+// so foreign origins and POSTs other than JSON are rejected as well
+func apiHandler(method string, token string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && origin != "http://" + r.Host && origin != "https://" + r.Host {
+			writeApiError(w, http.StatusForbidden, "cross-origin requests are not allowed")
+			return
+		}
+		if token != "" && r.Header.Get("Authorization") != "Bearer " + token {
+			writeApiError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if r.Method == "POST" && !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			writeApiError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Random token for servers started without one
+func generateApiToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func apiDevices(w http.ResponseWriter, r *http.Request) {
+	devices := []apiDevice{}
+	if device.D1.State != "disconnected" {
+		devices = append(devices, apiDeviceFromD1(false))
+	}
+	writeApiJson(w, http.StatusOK, devices)
+}
+
+func apiDeviceBySerial(w http.ResponseWriter, r *http.Request) {
+	serial := strings.TrimPrefix(r.URL.Path, "/api/devices/")
+	if device.D1.State == "disconnected" || serial != device.D1.SerialNumber {
+		writeApiError(w, http.StatusNotFound, "no device with serial number " + serial)
+		return
+	}
+	writeApiJson(w, http.StatusOK, apiDeviceFromD1(true))
+}
+
+func apiRoms(w http.ResponseWriter, r *http.Request) {
+	codename := r.URL.Query().Get("codename")
+	if codename == "" {
+		writeApiError(w, http.StatusBadRequest, "missing codename")
+		return
+	}
+	// Checked after locking, a job may have started while waiting
+	api_roms_mutex.Lock()
+	defer api_roms_mutex.Unlock()
+	if apiJobActive() {
+		writeApiError(w, http.StatusConflict, "a flash job is running")
+		return
+	}
+
+	a := get.NewAvailable()
+	err := a.PopulateForApi(codename)
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	writeApiJson(w, http.StatusOK, map[string]interface{}{
+		"codename": codename,
+		"upstream": a.Upstream.Rom,
+		"archive": a.Archive.Rom,
+	})
+}
+
+func apiLookupCodenames(w http.ResponseWriter, r *http.Request) {
+	model := r.URL.Query().Get("model")
+	if model == "" {
+		writeApiError(w, http.StatusBadRequest, "missing model")
+		return
+	}
+
+	codenames, err := lookup.ModelToCodenameCandidatesForApi(model)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeApiJson(w, http.StatusOK, map[string]interface{}{"model": model, "codenames": codenames})
+}
+
+func apiLookupBrand(w http.ResponseWriter, r *http.Request) {
+	codename := r.URL.Query().Get("codename")
+	if codename == "" {
+		writeApiError(w, http.StatusBadRequest, "missing codename")
+		return
+	}
+
+	brand, err := lookup.CodenameToBrandForApi(codename)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if brand == "" {
+		writeApiError(w, http.StatusNotFound, "unknown codename " + codename)
+		return
+	}
+	writeApiJson(w, http.StatusOK, map[string]string{"codename": codename, "brand": brand})
+}
+
+func apiJobGet(w http.ResponseWriter, r *http.Request) {
+	api_job_mutex.Lock()
+	defer api_job_mutex.Unlock()
+	if api_job == nil {
+		writeApiError(w, http.StatusNotFound, "no job yet")
+		return
+	}
+	writeApiJson(w, http.StatusOK, api_job)
+}
+
+func apiJobStart(w http.ResponseWriter, r *http.Request) {
+	d := device.D1
+	if !helpers.IsStringInSlice(d.State, []string{"android", "recovery", "fastboot", "heimdall"}) || d.Scanning || d.Codename == "" {
+		writeApiError(w, http.StatusConflict, "no recognized device connected")
+		return
+	}
+	if d.Codename_ambiguous {
+		writeApiError(w, http.StatusConflict, "the model " + d.Model + " matches several devices")
+		return
+	}
+	if !d.IsSupported {
+		writeApiError(w, http.StatusConflict, AppName + " does not support this device")
+		return
+	}
+
+	req := apiFlashRequest{
+		Rom: "LineageOS",
+		Options: FlashOptions{
+			Gapps: "MicroG",
+			Opengapps_variant: "pico",
+			Fdroid: true,
+			Reboot_after_installation: true,
+			Copy_partitions: d.IsAB,
+		},
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, "invalid request: " + err.Error())
+		return
+	}
+	if req.Resume && !flashplan.HasCheckpoint(d.SerialNumber) {
+		writeApiError(w, http.StatusConflict, "no interrupted installation found for " + d.SerialNumber)
+		return
+	}
+	req.Options.User_rom = strings.HasSuffix(strings.ToLower(req.Rom), ".zip")
+	req.Options.User_twrp = req.Twrp != ""
+	req.Options.Skip_wipe_data = req.Options.Skip_unlock && req.Options.Skip_wipe_data
+
+	if !req.Update && !req.Resume && !d.IsUnlocked && !d.IsBrandUnlockable && !req.Options.Skip_unlock {
+		writeApiError(w, http.StatusConflict, "unable to unlock " + d.Brand + " devices, unlock the bootloader yourself and use skip_unlock")
+		return
+	}
+
+	api_job_mutex.Lock()
+	if api_job != nil && helpers.IsStringInSlice(api_job.State, []string{"running", "unlock_code_needed"}) {
+		api_job_mutex.Unlock()
+		writeApiError(w, http.StatusConflict, "a flash job is already running")
+		return
+	}
+	api_job_count = api_job_count + 1
+	api_job = &apiJob{
+		Id: api_job_count,
+		Kind: "flash",
+		State: "running",
+		Serial: d.SerialNumber,
+		Codename: d.Codename,
+		Rom: req.Rom,
+		Started: time.Now(),
+	}
+	if req.Update {
+		api_job.Kind = "update"
+	}
+	if req.Resume {
+		api_job.Kind = "resume"
+		api_job.Rom = ""
+	}
+	job := *api_job
+	api_job_mutex.Unlock()
+
+	api_broker.publish("job", job)
+	go runApiJob(req)
+
+	writeApiJson(w, http.StatusAccepted, job)
+}
+
+func runApiJob(req apiFlashRequest) {
+	ui := &apiFlashUi{}
+
+	if req.Resume {
+		checkpoint, err := flashplan.LoadCheckpoint(device.D1.SerialNumber)
+		if err != nil {
+			endApiJob(err)
+			return
+		}
+		endApiJob(resumeFlash(checkpoint, ui))
+		return
+	}
+
+	updateApiJob(func(j *apiJob) { j.Progress = "Looking for available roms and TWRP..."; j.Busy = true })
+	api_roms_mutex.Lock()
+	cliPopulate(device.D1.Codename)
+	api_roms_mutex.Unlock()
+	updateApiJob(func(j *apiJob) { j.Busy = false })
+
+	err := selectTwrp(req.Twrp)
+	if err != nil {
+		endApiJob(err)
+		return
+	}
+
+	if req.Update {
+		endApiJob(prepareUpdate(&req.Options, ui))
+		return
+	}
+
+	err = selectRom(req.Rom, req.Build, req.Options.Gapps)
+	if err != nil {
+		endApiJob(err)
+		return
+	}
+	defaultOpengappsVersion(&req.Options)
+	if req.Options.Gapps == "OpenGapps" && req.Options.Opengapps_version == "" {
+		endApiJob(fmt.Errorf("unable to tell the OpenGapps version for %s, please provide opengapps_version", get.A1.User.Rom.Filename))
+		return
+	}
+
+	updateApiJob(func(j *apiJob) { j.Rom = get.A1.User.Rom.Filename })
+	endApiJob(prepareFlash(&req.Options, ui))
+}
+
+func apiJobUnlockCode(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.Code == "" {
+		writeApiError(w, http.StatusBadRequest, "missing code")
+		return
+	}
+
+	api_job_mutex.Lock()
+	if api_job == nil || api_job.State != "unlock_code_needed" {
+		api_job_mutex.Unlock()
+		writeApiError(w, http.StatusConflict, "no job is waiting for an unlock code")
+		return
+	}
+	api_job.State = "running"
+	job := *api_job
+	api_job_mutex.Unlock()
+
+	api_broker.publish("job", job)
+	api_unlock_code <- body.Code
+
+	writeApiJson(w, http.StatusAccepted, job)
+}
+
+func apiJobCancel(w http.ResponseWriter, r *http.Request) {
+	api_job_mutex.Lock()
+	if api_job == nil || !helpers.IsStringInSlice(api_job.State, []string{"running", "unlock_code_needed"}) {
+		api_job_mutex.Unlock()
+		writeApiError(w, http.StatusConflict, "no job is running")
+		return
+	}
+	waiting := api_job.State == "unlock_code_needed"
+	api_job.State = "cancelled"
+	api_job_mutex.Unlock()
+
+	logger.Log("API-Server: Client cancelled the flash job")
+	device.D1.Flashing = false
+	go logger.Report(map[string]string{"progress":"Cancelled"})
+
+	// The flash plan engine returns "cancelled" at its next step
+	if waiting {
+		api_unlock_code <- ""
+	}
+	updateApiJob(func(j *apiJob) {})
+
+	apiJobGet(w, r)
+}
+
+func apiEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeApiError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	c := api_broker.subscribe()
+	defer api_broker.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Start with the current state
+	writeApiEvent(w, "device", apiDeviceFromD1(false))
+	api_job_mutex.Lock()
+	if api_job != nil {
+		writeApiEvent(w, "job", *api_job)
+	}
+	api_job_mutex.Unlock()
+	flusher.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-c:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeApiEvent(w http.ResponseWriter, name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+}
+
+// Sends the device to the event stream clients whenever it changes
+func publishDeviceChanges() {
+	last := ""
+	for {
+		time.Sleep(500 * time.Millisecond)
+		a := apiDeviceFromD1(false)
+		data, err := json.Marshal(a)
+		if err != nil || string(data) == last {
+			continue
+		}
+		last = string(data)
+		api_broker.publish("device", a)
+	}
+}
+
+func cliServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	dflags := &cliDeviceFlags{
+		nosudo: fs.Bool("nosudo", false, "Do not use sudo (udev rules are set up), same as -privilege udev"),
+		privilege: fs.String("privilege", "", "How to access usb devices on linux: udev or sudo (default udev if the rules of the udev command are installed, else sudo)"),
+		verbose: fs.Bool("v", false, "Print the log to the terminal"),
+		simulate: fs.String("s", "", "Simulate a connected device of this model instead of using a real one"),
+		sim_config: fs.String("sim-config", "", "YAML file describing the simulated device, its timings and failures"),
+		log_level: fs.String("log-level", "info", "Lowest level to log: debug, info, warn or error"),
+		log_json: fs.String("log-json", "", "Additionally write the log as JSON lines to this file"),
+		log_unmasked: fs.String("log-unmasked", "", "Comma separated kinds kept readable in the local log: imei, serial, unlock_code, password, user_path"),
+	}
+	addr := fs.String("addr", "127.0.0.1:8765", "Address to listen on")
+	token := fs.String("token", "", "Require this bearer token from clients (default a random token printed at startup)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = cliSetup(dflags)
+	if err != nil {
+		return err
+	}
+
+	if *token == "" {
+		*token, err = generateApiToken()
+		if err != nil {
+			return err
+		}
+		fmt.Println("API token: " + *token)
+	}
+
+	logger.AddHook(func(line string) {
+		api_broker.publish("log", line)
+	})
+	device.D1.Observe()
+	go publishDeviceChanges()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/devices", apiHandler("GET", *token, apiDevices))
+	mux.HandleFunc("/api/devices/", apiHandler("GET", *token, apiDeviceBySerial))
+	mux.HandleFunc("/api/roms", apiHandler("GET", *token, apiRoms))
+	mux.HandleFunc("/api/lookup/codenames", apiHandler("GET", *token, apiLookupCodenames))
+	mux.HandleFunc("/api/lookup/brand", apiHandler("GET", *token, apiLookupBrand))
+	mux.HandleFunc("/api/job/unlock-code", apiHandler("POST", *token, apiJobUnlockCode))
+	mux.HandleFunc("/api/job/cancel", apiHandler("POST", *token, apiJobCancel))
+	mux.HandleFunc("/api/events", apiHandler("GET", *token, apiEvents))
+	mux.HandleFunc("/api/job", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			apiHandler("POST", *token, apiJobStart)(w, r)
+		} else {
+			apiHandler("GET", *token, apiJobGet)(w, r)
+		}
+	})
+
+	fmt.Println("API server listening on http://" + *addr + "/api/")
+	logger.Log("API-Server: Listening on " + *addr)
+
+	return http.ListenAndServe(*addr, mux)
+}
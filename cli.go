@@ -0,0 +1,869 @@
+package main
+
+import (
+	"github.com/amo13/anarchy-droid/get"
+	"github.com/amo13/anarchy-droid/device"
+	"github.com/amo13/anarchy-droid/lookup"
+	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/helpers"
+	"github.com/amo13/anarchy-droid/flashplan"
+	"github.com/amo13/anarchy-droid/device/adb"
+	"github.com/amo13/anarchy-droid/device/errs"
+	"github.com/amo13/anarchy-droid/device/sim"
+	"github.com/amo13/anarchy-droid/device/usb"
+
+	"os"
+	"fmt"
+	"errors"
+	"flag"
+	"sort"
+	"time"
+	"runtime"
+	"strings"
+	"strconv"
+	"path/filepath"
+)
+
+// Headless command line interface
+// Each subcommand runs the same procedures as the gui but prints to the terminal
+type cliCommand struct {
+	Usage string
+	Run func(args []string) error
+}
+
+// Returned by cliWaitForDevice if no device showed up in time
+var errNoDevice = errors.New("no device connected")
+
+var cliCommands = map[string]cliCommand{
+	"devices": {"devices", cliDevices},
+	"diagnostics": {"diagnostics [-o FILE]", cliDiagnostics},
+	"info": {"info [-props]", cliInfo},
+	"migrate-lookup": {"migrate-lookup [-o FILE] <dir>", cliMigrateLookup},
+	"available": {"available [-builds] <codename>", cliAvailable},
+	"download": {"download [flash options] <codename>", cliDownload},
+	"unlock": {"unlock [-unlock-code CODE]", cliUnlock},
+	"boot-recovery": {"boot-recovery [-twrp FILE]", cliBootRecovery},
+	"flash": {"flash [-rom NAME|FILE] [-gapps MicroG|MinMicroG|OpenGapps|Nothing] [-twrp FILE] [-plan FILE] [-save-plan FILE] [-resume] [flash options]", cliFlash},
+	"rescue": {"rescue [-codename CODENAME] <model>", cliRescue},
+	"serve": {"serve [-addr HOST:PORT] [-token TOKEN]", cliServe},
+	"udev": {"udev [-o FILE] [-install [-privilege pkexec|sudo]]", cliUdev},
+}
+
+// True if the first argument is a subcommand
+func isCliCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	_, found := cliCommands[args[0]]
+	return found || args[0] == "help"
+}
+
+// Runs the subcommand and returns the exit code
+func runCli(args []string) int {
+	logger.Quiet = true
+
+	command, found := cliCommands[args[0]]
+	if !found {
+		cliUsage()
+		return 0
+	}
+
+	err := command.Run(args[1:])
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "Error:", err.Error())
+		}
+		return 1
+	}
+
+	return 0
+}
+
+func cliUsage() {
+	fmt.Println("Usage: " + AppName + " <command> [options]\n\nCommands:")
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println("  " + cliCommands[name].Usage)
+	}
+	fmt.Println("\nRun " + AppName + " <command> -h for the options of a command.\nWithout a command, the graphical interface is started.")
+}
+
+// Prints the events of the flash plan engine to the terminal
+type terminalFlashUi struct {
+	last_instructions string
+}
+
+func (u *terminalFlashUi) Event(e flashplan.Event) {
+	switch e.Kind {
+	case flashplan.EventProgress:
+		if e.Text != "" {
+			fmt.Println("==> " + strings.ReplaceAll(e.Text, "\n\n", "\n    "))
+		}
+	case flashplan.EventInstructions:
+		// The same instructions are emitted repeatedly while waiting
+		if e.Text == u.last_instructions {
+			return
+		}
+		u.last_instructions = e.Text
+		fmt.Println("\n" + e.Text + "\n")
+	case flashplan.EventStepFailed:
+		fmt.Println("==> Step " + strconv.Itoa(e.Step) + " (" + e.Action + ") failed: " + e.Text)
+	}
+}
+
+func (u *terminalFlashUi) UnlockCode(brand string) (string, error) {
+	return "", fmt.Errorf("%s devices need an unlock code from the manufacturer, please provide it with -unlock-code", brand)
+}
+
+// Options shared by the subcommands talking to a device
+type cliDeviceFlags struct {
+	nosudo *bool
+	privilege *string
+	wait *int
+	codename *string
+	verbose *bool
+	simulate *string
+	sim_config *string
+	log_level *string
+	log_json *string
+	log_unmasked *string
+}
+
+func addDeviceFlags(fs *flag.FlagSet, wait int) *cliDeviceFlags {
+	return &cliDeviceFlags{
+		nosudo: fs.Bool("nosudo", false, "Do not use sudo (udev rules are set up), same as -privilege udev"),
+		privilege: fs.String("privilege", "", "How to access usb devices on linux: udev or sudo (default udev if the rules of the udev command are installed, else sudo)"),
+		wait: fs.Int("wait", wait, "Seconds to wait for a device to be connected"),
+		codename: fs.String("codename", "", "Use this codename instead of detecting it"),
+		verbose: fs.Bool("v", false, "Print the log to the terminal"),
+		simulate: fs.String("s", "", "Simulate a connected device of this model instead of using a real one"),
+		sim_config: fs.String("sim-config", "", "YAML file describing the simulated device, its timings and failures"),
+		log_level: fs.String("log-level", "info", "Lowest level to log: debug, info, warn or error"),
+		log_json: fs.String("log-json", "", "Additionally write the log as JSON lines to this file"),
+		log_unmasked: fs.String("log-unmasked", "", "Comma separated kinds kept readable in the local log: imei, serial, unlock_code, password, user_path"),
+	}
+}
+
+// Options of the flashing procedure
+type cliFlashFlags struct {
+	rom *string
+	build *string
+	twrp *string
+	gapps *string
+	opengapps_variant *string
+	opengapps_version *string
+	skip_unlock *bool
+	keep_data *bool
+	skip_flash_twrp *bool
+	no_copy_partitions *bool
+	no_reboot *bool
+	fdroid *bool
+	aurora *bool
+	playstore *bool
+	sigspoof *bool
+	gsync *bool
+	swype *bool
+	unlock_code *string
+}
+
+func addFlashFlags(fs *flag.FlagSet) *cliFlashFlags {
+	return &cliFlashFlags{
+		rom: fs.String("rom", "LineageOS", "Name of an available rom or path to a rom zip file"),
+		build: fs.String("build", "", "Date (YYYY-MM-DD) or file name of an older build of the rom"),
+		twrp: fs.String("twrp", "", "Path to a TWRP image file instead of the available one"),
+		gapps: fs.String("gapps", "MicroG", "MicroG, MinMicroG, OpenGapps or Nothing"),
+		opengapps_variant: fs.String("opengapps-variant", "pico", "OpenGapps variant"),
+		opengapps_version: fs.String("opengapps-version", "", "OpenGapps android version, defaults to the one of the rom"),
+		skip_unlock: fs.Bool("skip-unlock", false, "Assume the bootloader is already unlocked"),
+		keep_data: fs.Bool("keep-data", false, "Do not wipe the data partition (requires -skip-unlock)"),
+		skip_flash_twrp: fs.Bool("skip-flash-twrp", false, "Assume TWRP is already installed"),
+		no_copy_partitions: fs.Bool("no-copy-partitions", false, "Do not flash copy-partitions.zip on A/B devices"),
+		no_reboot: fs.Bool("no-reboot", false, "Do not reboot after the installation"),
+		fdroid: fs.Bool("fdroid", true, "Install F-Droid"),
+		aurora: fs.Bool("aurora", false, "Install Aurora Store"),
+		playstore: fs.Bool("playstore", false, "Install Google Play Store"),
+		sigspoof: fs.Bool("sigspoof", false, "Flash the signature spoofing patch"),
+		gsync: fs.Bool("gsync", false, "Install Google sync adapters"),
+		swype: fs.Bool("swype", false, "Install swype libraries"),
+		unlock_code: fs.String("unlock-code", "", "Bootloader unlock code obtained from the manufacturer"),
+	}
+}
+
+func (f *cliFlashFlags) options() *FlashOptions {
+	return &FlashOptions{
+		User_rom: strings.HasSuffix(strings.ToLower(*f.rom), ".zip"),
+		User_twrp: *f.twrp != "",
+		Skip_unlock: *f.skip_unlock,
+		Skip_wipe_data: *f.skip_unlock && *f.keep_data,
+		Skip_flash_twrp: *f.skip_flash_twrp,
+		Copy_partitions: !*f.no_copy_partitions && device.D1.IsAB,
+		Reboot_after_installation: !*f.no_reboot,
+		Gapps: *f.gapps,
+		Opengapps_version: *f.opengapps_version,
+		Opengapps_variant: *f.opengapps_variant,
+		Fdroid: *f.fdroid,
+		Aurora: *f.aurora,
+		Playstore: *f.playstore,
+		Sigspoof: *f.sigspoof,
+		Gsync: *f.gsync,
+		Swype: *f.swype,
+		Unlock_code: *f.unlock_code,
+	}
+}
+
+// Downloads the binaries and restarts the adb server
+func cliSetup(flags *cliDeviceFlags) error {
+	logger.Quiet = !*flags.verbose
+
+	level, err := logger.ParseLevel(*flags.log_level)
+	if err != nil {
+		return err
+	}
+	logger.MinLevel = level
+	err = logger.AllowLocal(strings.Split(*flags.log_unmasked, ",")...)
+	if err != nil {
+		return err
+	}
+	if *flags.log_json != "" {
+		err = logger.EnableJSON(*flags.log_json)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *flags.simulate != "" || *flags.sim_config != "" {
+		return simulateDevice(*flags.simulate, *flags.sim_config)
+	}
+
+	if runtime.GOOS == "linux" {
+		privilege := *flags.privilege
+		if *flags.nosudo {
+			privilege = "udev"
+		} else if privilege == "" && udevRulesInstalled() {
+			privilege = "udev"
+		} else if privilege == "" {
+			privilege = "sudo"
+		}
+		// sudo asks for the password on the terminal
+		p, err := helpers.DevicePrivilege(privilege, "")
+		if err != nil {
+			return err
+		}
+		err = p.Check()
+		if err != nil {
+			return err
+		}
+		helpers.Privileged = p
+	}
+
+	err = get.Binaries()
+	if err != nil {
+		return fmt.Errorf("unable to get the binaries: %s", err.Error())
+	}
+
+	err = adb.KillServer()
+	if err != nil && !errors.Is(err, errs.ErrConnectionRefused) {
+		return err
+	}
+
+	return adb.StartServer()
+}
+
+// Replaces the adb, fastboot and heimdall binaries by a simulated phone
+// so the whole procedure can be rehearsed without a device
+func simulateDevice(model string, config_file string) error {
+	config := sim.NewConfig(model)
+	if config_file != "" {
+		var err error
+		config, err = sim.LoadConfig(config_file)
+		if err != nil {
+			return err
+		}
+		if model != "" {
+			config.Model = model
+		}
+	}
+	if config.Model == "" {
+		return fmt.Errorf("the simulated device needs a model")
+	}
+
+	sim.Install(sim.NewPhone(config))
+	return nil
+}
+
+// Starts observing the device connection and waits until it is recognized
+func cliWaitForDevice(flags *cliDeviceFlags) error {
+	device.D1.Observe()
+
+	fmt.Println("Waiting for a device...")
+	unauthorized_shown := false
+	usb_shown := ""
+	deadline := time.Now().Add(time.Duration(*flags.wait) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(1 * time.Second)
+
+		if device.D1.State == "unauthorized" && !unauthorized_shown {
+			fmt.Println("Device unauthorized! Please allow USB debugging on your device screen.")
+			unauthorized_shown = true
+		}
+		if device.IsUsbOnly(device.D1.State) && device.D1.State != usb_shown {
+			fmt.Println(usb.Instructions[device.D1.State])
+			usb_shown = device.D1.State
+		}
+		if !helpers.IsStringInSlice(device.D1.State, []string{"android", "recovery", "fastboot", "heimdall"}) || device.D1.Scanning {
+			continue
+		}
+
+		if *flags.codename != "" && device.D1.Codename != *flags.codename {
+			device.D1.Codename = *flags.codename
+			device.D1.Codename_ambiguous = false
+			device.D1.Brand = ""
+			device.D1.ReadMissingProps()
+		}
+
+		if device.D1.Codename_ambiguous {
+			candidates, err := device.D1.CodenameCandidates()
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("the model %s matches several devices, please choose one with -codename: %s", device.D1.Model, strings.Join(candidates, ", "))
+		}
+
+		if device.D1.Codename != "" {
+			return nil
+		}
+	}
+
+	if device.D1.State == "disconnected" {
+		return errNoDevice
+	}
+	if device.IsUsbOnly(device.D1.State) {
+		return fmt.Errorf("the device is connected in %s mode and cannot be used", device.D1.State)
+	}
+	return fmt.Errorf("unable to recognize the connected device")
+}
+
+// Loads the available roms and TWRP for the codename
+func cliPopulate(codename string) {
+	fmt.Println("Looking for available roms and TWRP...")
+	get.A1 = get.NewAvailable()
+	err := get.A1.Populate(codename)
+	if err != nil {
+		logger.LogError("unable to populate the list of available roms:", err)
+	}
+}
+
+// Sets get.A1.User.Rom from a rom name or zip file and an optional build date or file name
+func selectRom(romname string, build_name string, gapps string) error {
+	if strings.HasSuffix(strings.ToLower(romname), ".zip") {
+		_, err := os.Stat(romname)
+		if err != nil {
+			return err
+		}
+
+		get.A1.User.Rom = &get.Item{}
+		get.A1.User.Rom.Href = romname
+		get.A1.User.Rom.Filename = filepath.Base(romname)
+		romname, androidversion, err := get.GuessRomNameAndAndroidVersion(get.A1.User.Rom.Filename)
+		if err != nil {
+			logger.LogError("Unable to guess rom name and android version of " + get.A1.User.Rom.Filename + ":", err)
+		} else {
+			get.A1.User.Rom.Name = romname
+			get.A1.User.Rom.Android_version = androidversion
+		}
+		return nil
+	}
+
+	// Same choice as in the settings tab
+	var rom *get.Item
+	if gapps == "MicroG" && strings.EqualFold(romname, "LineageOS") && get.A1.Upstream.Rom["LineageOSMicroG"] != nil {
+		rom = get.A1.Upstream.Rom["LineageOSMicroG"]
+	}
+	for _, roms := range []map[string]*get.Item{get.A1.Upstream.Rom, get.A1.Archive.Rom} {
+		for name, item := range roms {
+			if rom == nil && strings.EqualFold(name, romname) {
+				rom = item
+			}
+		}
+	}
+	if rom == nil || rom.Href == "" {
+		return fmt.Errorf("%s is not available, available are: %s", romname, strings.Join(append(get.A1.Upstream.Romlist, get.A1.Archive.Romlist...), ", "))
+	}
+
+	if build_name != "" {
+		var build *get.Item
+		for _, b := range rom.Builds {
+			if b.Date == build_name || b.Filename == build_name {
+				build = b
+				break
+			}
+		}
+		if build == nil {
+			return fmt.Errorf("build %s of %s not found", build_name, rom.Name)
+		}
+		if build.Href != rom.Href {
+			rom = build
+		}
+	}
+
+	get.A1.User.Rom = rom
+	return nil
+}
+
+// Sets get.A1.User.Twrp from a TWRP image file or the available TWRP
+func selectTwrp(twrp_file string) error {
+	if twrp_file != "" {
+		_, err := os.Stat(twrp_file)
+		if err != nil {
+			return err
+		}
+		get.A1.User.Twrp.Img = &get.Item{Href: twrp_file, Filename: filepath.Base(twrp_file)}
+		return nil
+	}
+
+	if !get.A1.SelectDefaultTwrp() {
+		return fmt.Errorf("no TWRP available for %s", device.D1.Codename)
+	}
+
+	return nil
+}
+
+func cliDevices(args []string) error {
+	fs := flag.NewFlagSet("devices", flag.ContinueOnError)
+	dflags := addDeviceFlags(fs, 5)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = cliSetup(dflags)
+	if err != nil {
+		return err
+	}
+
+	err = cliWaitForDevice(dflags)
+	if errors.Is(err, errNoDevice) {
+		fmt.Println("No device connected")
+		return nil
+	}
+
+	fmt.Printf("%s\t%s\t%s\t%s\t%s\n", device.D1.SerialNumber, device.D1.State, device.D1.Brand, device.D1.Model, device.D1.Codename)
+	return err
+}
+
+// Exports the diagnostic bundle, also without a connected device
+func cliDiagnostics(args []string) error {
+	fs := flag.NewFlagSet("diagnostics", flag.ContinueOnError)
+	dflags := addDeviceFlags(fs, 10)
+	output := fs.String("o", "", "Write the zip file here instead of the log folder")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = cliSetup(dflags)
+	if err != nil {
+		return err
+	}
+
+	err = cliWaitForDevice(dflags)
+	if err != nil {
+		fmt.Println("Exporting without device information:", err.Error())
+	}
+
+	zip_path := *output
+	if zip_path == "" {
+		zip_path = diagnosticsFilename()
+	}
+	names, err := exportDiagnostics(zip_path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Diagnostics written to " + zip_path + ": " + strings.Join(names, ", "))
+	return nil
+}
+
+func cliInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	dflags := addDeviceFlags(fs, 60)
+	props := fs.Bool("props", false, "Also print all ADB props and fastboot vars")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = cliSetup(dflags)
+	if err != nil {
+		return err
+	}
+	err = cliWaitForDevice(dflags)
+	if err != nil {
+		return err
+	}
+
+	d := device.D1
+	fmt.Println("State:        ", d.State)
+	fmt.Println("Brand:        ", d.Brand)
+	fmt.Println("Model:        ", d.Model)
+	fmt.Println("Codename:     ", d.Codename)
+	fmt.Println("Name:         ", d.Name)
+	fmt.Println("Architecture: ", d.Arch)
+	fmt.Println("Serial number:", d.SerialNumber)
+	fmt.Println("A/B device:   ", d.IsAB)
+	fmt.Println("Unlocked:     ", d.IsUnlocked)
+	fmt.Println("Supported:    ", d.IsSupported)
+	if d.InstalledRom != "" {
+		fmt.Println("Installed rom:", d.InstalledRom, d.InstalledRomVersion)
+	}
+	if d.InstalledGapps != "" {
+		fmt.Println("Installed google services:", d.InstalledGapps)
+	}
+	if d.TwrpVersionConnected != "" {
+		fmt.Println("TWRP version: ", d.TwrpVersionConnected)
+	}
+
+	if *props {
+		for _, m := range []map[string]string{d.AdbProps, d.FastbootVars} {
+			keys := helpers.KeysOfMap(m)
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Println(key + ": " + m[key])
+			}
+		}
+	}
+
+	return nil
+}
+
+func cliAvailable(args []string) error {
+	fs := flag.NewFlagSet("available", flag.ContinueOnError)
+	builds := fs.Bool("builds", false, "List all available builds of each rom")
+	verbose := fs.Bool("v", false, "Print the log to the terminal")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: available [-builds] <codename>")
+	}
+	logger.Quiet = !*verbose
+
+	cliPopulate(fs.Arg(0))
+	fmt.Println(get.A1.String())
+
+	if *builds {
+		for _, romname := range get.A1.Upstream.Romlist {
+			// LineageOS stands for LineageOSMicroG as well, see Populate
+			items := []string{romname}
+			if romname == "LineageOS" {
+				items = append(items, "LineageOSMicroG")
+			}
+			for _, itemname := range items {
+				item := get.A1.Upstream.Rom[itemname]
+				if item == nil {
+					continue
+				}
+				fmt.Println(itemname + ":")
+				for _, build := range item.Builds {
+					fmt.Println("  " + build.Date + "  " + build.Filename)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func cliDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	fflags := addFlashFlags(fs)
+	arch := fs.String("arch", "arm64", "CPU architecture for OpenGapps")
+	verbose := fs.Bool("v", false, "Print the log to the terminal")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: download [flash options] <codename>")
+	}
+	logger.Quiet = !*verbose
+
+	device.D1.Codename = fs.Arg(0)
+	device.D1.Arch = *arch
+	cliPopulate(device.D1.Codename)
+
+	err = selectRom(*fflags.rom, *fflags.build, *fflags.gapps)
+	if err != nil {
+		return err
+	}
+	err = selectTwrp(*fflags.twrp)
+	if err != nil {
+		return err
+	}
+
+	Opts = fflags.options()
+	defaultOpengappsVersion(Opts)
+	plan := flashplan.NewPlan(device.D1.Codename)
+	plan.Files, err = planFiles()
+	if err != nil {
+		return err
+	}
+	err = flashplan.NewEngine(plan, (&terminalFlashUi{}).Event, nil).Download()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range plan.FileKeys() {
+		fmt.Println(key + ": " + plan.Files[key].Path)
+	}
+
+	return nil
+}
+
+// Uses the android version of the chosen rom if no OpenGapps version is given
+func defaultOpengappsVersion(opts *FlashOptions) {
+	if opts.Gapps == "OpenGapps" && opts.Opengapps_version == "" {
+		version, err := formatToOpenGappsAndroidVersion(get.A1.User.Rom.Android_version)
+		if err == nil {
+			opts.Opengapps_version = version
+		}
+	}
+}
+
+func cliUnlock(args []string) error {
+	fs := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	dflags := addDeviceFlags(fs, 60)
+	unlock_code := fs.String("unlock-code", "", "Bootloader unlock code obtained from the manufacturer")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = cliSetup(dflags)
+	if err != nil {
+		return err
+	}
+	err = cliWaitForDevice(dflags)
+	if err != nil {
+		return err
+	}
+
+	if device.D1.IsUnlocked {
+		fmt.Println("The bootloader is already unlocked.")
+		return nil
+	}
+
+	Opts = &FlashOptions{Unlock_code: *unlock_code}
+	Ui = &terminalFlashUi{}
+	plan := flashplan.NewPlan(device.D1.Codename)
+	plan.Add(&flashplan.Step{
+		Action: flashplan.ActionUnlock,
+		Description: "Unlocking the bootloader, follow the instructions on your device screen if needed...",
+		Unlock_code: *unlock_code,
+	})
+	err = runPlan(plan)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Bootloader unlocked successfully!")
+	return nil
+}
+
+func cliBootRecovery(args []string) error {
+	fs := flag.NewFlagSet("boot-recovery", flag.ContinueOnError)
+	dflags := addDeviceFlags(fs, 60)
+	twrp := fs.String("twrp", "", "Path to a TWRP image file instead of the available one")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = cliSetup(dflags)
+	if err != nil {
+		return err
+	}
+	err = cliWaitForDevice(dflags)
+	if err != nil {
+		return err
+	}
+
+	if *twrp == "" {
+		cliPopulate(device.D1.Codename)
+	}
+	err = selectTwrp(*twrp)
+	if err != nil {
+		return err
+	}
+
+	Opts = &FlashOptions{User_twrp: *twrp != ""}
+	Ui = &terminalFlashUi{}
+	plan := flashplan.NewPlan(device.D1.Codename)
+	plan.Files["twrp_img"] = twrpImgFile()
+	plan.Add(&flashplan.Step{
+		Action: flashplan.ActionBootRecovery,
+		Description: "Booting TWRP...",
+		File: "twrp_img",
+	})
+	plan.Final_instructions = "TWRP is running."
+
+	return runPlan(plan)
+}
+
+func cliFlash(args []string) error {
+	fs := flag.NewFlagSet("flash", flag.ContinueOnError)
+	dflags := addDeviceFlags(fs, 60)
+	fflags := addFlashFlags(fs)
+	plan_file := fs.String("plan", "", "Run a saved flash plan instead of building one from the options")
+	save_plan := fs.String("save-plan", "", "Only save the flash plan to this file without flashing")
+	resume := fs.Bool("resume", false, "Resume the interrupted installation of the connected device")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = cliSetup(dflags)
+	if err != nil {
+		return err
+	}
+	err = cliWaitForDevice(dflags)
+	if err != nil {
+		return err
+	}
+	fmt.Println(device.D1.Model + " (" + device.D1.Codename + ") connected")
+
+	if *resume {
+		checkpoint, err := flashplan.LoadCheckpoint(device.D1.SerialNumber)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("no interrupted installation found for %s", device.D1.SerialNumber)
+			}
+			return err
+		}
+		fmt.Println("Resuming: " + checkpoint.String())
+		return resumeFlash(checkpoint, &terminalFlashUi{})
+	}
+	if flashplan.HasCheckpoint(device.D1.SerialNumber) {
+		fmt.Println("An earlier installation on this device has been interrupted, run with -resume to continue it.")
+	}
+
+	if *plan_file != "" {
+		plan, err := flashplan.Load(*plan_file)
+		if err != nil {
+			return err
+		}
+		Ui = &terminalFlashUi{}
+		fmt.Print(plan.String())
+		return runPlan(plan)
+	}
+
+	if !device.D1.IsSupported {
+		return fmt.Errorf("%s does not support this device", AppName)
+	}
+	if !device.D1.IsUnlocked && !device.D1.IsBrandUnlockable && !*fflags.skip_unlock {
+		return fmt.Errorf("unable to unlock %s devices, unlock the bootloader yourself and use -skip-unlock", device.D1.Brand)
+	}
+
+	cliPopulate(device.D1.Codename)
+	err = selectRom(*fflags.rom, *fflags.build, *fflags.gapps)
+	if err != nil {
+		return err
+	}
+	err = selectTwrp(*fflags.twrp)
+	if err != nil {
+		return err
+	}
+
+	Opts = fflags.options()
+	defaultOpengappsVersion(Opts)
+	if Opts.Gapps == "OpenGapps" && Opts.Opengapps_version == "" {
+		return fmt.Errorf("unable to tell the OpenGapps version for %s, please provide it with -opengapps-version", get.A1.User.Rom.Filename)
+	}
+
+	if *save_plan != "" {
+		plan, err := buildFlashPlan()
+		if err != nil {
+			return err
+		}
+		fmt.Print(plan.String())
+		return plan.Save(*save_plan)
+	}
+
+	fmt.Println("Installing " + get.A1.User.Rom.Filename)
+	return prepareFlash(Opts, &terminalFlashUi{})
+}
+
+func cliRescue(args []string) error {
+	fs := flag.NewFlagSet("rescue", flag.ContinueOnError)
+	dflags := addDeviceFlags(fs, 60)
+	twrp := fs.String("twrp", "", "Path to a TWRP image file instead of the available one")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	codename := *dflags.codename
+	if codename == "" {
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: rescue [-codename CODENAME] <model>")
+		}
+		codename, err = lookup.ModelToCodename(fs.Arg(0))
+		if err != nil {
+			if errors.Is(err, lookup.ErrAmbiguous) {
+				candidates, _ := lookup.ModelToCodenameCandidates(fs.Arg(0))
+				return fmt.Errorf("the model %s matches several devices, please choose one with -codename: %s", fs.Arg(0), strings.Join(candidates, ", "))
+			}
+			return err
+		}
+	}
+
+	err = cliSetup(dflags)
+	if err != nil {
+		return err
+	}
+	device.D1.Observe()
+
+	if *twrp == "" {
+		cliPopulate(codename)
+	}
+	device.D1.Codename = codename
+	err = selectTwrp(*twrp)
+	if err != nil {
+		return err
+	}
+
+	return bootloopRescue(codename, &FlashOptions{User_twrp: *twrp != ""}, &terminalFlashUi{})
+}
+
+// Converts the flat lookup files used before devices.yml into device profiles
+func cliMigrateLookup(args []string) error {
+	fs := flag.NewFlagSet("migrate-lookup", flag.ContinueOnError)
+	output := fs.String("o", "", "Write the profiles to this file instead of printing them")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("please give the directory containing codenames.yml, brands.yml and the other lookup files")
+	}
+
+	db, err := lookup.MigrateTables(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	content, err := db.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if *output != "" {
+		return os.WriteFile(*output, content, 0644)
+	}
+	fmt.Print(string(content))
+	return nil
+}
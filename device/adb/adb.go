@@ -1,57 +1,66 @@
 package adb
 
 import (
+	"time"
 	"strings"
 	"strconv"
 	"runtime"
 	"regexp"
+	"errors"
 	"fmt"
 
 	"github.com/amo13/anarchy-droid/helpers"
 	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/device/errs"
 )
 
-var Sudopw string = ""
-var Nosudo bool = false
-var Simulation bool = false
-
 func adb_command() string {
 	switch runtime.GOOS {
 	case "windows":
 		return "bin\\platform-tools\\adb.exe"
 	case "darwin":
 		return "bin/platform-tools/adb"
-	default:	// linux
-		if Nosudo {
-			return "bin/platform-tools/adb"
-		} else if Sudopw == "" {
-			return "sudo bin/platform-tools/adb"
-		} else {
-			return "printf " + Sudopw + " | sudo -S bin/platform-tools/adb"
-		}
+	default:	// linux, started according to helpers.Privileged
+		return "bin/platform-tools/adb"
 	}
 }
 
+// Executes the adb commands, replaced by a simulated device for dry-runs
+type Runner interface {
+	Run(args ...string) (stdout string, stderr string)
+}
+
+var Backend Runner = binary{}
+
+// Runs the adb binary shipped with the app
+type binary struct{}
+
+func (binary) Run(args ...string) (stdout string, stderr string) {
+	return helpers.CmdPrivileged(adb_command(), args...)
+}
+
 // Returns trimmed stdout of a given adb command
 func Cmd(args ...string) (stdout string, err error) {
-	stdout, stderr := helpers.Cmd(adb_command(), args...)
+	start := time.Now()
+	stdout, stderr := Backend.Run(args...)
+	logger.With(logger.Fields{"command": "adb " + strings.Join(args, " "), "duration": time.Since(start).String()}).Debug("Command finished")
 	if stderr != "" {
 		if strings.Contains(stderr, "no devices/emulators found") {
-			return "", fmt.Errorf("disconnected")
+			return "", errs.Command("adb", args, stderr, errs.ErrDisconnected)
 		} else if strings.Contains(stderr, "device offline") {
-			return "", fmt.Errorf("disconnected")
+			return "", errs.Command("adb", args, stderr, errs.ErrDisconnected)
 		} else if strings.Contains(stderr, "device unauthorized") {
-			return "", fmt.Errorf("unauthorized")
+			return "", errs.Command("adb", args, stderr, errs.ErrUnauthorized)
 		} else if strings.Contains(stderr, "device still authorizing") {
-			return "", fmt.Errorf("unauthorized")
+			return "", errs.Command("adb", args, stderr, errs.ErrUnauthorized)
 		} else if len(args) > 0 && args[0] == "kill-server" && (strings.Contains(stderr, "Connection refused") || strings.Contains(stderr, "cannot connect to daemon")) {
-			return "", fmt.Errorf("connection refused")
+			return "", errs.Command("adb", args, stderr, errs.ErrConnectionRefused)
 		} else if strings.Contains(stderr, "daemon not running; starting now") {
 			return stdout, nil
 		} else if strings.Contains(stderr, "[sudo]") {
 			logger.Log("Stderr contains [sudo]")
 			if strings.Contains(stderr, "Connection refused") && len(args) > 0 && args[0] == "kill-server" {
-				return "", fmt.Errorf("connection refused")
+				return "", errs.Command("adb", args, stderr, errs.ErrConnectionRefused)
 			} else {
 				logger.Log("Bug: sudo password prompt instead of command output. Killing adb server and retrying " + strings.Join(args, " "))
 				return "", KillServer()
@@ -59,9 +68,9 @@ func Cmd(args ...string) (stdout string, err error) {
 		} else if strings.Contains(stderr, "adb: failed to read command: Success") || strings.Contains(stderr, "adb: failed to read command: No error") {
 			return stdout, nil
 		} else if strings.Contains(stderr, "Service") && strings.Contains(stderr, "does not exist") {
-			return stdout, fmt.Errorf(stderr)
+			return stdout, errs.Command("adb", args, stdout + "\n" + stderr, errors.New(stderr))
 		} else if strings.Contains(stdout + " " + stderr, "No such file or directory") {
-			return strings.Trim(strings.Trim(stdout + " " + stderr, "\n"), " "), fmt.Errorf(strings.Join(args, " ") + "failed: " + stdout + " " + stderr)
+			return strings.Trim(strings.Trim(stdout + " " + stderr, "\n"), " "), errs.Command("adb", args, stdout + "\n" + stderr, errors.New(strings.Join(args, " ") + "failed: " + stdout + " " + stderr))
 		}
 		
 		logger.LogError("ADB command " + strings.Join(args, " ") + " gave an unexpected error:", fmt.Errorf("stderr: %s\nstdout: %s", stderr, stdout))
@@ -73,12 +82,13 @@ func Cmd(args ...string) (stdout string, err error) {
 // Check for disconnection error or suddenly unauthorized error
 func unavailable(err error) bool {
 	if err != nil {
-		if err.Error() == "disconnected" || err.Error() == "unauthorized" {
-			return true
-		} else if strings.Contains(err.Error(), "no devices/emulators found") {
+		if errs.IsUnavailable(err) {
 			return true
 		} else {
 			logger.LogError("Unknown ADB error:", err)
+			if details := errs.Details(err); details != "" {
+				logger.Debug(details)
+			}
 		}
 	}
 
@@ -96,12 +106,8 @@ func KillServer() error {
 }
 
 func State() string {
-	if Simulation {
-		return "simulation"
-	}
-	
-	// Call helpers.Cmd because we need stdout and stderr
-	stdout, stderr := helpers.Cmd(adb_command(), "get-state")
+	// Call the backend directly because we need stdout and stderr
+	stdout, stderr := Backend.Run("get-state")
 
 	if strings.Contains(stderr, "error: no devices/emulators found") {
 		return "disconnected"
@@ -161,7 +167,7 @@ func IsReady() bool {
 
 func IsBootComplete() (bool, error) {
 	// Do not query the full props map before booting is completed
-	bootcomplete, stderr := helpers.Cmd(adb_command(), "shell", "getprop", "dev.bootcomplete")
+	bootcomplete, stderr := Backend.Run("shell", "getprop", "dev.bootcomplete")
 	if stderr != "" {
 		logger.Log("Error executing adb shell getprop dev.bootcomplete: " + stderr)
 		return true, fmt.Errorf(stderr)
@@ -171,7 +177,7 @@ func IsBootComplete() (bool, error) {
 		return true, nil
 	} else {
 		// Do not query the full props map before booting is completed
-		boot_completed, stderr := helpers.Cmd(adb_command(), "shell", "getprop", "sys.boot_completed")
+		boot_completed, stderr := Backend.Run("shell", "getprop", "sys.boot_completed")
 		if stderr != "" {
 			logger.Log("Error executing adb shell getprop sys.boot_completed: " + stderr)
 			return true, fmt.Errorf(stderr)
@@ -191,7 +197,7 @@ func IsBooting() (bool, error) {
 		return false, err
 	}
 
-	stdout, _ := helpers.Cmd(adb_command(), "get-state")
+	stdout, _ := Backend.Run("get-state")
 	if strings.HasPrefix(stdout, "device") && !complete {
 		return true, nil
 	} else {
@@ -323,6 +329,7 @@ func Imei() (string, error) {
 		imei = strings.Join(re.FindAllString(s, -1), "")
 	}
 
+	logger.AddSecret(logger.SecretImei, imei)
 	return imei, nil
 }
 
@@ -346,6 +353,7 @@ func SerialNumber() (string, error) {
 		sn = props["ro.boot.serialno"]
 	}
 
+	logger.AddSecret(logger.SecretSerial, sn)
 	return sn, nil
 }
 
@@ -483,6 +491,72 @@ func IsCustomRomFromMap(props map[string]string) bool {
 	}
 }
 
+// Props set by custom roms to announce their name and version
+// Checked in order, so derivatives come before the roms they are based on
+var RomVersionProps = []struct{
+	Prop string
+	Rom string
+}{
+	{"ro.crdroid.version", "crDroid"},
+	{"ro.rr.version", "ResurrectionRemix"},
+	{"ro.carbon.version", "Carbonrom"},
+	{"ro.omni.version", "Omnirom"},
+	{"ro.lineage.version", "LineageOS"},
+	{"ro.cm.version", "CyanogenMod"},
+	{"ro.modversion", ""},	// Generic, the rom name has to be guessed from the version
+}
+
+// Returns the name and version string of the installed custom rom
+// The name is empty if only a generic version prop is set
+func InstalledRomFromPropMap(props map[string]string) (name string, version string) {
+	for _, p := range RomVersionProps {
+		if props[p.Prop] != "" {
+			name, version = p.Rom, props[p.Prop]
+			break
+		}
+	}
+
+	// LineageOS for MicroG builds are tagged with their own release type
+	if name == "LineageOS" && strings.ToUpper(props["ro.lineage.releasetype"]) == "MICROG" {
+		name = "LineageOSMicroG"
+	}
+
+	return name, version
+}
+
+// Returns the package names installed on the device
+func InstalledPackages() ([]string, error) {
+	stdout, err := Cmd("shell", "pm", "list", "packages")
+	if unavailable(err) {
+		return []string{}, err
+	}
+
+	packages := make([]string, 0)
+	for _, line := range helpers.StringToLinesSlice(stdout) {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "package:") {
+			packages = append(packages, strings.TrimPrefix(line, "package:"))
+		}
+	}
+
+	return packages, nil
+}
+
+// Tells which kind of google services are installed: "MicroG", "OpenGapps" or "Nothing"
+func InstalledGappsFromPackages(packages []string) string {
+	if !helpers.IsStringInSlice("com.google.android.gms", packages) {
+		return "Nothing"
+	}
+
+	for _, p := range packages {
+		if strings.HasPrefix(p, "org.microg.") {
+			return "MicroG"
+		}
+	}
+
+	return "OpenGapps"
+}
+
 func Push(local string, remote string) error {
 	_, err := Cmd("push", local, remote)
 	return err
@@ -0,0 +1,221 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+	"errors"
+	"strings"
+	"strconv"
+
+	"github.com/amo13/anarchy-droid/lookup"
+	"github.com/amo13/anarchy-droid/logger"
+)
+
+// Detects the codename from several props and vars and the lookup tables
+// Each signal supports a codename with a weight, the codenames are ranked by
+// the combined weights and lose confidence if other codenames are supported as well
+
+// Below this confidence the user is asked to select the model
+var CodenameConfidenceThreshold = 0.5
+
+type CodenameGuess struct {
+	Codename string
+	// Between 0 and 1
+	Confidence float64
+	Reasons []string
+}
+
+// Props and vars holding the codename and how much they are trusted
+var codename_signals = []struct {
+	source string
+	key string
+	weight float64
+}{
+	{"adb", "ro.product.device", 0.5},
+	{"adb", "ro.product.vendor.device", 0.5},
+	{"adb", "ro.build.product", 0.4},
+	// Often the chipset, e.g. qcom
+	{"adb", "ro.boot.hardware", 0.2},
+	{"adb", "ro.product.name", 0.2},
+	{"fastboot", "product", 0.5},
+}
+
+// Weights of the model looked up in the tables
+const (
+	weight_model = 0.6
+	// Shared by all matching codenames
+	weight_model_ambiguous = 0.6
+)
+
+// Returns the codename candidates, the most likely first
+func DetectCodename(model string, props map[string]string, vars map[string]string) []CodenameGuess {
+	// The user already chose the codename of this model
+	if model != "" {
+		codename, err := lookup.ModelOverride(model)
+		if err != nil {
+			logger.LogError("Unable to read the overrides in " + lookup.OverridesPath + ":", err)
+		} else if codename != "" {
+			return []CodenameGuess{{Codename: codename, Confidence: 1, Reasons: []string{"model " + model + " was saved as " + codename + " in " + lookup.OverridesPath}}}
+		}
+	}
+
+	// Codename to the weight of each distinct value supporting it
+	evidence := make(map[string]map[string]float64)
+	reasons := make(map[string][]string)
+	known := make(map[string]string)
+	// Signals with the same key count once, e.g. the same value in several props
+	support := func(value string, key string, weight float64, reason string) {
+		codename, how := normalizeCodename(value)
+		if codename == "" {
+			return
+		}
+		if evidence[codename] == nil {
+			evidence[codename] = make(map[string]float64)
+			known[codename] = how
+		}
+		if weight > evidence[codename][key] {
+			evidence[codename][key] = weight
+		}
+		reasons[codename] = append(reasons[codename], reason)
+	}
+
+	for _, s := range codename_signals {
+		m := props
+		if s.source == "fastboot" {
+			m = vars
+		}
+		if m[s.key] != "" {
+			support(m[s.key], strings.ToLower(m[s.key]), s.weight, s.source + " " + s.key + " is " + m[s.key])
+		}
+	}
+
+	if model != "" {
+		codename, err := lookup.ModelToCodename(model)
+		if err == nil && codename != "" {
+			support(codename, "model", weight_model, "model " + model + " is " + codename + " in the lookup tables")
+		} else if err != nil && errors.Is(err, lookup.ErrAmbiguous) {
+			candidates, err := lookup.ModelToCodenameCandidates(model)
+			if err != nil {
+				logger.LogError("Error retrieving codename candidates from model " + model, err)
+			}
+			for _, c := range candidates {
+				support(c, "model", weight_model_ambiguous / float64(len(candidates)), fmt.Sprintf("model %s matches %d devices in the lookup tables", model, len(candidates)))
+			}
+		} else if err != nil {
+			logger.Log("Unable to lookup model " + model + " to codename:", err.Error())
+		}
+	}
+
+	guesses := []CodenameGuess{}
+	for codename, values := range evidence {
+		// Probability that at least one signal is right
+		doubt := 1.0
+		for _, weight := range values {
+			doubt = doubt * (1 - weight)
+		}
+		confidence := 1 - doubt
+		if known[codename] == "" {
+			confidence = confidence / 2
+			reasons[codename] = append(reasons[codename], "unknown to the lookup tables")
+		} else {
+			reasons[codename] = append(reasons[codename], "known to the " + known[codename])
+		}
+		guesses = append(guesses, CodenameGuess{Codename: codename, Confidence: confidence, Reasons: reasons[codename]})
+	}
+
+	// Competing codenames make each other less likely
+	raw := make([]float64, len(guesses))
+	for i := range guesses {
+		raw[i] = guesses[i].Confidence
+	}
+	for i := range guesses {
+		competitor := 0.0
+		for j := range raw {
+			if j != i && raw[j] > competitor {
+				competitor = raw[j]
+			}
+		}
+		guesses[i].Confidence = raw[i] * (1 - competitor / 2)
+	}
+
+	sort.Slice(guesses, func(i, j int) bool {
+		if guesses[i].Confidence != guesses[j].Confidence {
+			return guesses[i].Confidence > guesses[j].Confidence
+		}
+		return guesses[i].Codename < guesses[j].Codename
+	})
+
+	return guesses
+}
+
+// Removes prefixes like lineage_ and suffixes like _ds, as long as the result is known
+// Returns the codename and the table knowing it, empty if unknown
+func normalizeCodename(value string) (string, string) {
+	value = strings.TrimSpace(value)
+	for _, prefix := range lookup.CodenamePrefixes {
+		value = strings.TrimPrefix(value, prefix)
+	}
+	if value == "" {
+		return "", ""
+	}
+
+	variants := []string{value}
+	for _, suffix := range lookup.CodenameSuffixes {
+		if strings.HasSuffix(strings.ToLower(value), suffix) {
+			variants = append(variants, value[:len(value)-len(suffix)])
+		}
+	}
+
+	for _, v := range variants {
+		profile, err := lookup.Profile(v)
+		if err == nil {
+			return profile.Codename, "device profiles"
+		}
+	}
+	for _, v := range variants {
+		is_codename, err := lookup.IsCodename(v)
+		if err == nil && is_codename {
+			return strings.ToLower(v), "lookup CSV"
+		}
+	}
+
+	return variants[len(variants)-1], ""
+}
+
+// Detects the codename and asks for the model if the best guess is not confident enough
+func (d *Device) detectCodename() {
+	d.Codename_guesses = DetectCodename(d.Model, d.AdbProps, d.FastbootVars)
+	if len(d.Codename_guesses) == 0 {
+		return
+	}
+
+	best := d.Codename_guesses[0]
+	logger.With(logger.Fields{"codename": best.Codename, "confidence": fmt.Sprintf("%.2f", best.Confidence), "candidates": strconv.Itoa(len(d.Codename_guesses))}).Debug("Detected codename: " + strings.Join(best.Reasons, ", "))
+
+	// Only worth asking if there are models to choose from
+	if best.Confidence < CodenameConfidenceThreshold && len(d.Codename_guesses) > 1 {
+		codenames, _ := d.CodenameCandidates()
+		models, err := lookup.CodenamesToModels(codenames)
+		if err == nil && len(models) > 1 {
+			d.Codename_ambiguous = true
+			return
+		}
+	}
+
+	d.Codename = best.Codename
+	d.Codename_confidence = best.Confidence
+	d.Codename_ambiguous = false
+}
+
+// Codenames to choose from when the detection was not confident enough
+func (d *Device) CodenameCandidates() ([]string, error) {
+	if len(d.Codename_guesses) > 0 {
+		codenames := []string{}
+		for _, g := range d.Codename_guesses {
+			codenames = append(codenames, g.Codename)
+		}
+		return codenames, nil
+	}
+
+	return lookup.ModelToCodenameCandidates(d.Model)
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"fmt"
 	"time"
+	"errors"
 	"strings"
 	"strconv"
 	"runtime"
@@ -13,13 +14,15 @@ import (
 	"github.com/amo13/anarchy-droid/lookup"
 	"github.com/amo13/anarchy-droid/helpers"
 	"github.com/amo13/anarchy-droid/device/adb"
+	"github.com/amo13/anarchy-droid/device/errs"
+	"github.com/amo13/anarchy-droid/device/quirks"
 	"github.com/amo13/anarchy-droid/device/twrp"
+	"github.com/amo13/anarchy-droid/device/usb"
 	"github.com/amo13/anarchy-droid/device/fastboot"
 	"github.com/amo13/anarchy-droid/device/heimdall"
 )
 
 var D1 = NewDevice()
-var UnlockableBrands = []string{"sony", "motorola", "samsung", "nvidia", "oneplus", "fairphone"}
 
 func NewDevice() Device {
 	return Device{
@@ -33,6 +36,8 @@ func NewDevice() Device {
 		Model: "",
 		Codename: "",
 		Codename_ambiguous: false,
+		Codename_confidence: 0,
+		Codename_guesses: nil,
 		Brand: "",
 		IsBrandUnlockable: false,
 		Name: "",
@@ -45,6 +50,10 @@ func NewDevice() Device {
 		IsSupported: true,
 		IsSupported_checked: false,
 		TwrpVersionConnected: "",
+		InstalledRom: "",
+		InstalledRomVersion: "",
+		InstalledGapps: "",
+		InstalledPackages: nil,
 		AdbProps: map[string]string{},
 		FastbootVars: map[string]string{},
 	}
@@ -61,6 +70,8 @@ type Device struct {
 	Model string
 	Codename string
 	Codename_ambiguous bool
+	Codename_confidence float64
+	Codename_guesses []CodenameGuess
 	Brand string
 	IsBrandUnlockable bool
 	Name string
@@ -73,21 +84,31 @@ type Device struct {
 	IsSupported bool
 	IsSupported_checked bool
 	TwrpVersionConnected string
+	InstalledRom string
+	InstalledRomVersion string
+	InstalledGapps string
+	InstalledPackages []string
 	AdbProps map[string]string
 	FastbootVars map[string]string
 }
 
-func (d *Device) Test(model string) {
-	d.ObserveMe = false
-	d.State = "simulation"
-	adb.Simulation = true
-	d.Model = model
-	d.Arch = "simulation"
-	d.Imei = "simulation"
-	d.IsAB_checked = true
-	d.IsUnlocked = true
+// Returns a newer build of the installed rom which can be flashed
+// without wiping the data, or nil if there is none
+func (d *Device) UpdateCandidate() *get.Item {
+	if d.InstalledRom == "" || !d.IsUnlocked {
+		return nil
+	}
+
+	get.A1.Mutex.Lock()
+	rom := get.A1.Upstream.Rom[d.InstalledRom]
+	get.A1.Mutex.Unlock()
 
-	d.ReadMissingProps()
+	return get.NewerCompatibleBuild(rom, d.InstalledRomVersion)
+}
+
+// Tells whether a package is installed on the device
+func (d *Device) HasPackage(name string) bool {
+	return helpers.IsStringInSlice(name, d.InstalledPackages)
 }
 
 func (d *Device) GetState() string {
@@ -103,7 +124,7 @@ func (d *Device) GetState() string {
 			if heimdall_state == "connected" {
 				return "heimdall"
 			} else if heimdall_state == "disconnected" {
-				return "disconnected"
+				return usbState()
 			} else {
 				logger.LogError("Cannot determine heimdall connection state", fmt.Errorf("unknown heimdall state"))
 			}
@@ -117,16 +138,34 @@ func (d *Device) GetState() string {
 	return "unknown"
 }
 
+// Returns the mode of a device only visible on the usb bus, e.g. in EDL mode, or "disconnected"
+func usbState() string {
+	mode, u, err := usb.Scan()
+	if err != nil {
+		logger.Debug("Unable to scan the usb devices: " + err.Error())
+		return "disconnected"
+	}
+	if mode == "" {
+		return "disconnected"
+	}
+
+	logger.With(logger.Fields{"usb": u.String()}).Debug("Device only visible on the usb bus")
+	return mode
+}
+
+// True if the device is connected but cannot be used by adb, fastboot or heimdall
+func IsUsbOnly(state string) bool {
+	return helpers.IsStringInSlice(state, usb.Modes)
+}
+
 func (d *Device) Reboot(target string) (err error) {
 	switch d.State {
 	case "android", "recovery":
 		if strings.ToLower(target) == "bootloader" {
-			if strings.ToLower(d.Brand) == "samsung" {
-				err = adb.Reboot("heimdall")
-			} else if strings.ToLower(d.Brand) == "" {
+			if d.Brand == "" {
 				err = adb.Reboot(target)
 			} else {
-				err = adb.Reboot("fastboot")
+				err = adb.Reboot(d.Quirks().Bootloader_verb)
 			}
 		} else {
 			err = adb.Reboot(strings.ToLower(target))
@@ -136,7 +175,7 @@ func (d *Device) Reboot(target string) (err error) {
 	case "heimdall":
 		err = heimdall.Reboot()
 	default:
-		err = fmt.Errorf("Cannot reboot device right now")
+		err = errs.ErrRebootNotPossible
 	}
 
 	return err
@@ -152,7 +191,7 @@ func (d *Device) HandleStateRequest(req_state string) {
 		// Do nothing: simply wait for sideload to finish
 	} else {
 		err := d.Reboot(req_state)
-		if err != nil && err.Error() != "Cannot reboot device right now" {
+		if err != nil && !errors.Is(err, errs.ErrRebootNotPossible) {
 			logger.LogError("Unable to reboot device to " + req_state + ":", err)
 		}
 	}
@@ -161,115 +200,103 @@ func (d *Device) HandleStateRequest(req_state string) {
 func (d *Device) Unlock() error {
 	if !d.Flashing {
 		logger.Log("User cancelled flashing")
-		return fmt.Errorf("cancelled")
+		return errs.ErrCancelled
 	}
 
-	switch strings.ToLower(d.Brand) {
-	case "":
+	if d.Brand == "" {
 		return fmt.Errorf("Unknown brand")
-	case "samsung":
-		return nil	// No need on samsung devices
-	default:
-		unlock_data, err := d.GetUnlockData()
-		if err != nil && err.Error() != "No unlock data needed" {
-			return err
-		}
+	}
+	if d.Quirks().Unlock == quirks.UnlockNone {
+		return nil
+	}
 
-		err = d.DoUnlock(unlock_data)
-		if err != nil {
-			return err
-		}
+	unlock_data, err := d.GetUnlockData()
+	if err != nil && !errors.Is(err, errs.ErrNoUnlockData) {
+		return err
+	}
 
-		d.IsUnlocked = true
-		return nil
+	err = d.DoUnlock(unlock_data)
+	if err != nil {
+		return err
 	}
+
+	d.IsUnlocked = true
+	return nil
 }
 
 func (d *Device) DoUnlock(unlock_data string) error {
 	if !d.Flashing {
 		logger.Log("User cancelled flashing")
-		return fmt.Errorf("cancelled")
+		return errs.ErrCancelled
 	}
 	
-	switch strings.ToLower(d.Brand) {
-	case "":
+	if d.Brand == "" {
 		return fmt.Errorf("Unknown brand")
-	case "samsung":
-		return nil // No unlock needed on Samsung devices
-	case "motorola":
-		return d.UnlockMotorola(unlock_data)
-	case "sony":
-		return d.UnlockSony(unlock_data)
-	case "fairphone":
-		return d.UnlockFairphone()
-	default:
-		return fastboot.UnlockGeneric()
 	}
+	q := d.Quirks()
+	if q.Unlock == quirks.UnlockNone {
+		return nil
+	}
+	if q.TakesUnlockCode() && unlock_data == "" {
+		return fmt.Errorf("No unlock code provided")
+	}
+
+	d.State_request = "fastboot"
+	<-d.State_reached	// blocks until fastboot is reached
+	time.Sleep(q.Bootloader_delay)
+
+	return fastboot.Unlock(q.Unlock, unlock_data)
 }
 
 func (d *Device) GetUnlockData() (string, error) {
 	if !d.Flashing {
 		logger.Log("User cancelled flashing")
-		return "", fmt.Errorf("cancelled")
+		return "", errs.ErrCancelled
 	}
 	
-	switch strings.ToLower(d.Brand) {
-	case "":
+	if d.Brand == "" {
 		return "", fmt.Errorf("Unknown brand")
-	case "samsung", "oneplus", "nvidia":
-		return "", fmt.Errorf("No unlock data needed")
-	case "sony":
+	}
+	q := d.Quirks()
+
+	switch q.Unlock_data {
+	case quirks.UnlockDataNone:
+		return "", errs.ErrNoUnlockData
+	case quirks.UnlockDataImei:
 		if d.Imei != "" {
 			return d.Imei, nil
-		} else {
-			// Also able to return Imei while ADB connected
-			return fastboot.GetUnlockData(d.Brand)
 		}
-	case "fairphone":
-		if d.Codename == "FP2" {
-			return "", fmt.Errorf("No unlock data needed")
-		} else {
-			if d.Imei != "" && d.SerialNumber != "" {
-				return d.Imei + " " + d.SerialNumber, nil
-			} else {
-				// Also able to return Imei while ADB connected
-				return fastboot.GetUnlockData(d.Brand)
-			}
+	case quirks.UnlockDataImeiSerial:
+		if d.Imei != "" && d.SerialNumber != "" {
+			return d.Imei + " " + d.SerialNumber, nil
 		}
 	default:
 		d.State_request = "fastboot"
 		<-d.State_reached	// blocks until fastboot is reached
-		return fastboot.GetUnlockData(d.Brand)
-	}
-}
-
-func (d *Device) UnlockMotorola(unlock_code string) error {
-	if unlock_code == "" {
-		return fmt.Errorf("No unlock code provided")
+		time.Sleep(q.Bootloader_delay)
 	}
 
-	d.State_request = "fastboot"
-	<-d.State_reached	// blocks until fastboot is reached
-
-	return fastboot.UnlockMotorola(unlock_code)
+	// Also able to return the Imei while adb is connected
+	return fastboot.GetUnlockData(q.Unlock_data)
 }
 
-func (d *Device) UnlockSony(unlock_code string) error {
-	if unlock_code == "" {
-		return fmt.Errorf("No unlock code provided")
-	}
-
-	d.State_request = "fastboot"
-	<-d.State_reached	// blocks until fastboot is reached
-
-	return fastboot.UnlockSony(unlock_code)
+// Returns how the device differs from a generic android device
+func (d *Device) Quirks() quirks.Quirks {
+	return QuirksOf(d.Brand, d.Codename)
 }
 
-func (d *Device) UnlockFairphone() error {
-	d.State_request = "fastboot"
-	<-d.State_reached	// blocks until fastboot is reached
+// Returns the quirks of a brand and codename with the overrides of the device profiles
+func QuirksOf(brand string, codename string) quirks.Quirks {
+	return quirks.Of(brand, codename, profileQuirks(brand, codename))
+}
 
-	return fastboot.UnlockFairphone()
+// The unlock method and unlock data of the device profiles and overrides.yml
+func profileQuirks(brand string, codename string) quirks.Quirks {
+	method, data, err := lookup.UnlockOverride(codename, strings.ToLower(brand))
+	if err != nil {
+		logger.LogError("Unable to lookup the unlock method:", err)
+	}
+	return quirks.Quirks{Unlock: method, Unlock_data: data}
 }
 
 // Boot a given recovery image.
@@ -279,7 +306,7 @@ func (d *Device) UnlockFairphone() error {
 func (d *Device) BootRecovery(img_file string, bootloader_timeout int) (string, error) {
 	if !d.Flashing {
 		logger.Log("User cancelled flashing")
-		return "", fmt.Errorf("cancelled")
+		return "", errs.ErrCancelled
 	}
 	
 	_, err := os.Stat(img_file)
@@ -295,12 +322,12 @@ func (d *Device) BootRecovery(img_file string, bootloader_timeout int) (string,
 			case <-d.State_reached:
 			case <-time.After(time.Duration(bootloader_timeout) * time.Second):
 				logger.Log(strconv.Itoa(bootloader_timeout) + " seconds timeout was hit.")
-				return "", fmt.Errorf("timeout waiting for bootloader on windows")
+				return "", errs.Wrap(errs.ErrTimeout, "timeout waiting for bootloader on windows")
 			}
 		} else {
 			<-d.State_reached	// Wait for bootloader
 		}
-		
+		time.Sleep(d.Quirks().Bootloader_delay)
 	}
 
 	user_instructions, err := lookup.RecoveryKeyCombination(d.Codename)
@@ -326,11 +353,20 @@ func (d *Device) BootRecovery(img_file string, bootloader_timeout int) (string,
 		return "", err
 	}
 
+	// The profile can ask to flash TWRP even without a partition name
+	boot := partition == "" || strings.ToLower(partition) == "boot"
+	profile, err := lookup.Profile(d.Codename)
+	if err == nil {
+		boot = profile.BootsRecovery()
+	}
+
 	if d.State == "fastboot" {
-		if partition == "" || strings.ToLower(partition) == "boot" {
-			return "", fastboot.BootRecovery(d.Brand, img_file)
+		if boot {
+			return "", fastboot.BootRecovery(img_file)
+		} else if partition == "" {
+			return user_instructions, fastboot.FlashRecovery(img_file, "recovery")
 		} else {
-			return user_instructions, fastboot.FlashRecovery(d.Brand, img_file, partition)
+			return user_instructions, fastboot.FlashRecovery(img_file, partition)
 		}
 	} else if d.State == "heimdall" {
 		if partition == "" {
@@ -343,12 +379,10 @@ func (d *Device) BootRecovery(img_file string, bootloader_timeout int) (string,
 	}
 }
 
-// Flashes a rom zip file using TWRP and adb sideload.
-// "Clean flash" (formating data) if wipe == "clean"
-func (d *Device) FlashRom(zip_file string, wipe string) error {
+func (d *Device) FlashZip(zip_file string) error {
 	if !d.Flashing {
 		logger.Log("User cancelled flashing")
-		return fmt.Errorf("cancelled")
+		return errs.ErrCancelled
 	}
 	
 	_, err := os.Stat(zip_file)
@@ -362,54 +396,12 @@ func (d *Device) FlashRom(zip_file string, wipe string) error {
 	}
 
 	if d.State == "recovery" {
-		// Wipe caches (and format data if "clean")
-		if wipe == "clean" {
-			logger.Log("Clean-Wiping the device...")
-			err = twrp.WipeClean()
-			if err != nil {
-				return err
-			}
-		} else {
-			logger.Log("Dirty-Wiping the device...")
-			err = twrp.WipeDirty()
-			if err != nil {
-				return err
-			}
-		}
-
-		d.State_request = "sideload"
-		<-d.State_reached	// Blocks until sideload is connected
-
-		// Flash the zip
-		logger.Log("Sideloading the rom zip...")
-		err = twrp.Sideload(zip_file)
+		// Only the log lines of this sideload are analyzed afterwards
+		err = twrp.MarkLog()
 		if err != nil {
-			return err
+			logger.Log("Unable to read the recovery log before sideloading:", err.Error())
 		}
 
-		return nil
-	} else {
-		return fmt.Errorf("Cannot flash the rom: TWRP not connected")
-	}
-}
-
-func (d *Device) FlashZip(zip_file string) error {
-	if !d.Flashing {
-		logger.Log("User cancelled flashing")
-		return fmt.Errorf("cancelled")
-	}
-	
-	_, err := os.Stat(zip_file)
-	if os.IsNotExist(err) {
-	  return fmt.Errorf("%s does not exist, can't flash it", zip_file)
-	}
-
-	if d.State != "recovery" {
-		d.State_request = "recovery"
-		<-d.State_reached	// Blocks until recovery is connected
-	}
-
-	if d.State == "recovery" {
 		d.State_request = "sideload"
 		<-d.State_reached	// Blocks until sideload is connected
 
@@ -0,0 +1,96 @@
+package errs
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors shared by the adb, fastboot, heimdall and twrp packages
+// Check them with errors.Is instead of comparing messages,
+// errors.As with *CommandError gives access to the raw output
+
+var (
+	ErrDisconnected = errors.New("disconnected")
+	ErrUnauthorized = errors.New("unauthorized")
+	// The adb server is not running
+	ErrConnectionRefused = errors.New("connection refused")
+	ErrCancelled = errors.New("cancelled")
+	ErrTimeout = errors.New("timeout")
+	ErrNotImplemented = errors.New("not implemented")
+	ErrUnknownResponse = errors.New("unknown response")
+	// The device is busy, e.g. rebooting already
+	ErrRebootNotPossible = errors.New("Cannot reboot device right now")
+
+	ErrAlreadyUnlocked = errors.New("unlocked")
+	ErrNoUnlockData = errors.New("No unlock data needed")
+	// OEM unlocking has not been allowed in the developer options
+	ErrUnlockNotAllowed = errors.New("not allowed")
+	ErrUnlockFailed = errors.New("failed")
+
+	// The driver does not give access to the device, e.g. heimdall on windows without zadig
+	ErrDriverAccess = errors.New("heimdall failed to access device")
+	ErrFlashFailed = errors.New("flashing failed")
+	ErrBootFailed = errors.New("booting failed")
+	ErrUnknownPartition = errors.New("unknown partition")
+	ErrRecoveryBootFailed = errors.New("manually booting recovery failed")
+)
+
+// An error with its own message, matching err with errors.Is
+type wrapped struct {
+	message string
+	err error
+}
+
+func Wrap(err error, message string) error {
+	return &wrapped{message: message, err: err}
+}
+
+func (w *wrapped) Error() string {
+	return w.message
+}
+
+func (w *wrapped) Unwrap() error {
+	return w.err
+}
+
+// A command which failed, with its output for diagnostics
+type CommandError struct {
+	// adb, fastboot or heimdall
+	Tool string
+	Args []string
+	Output string
+	Err error
+}
+
+// Returns a *CommandError wrapping err
+func Command(tool string, args []string, output string, err error) error {
+	return &CommandError{Tool: tool, Args: args, Output: strings.TrimSpace(output), Err: err}
+}
+
+// Same message as the wrapped error, the output is only meant for logs and diagnostics
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Command line and output, e.g. to log them
+func (e *CommandError) Details() string {
+	return e.Tool + " " + strings.Join(e.Args, " ") + ": " + e.Err.Error() + "\n" + e.Output
+}
+
+// Returns the details of the *CommandError in err, empty if there is none
+func Details(err error) string {
+	var c *CommandError
+	if errors.As(err, &c) {
+		return c.Details()
+	}
+	return ""
+}
+
+// True if the device cannot be reached any more
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrDisconnected) || errors.Is(err, ErrUnauthorized)
+}
@@ -4,39 +4,50 @@ import (
 	"github.com/amo13/anarchy-droid/logger"
 	"github.com/amo13/anarchy-droid/helpers"
 	"github.com/amo13/anarchy-droid/device/adb"
+	"github.com/amo13/anarchy-droid/device/errs"
+	"github.com/amo13/anarchy-droid/device/quirks"
 
+	"time"
 	"runtime"
 	"strings"
+	"errors"
 	"fmt"
 )
 
-var Sudopw string = ""
-var Nosudo bool = false
-
 func fastboot_command() string {
 	switch runtime.GOOS {
 	case "windows":
 		return "bin\\platform-tools\\fastboot.exe"
 	case "darwin":
 		return "bin/platform-tools/fastboot"
-	default:	// linux
-		if Nosudo {
-			return "bin/platform-tools/fastboot"
-		} else if Sudopw == "" {
-			return "sudo bin/platform-tools/fastboot"
-		} else {
-			return "printf " + Sudopw + " | sudo -S bin/platform-tools/fastboot"
-		}
+	default:	// linux, started according to helpers.Privileged
+		return "bin/platform-tools/fastboot"
 	}
 }
 
+// Executes the fastboot commands, replaced by a simulated device for dry-runs
+type Runner interface {
+	Run(args ...string) (stdout string, stderr string)
+}
+
+var Backend Runner = binary{}
+
+// Runs the fastboot binary shipped with the app
+type binary struct{}
+
+func (binary) Run(args ...string) (stdout string, stderr string) {
+	return helpers.CmdPrivileged(fastboot_command(), args...)
+}
+
 // Returns the non-empty or longer one of stdout and stderr for a given fastboot command
 func Cmd(args ...string) (stdout string, err error) {
 	if !available() {
-		return "", fmt.Errorf("disconnected")
+		return "", errs.ErrDisconnected
 	}
 
-	stdout, stderr := helpers.Cmd(fastboot_command(), args...)
+	start := time.Now()
+	stdout, stderr := Backend.Run(args...)
+	logger.With(logger.Fields{"command": "fastboot " + strings.Join(args, " "), "duration": time.Since(start).String()}).Debug("Command finished")
 	if stdout != "" && stderr == "" {
 		return strings.Trim(strings.Trim(stdout, "\n"), " "), nil
 	} else if stdout == "" && stderr != "" {
@@ -48,17 +59,25 @@ func Cmd(args ...string) (stdout string, err error) {
 			return strings.Trim(strings.Trim(stderr, "\n"), " "), nil
 		}
 	} else {
-		return "", fmt.Errorf("disconnected")
+		return "", errs.ErrDisconnected
 	}
 }
 
+// Wraps err with the fastboot command and its output
+func commandError(output string, err error, args ...string) error {
+	return errs.Command("fastboot", args, output, err)
+}
+
 // Check for disconnection error
 func unavailable(err error) bool {
 	if err != nil {
-		if err.Error() == "disconnected" {
+		if errors.Is(err, errs.ErrDisconnected) {
 			return true
 		} else {
 			logger.LogError("Unknown fastboot error:", err)
+			if details := errs.Details(err); details != "" {
+				logger.Debug(details)
+			}
 		}
 	}
 
@@ -74,7 +93,7 @@ func available() bool {
 }
 
 func State() string {
-	stdout, _ := helpers.Cmd(fastboot_command(), "devices")
+	stdout, _ := Backend.Run("devices")
 
 	if stdout == "" {
 		return "disconnected"
@@ -85,7 +104,7 @@ func State() string {
 
 func Reboot(target string) error {
 	if !available() {
-		return fmt.Errorf("disconnected")
+		return errs.ErrDisconnected
 	}
 
 	logger.Log("Rebooting device to " + target + "...")
@@ -101,7 +120,7 @@ func Reboot(target string) error {
 
 func GetVarMap() (map[string]string, error) {
 	if !available() {
-		return make(map[string]string), fmt.Errorf("disconnected")
+		return make(map[string]string), errs.ErrDisconnected
 	}
 
 	stdout, err := Cmd("getvar", "all")
@@ -162,6 +181,7 @@ func Imei() (string, error) {
 }
 
 func ImeiFromVarMap(m map[string]string) string {
+	logger.AddSecret(logger.SecretImei, m["imei"])
 	return m["imei"]
 }
 
@@ -175,6 +195,7 @@ func SerialNumber() (string, error) {
 }
 
 func SerialNumberFromVarMap(m map[string]string) string {
+	logger.AddSecret(logger.SecretSerial, m["serialno"])
 	return m["serialno"]
 }
 
@@ -234,25 +255,27 @@ func IsUnlockedFromVarMap(m map[string]string) bool {
 	return false
 }
 
-// Retrieves the needed data to unlock the bootloader
+// Retrieves the needed data to unlock the bootloader from one of the quirks.UnlockData sources
 // returns an "unlocked" error if already unlocked
-func GetUnlockData(brand string) (string, error) {
+func GetUnlockData(source string) (string, error) {
 	unlocked, err := IsUnlocked()
 	if err != nil {
 		return "", err
 	}
 	if unlocked {
-		return "", fmt.Errorf("unlocked")
+		return "", errs.ErrAlreadyUnlocked
 	}
-	switch strings.ToLower(brand) {
-	case "motorola":
+	switch source {
+	case quirks.UnlockDataFastboot:
 		return GetUnlockDataMotorola()
-	case "sony":
+	case quirks.UnlockDataImei:
 		return GetUnlockDataSony()
-	case "fairphone":
+	case quirks.UnlockDataImeiSerial:
 		return GetUnlockDataFairphone()
+	case quirks.UnlockDataNone:
+		return "", errs.ErrNoUnlockData
 	default:
-		return "", fmt.Errorf("not implemented")
+		return "", errs.ErrNotImplemented
 	}
 }
 
@@ -262,11 +285,6 @@ func GetUnlockDataMotorola() (string, error) {
 		return "", err
 	}
 
-	// Log the result for reference
-	logger.Log("------- fastboot oem get_unlock_data -------")
-	logger.Log(data)
-	logger.Log("--------------------------------------------")
-
 	// parse and scrub the data
 	lines := strings.Split(data, "\n")
 	result := ""
@@ -292,6 +310,18 @@ func GetUnlockDataMotorola() (string, error) {
 		}
 	}
 
+	// Log the result for reference, the unlock data identifies the device
+	for _, line := range lines {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "INFO"))
+		if len(fields) > 0 {
+			logger.AddSecret(logger.SecretUnlockCode, fields[len(fields) - 1])
+		}
+	}
+	logger.AddSecret(logger.SecretUnlockCode, result)
+	logger.Log("------- fastboot oem get_unlock_data -------")
+	logger.Log(data)
+	logger.Log("--------------------------------------------")
+
 	if result != "" {
 		return result, nil
 	} else {
@@ -310,7 +340,7 @@ func GetUnlockDataSony() (string, error) {
 		if helpers.IsStringInSlice(adb.State(), []string{"android","recovery"}) {
 			return adb.Imei()
 		} else {
-			return "", fmt.Errorf("disconnected")
+			return "", errs.ErrDisconnected
 		}
 	}
 }
@@ -348,31 +378,31 @@ func GetUnlockDataFairphone() (string, error) {
 				return "", fmt.Errorf("Unable to read IMEI and SN")
 			}
 		} else {
-			return "", fmt.Errorf("disconnected")
+			return "", errs.ErrDisconnected
 		}
 	}
 }
 
-func Unlock(brand string, unlock_code string) error {
-	switch strings.ToLower(brand) {
-	case "motorola":
+// Unlocks the bootloader with one of the quirks.Unlock strategies
+func Unlock(method string, unlock_code string) error {
+	switch method {
+	case quirks.UnlockOemCode:
 		return UnlockMotorola(unlock_code)
-	case "sony":
+	case quirks.UnlockOemHexCode:
 		return UnlockSony(unlock_code)
-	case "oneplus":
-		return UnlockOneplus()
-	case "nvidia":
-		return UnlockNvidia()
-	case "fairphone":
+	case quirks.UnlockFlashing:
 		return UnlockFairphone()
-	case "generic":
+	case quirks.UnlockOem:
 		return UnlockGeneric()
+	case quirks.UnlockNone:
+		return nil
 	default:
-		return fmt.Errorf("not implemented")
+		return errs.ErrNotImplemented
 	}
 }
 
 func UnlockMotorola(unlock_code string) error {
+	logger.AddSecret(logger.SecretUnlockCode, unlock_code)
 	result, err := Cmd("oem", "unlock", unlock_code)
 	if unavailable(err) {
 		return err
@@ -385,13 +415,13 @@ func UnlockMotorola(unlock_code string) error {
 
 	if strings.Contains(strings.ToLower(result), "allow oem unlock") {
 		logger.Log("OEM unlock has apparently not been enabled...")
-		return fmt.Errorf("not allowed")
+		return commandError(result, errs.ErrUnlockNotAllowed, "oem", "unlock", unlock_code)
 	} else if strings.Contains(strings.ToLower(result), "re-run this command") {
 		logger.Log("Re-running the unlock command to confirm unlock request...")
 		return UnlockMotorola(unlock_code)
 	} else if strings.Contains(strings.ToLower(result), "failed") {
 		logger.Log("bootloader unlock failed")
-		return fmt.Errorf("failed")
+		return commandError(result, errs.ErrUnlockFailed, "oem", "unlock", unlock_code)
 	} else if strings.Contains(strings.ToLower(result), "already unlocked") {
 		logger.Log("bootloader already unlocked")
 		return nil
@@ -403,11 +433,12 @@ func UnlockMotorola(unlock_code string) error {
 		return nil
 	} else {
 		logger.Log("unknown response")
-		return fmt.Errorf("unknown response")
+		return commandError(result, errs.ErrUnknownResponse, "oem", "unlock", unlock_code)
 	}
 }
 
 func UnlockSony(unlock_code string) error {
+	logger.AddSecret(logger.SecretUnlockCode, unlock_code)
 	result, err := Cmd("oem", "unlock", "0x" + unlock_code)
 	if unavailable(err) {
 		return err
@@ -420,13 +451,13 @@ func UnlockSony(unlock_code string) error {
 
 	if strings.Contains(strings.ToLower(result), "not allowed") {
 		logger.Log("OEM unlock has apparently not been enabled...")
-		return fmt.Errorf("not allowed")
+		return commandError(result, errs.ErrUnlockNotAllowed, "oem", "unlock", "0x" + unlock_code)
 	} else if strings.Contains(strings.ToLower(result), "already") {
 		logger.Log("bootloader already unlocked")
 		return nil
 	} else if strings.Contains(strings.ToLower(result), "failed") {
 		logger.Log("bootloader unlock failed")
-		return fmt.Errorf("failed")
+		return commandError(result, errs.ErrUnlockFailed, "oem", "unlock", "0x" + unlock_code)
 	} else if strings.Contains(strings.ToLower(result), "re-run this command") {
 		logger.Log("Re-running the unlock command to confirm unlock request...")
 		return UnlockSony(unlock_code)
@@ -437,7 +468,7 @@ func UnlockSony(unlock_code string) error {
 		return nil
 	} else {
 		logger.Log("unknown response")
-		return fmt.Errorf("unknown response")
+		return commandError(result, errs.ErrUnknownResponse, "oem", "unlock", "0x" + unlock_code)
 	}
 }
 
@@ -454,13 +485,13 @@ func UnlockFairphone() error {
 
 	if strings.Contains(strings.ToLower(result), "allow oem unlock") {
 		logger.Log("OEM unlock has apparently not been enabled...")
-		return fmt.Errorf("not allowed")
+		return commandError(result, errs.ErrUnlockNotAllowed, "flashing", "unlock")
 	} else if strings.Contains(strings.ToLower(result), "re-run this command") {
 		logger.Log("Re-running the unlock command to confirm unlock request...")
 		return UnlockFairphone()
 	} else if strings.Contains(strings.ToLower(result), "failed") {
 		logger.Log("bootloader unlock failed")
-		return fmt.Errorf("failed")
+		return commandError(result, errs.ErrUnlockFailed, "flashing", "unlock")
 	} else if strings.Contains(strings.ToLower(result), "already unlocked") {
 		logger.Log("bootloader already unlocked")
 		return nil
@@ -472,18 +503,10 @@ func UnlockFairphone() error {
 		return nil
 	} else {
 		logger.Log("unknown response")
-		return fmt.Errorf("unknown response")
+		return commandError(result, errs.ErrUnknownResponse, "flashing", "unlock")
 	}
 }
 
-func UnlockOneplus() error {
-	return UnlockGeneric()
-}
-
-func UnlockNvidia() error {
-	return UnlockGeneric()
-}
-
 func UnlockGeneric() error {
 	result, err := Cmd("oem", "unlock")
 	if unavailable(err) {
@@ -497,10 +520,10 @@ func UnlockGeneric() error {
 
 	if strings.Contains(strings.ToLower(result), "allow oem unlock") {
 		logger.Log("OEM unlock has apparently not been enabled...")
-		return fmt.Errorf("not allowed")
+		return commandError(result, errs.ErrUnlockNotAllowed, "oem", "unlock")
 	} else if strings.Contains(strings.ToLower(result), "failed") {
 		logger.Log("bootloader unlock failed")
-		return fmt.Errorf("failed")
+		return commandError(result, errs.ErrUnlockFailed, "oem", "unlock")
 	} else if strings.Contains(strings.ToLower(result), "Total time: 0.000s") {
 		logger.Log("bootloader already unlocked")
 		return nil
@@ -514,7 +537,7 @@ func UnlockGeneric() error {
 		return nil
 	} else {
 		logger.Log("unknown response")
-		return fmt.Errorf("unknown response")
+		return commandError(result, errs.ErrUnknownResponse, "oem", "unlock")
 	}
 }
 
@@ -533,50 +556,11 @@ func FlashStartupLogo(logo_file string) error {
 		return nil
 	} else {
 		logger.Log("unknown response")
-		return fmt.Errorf("unknown response")
-	}
-}
-
-func BootRecovery(brand string, img_file string) error {
-	switch strings.ToLower(brand) {
-	case "motorola":
-		return bootRecoveryMotorola(img_file)
-	case "sony":
-		return bootRecoverySony(img_file)
-	case "oneplus":
-		return bootRecoveryOneplus(img_file)
-	case "nvidia":
-		return bootRecoveryNvidia(img_file)
-	case "fairphone":
-		return bootRecoveryFairphone(img_file)
-	case "generic":
-		return bootRecoveryGeneric(img_file)
-	default:
-		return fmt.Errorf("not implemented")
+		return commandError(result, errs.ErrUnknownResponse, "flash", "logo", logo_file)
 	}
 }
 
-func bootRecoveryMotorola(img_file string) error {
-	return bootRecoveryGeneric(img_file)
-}
-
-func bootRecoverySony(img_file string) error {
-	return bootRecoveryGeneric(img_file)
-}
-
-func bootRecoveryOneplus(img_file string) error {
-	return bootRecoveryGeneric(img_file)
-}
-
-func bootRecoveryFairphone(img_file string) error {
-	return bootRecoveryGeneric(img_file)
-}
-
-func bootRecoveryNvidia(img_file string) error {
-	return bootRecoveryGeneric(img_file)
-}
-
-func bootRecoveryGeneric(img_file string) error {
+func BootRecovery(img_file string) error {
 	result, err := Cmd("boot", img_file)
 	if unavailable(err) {
 		return err
@@ -596,56 +580,17 @@ func bootRecoveryGeneric(img_file string) error {
 				extracted = "FAILED" + strings.Split(line, "FAILED")[1]
 			}
 		}
-		return fmt.Errorf(extracted)
+		return commandError(result, errs.Wrap(errs.ErrBootFailed, extracted), "boot", img_file)
 	} else if strings.Contains(result, "Sending") && strings.Contains(result, "Booting") && strings.Contains(result, "OKAY") {
 		return nil
 	} else {
 		logger.Log("unknown response")
 		logger.Log("is the recovery booting?")
-		return fmt.Errorf("unknown response")
-	}
-}
-
-func FlashRecovery(brand string, img_file string, partition string) error {
-	switch strings.ToLower(brand) {
-	case "motorola":
-		return flashRecoveryMotorola(img_file, partition)
-	case "sony":
-		return flashRecoverySony(img_file, partition)
-	case "oneplus":
-		return flashRecoveryOneplus(img_file, partition)
-	case "nvidia":
-		return flashRecoveryNvidia(img_file, partition)
-	case "fairphone":
-		return flashRecoveryFairphone(img_file, partition)
-	case "generic":
-		return flashRecoveryGeneric(img_file, partition)
-	default:
-		return fmt.Errorf("not implemented")
+		return commandError(result, errs.ErrUnknownResponse, "boot", img_file)
 	}
 }
 
-func flashRecoveryMotorola(img_file string, partition string) error {
-	return flashRecoveryGeneric(img_file, partition)
-}
-
-func flashRecoverySony(img_file string, partition string) error {
-	return flashRecoveryGeneric(img_file, partition)
-}
-
-func flashRecoveryOneplus(img_file string, partition string) error {
-	return flashRecoveryGeneric(img_file, partition)
-}
-
-func flashRecoveryNvidia(img_file string, partition string) error {
-	return flashRecoveryGeneric(img_file, partition)
-}
-
-func flashRecoveryFairphone(img_file string, partition string) error {
-	return flashRecoveryGeneric(img_file, partition)
-}
-
-func flashRecoveryGeneric(img_file string, partition string) error {
+func FlashRecovery(img_file string, partition string) error {
 	result, err := Cmd("flash", partition, img_file)
 	if unavailable(err) {
 		return err
@@ -665,16 +610,16 @@ func flashRecoveryGeneric(img_file string, partition string) error {
 				extracted = "FAILED" + strings.Split(line, "FAILED")[1]
 			}
 		}
-		return fmt.Errorf(extracted)
+		return commandError(result, errs.Wrap(errs.ErrFlashFailed, extracted), "flash", partition, img_file)
 	} else if strings.Contains(result, "no such partition") || strings.Contains(result, "invalid partition") {
 		logger.Log("unknown partition")
-		return fmt.Errorf("unknown partition")
+		return commandError(result, errs.ErrUnknownPartition, "flash", partition, img_file)
 	} else {
 		if strings.Contains(result, "Sending") && strings.Contains(result, "Writing") && strings.Contains(result, "OKAY") {
 			return nil
 		} else {
 			logger.Log("unknown response")
-			return fmt.Errorf("unknown response")
+			return commandError(result, errs.ErrUnknownResponse, "flash", partition, img_file)
 		}
 	}
 }
\ No newline at end of file
@@ -3,39 +3,49 @@ package heimdall
 import (
 	"github.com/amo13/anarchy-droid/helpers"
 	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/device/errs"
 
+	"time"
+	"errors"
 	"runtime"
 	"strings"
 	"fmt"
 )
 
-var Sudopw string = ""
-var Nosudo bool = false
-
 func heimdall_command() string {
 	switch runtime.GOOS {
 	case "windows":
 		return "bin\\heimdall\\heimdall.exe"
 	case "darwin":
 		return "bin/heimdall/heimdall"
-	default:
-		if Nosudo {
-			return "bin/heimdall/heimdall"
-		} else if Sudopw == "" {
-			return "sudo bin/heimdall/heimdall"
-		} else {
-			return "printf " + Sudopw + " | sudo -S bin/heimdall/heimdall"
-		}
+	default:	// linux, started according to helpers.Privileged
+		return "bin/heimdall/heimdall"
 	}
 }
 
+// Executes the heimdall commands, replaced by a simulated device for dry-runs
+type Runner interface {
+	Run(args ...string) (stdout string, stderr string)
+}
+
+var Backend Runner = binary{}
+
+// Runs the heimdall binary shipped with the app
+type binary struct{}
+
+func (binary) Run(args ...string) (stdout string, stderr string) {
+	return helpers.CmdPrivileged(heimdall_command(), args...)
+}
+
 // Returns the non-empty or longer of stdout and stderr for a given fastboot command
 func Cmd(args ...string) (stdout string, err error) {
 	if !available() {
-		return "", fmt.Errorf("disconnected")
+		return "", errs.ErrDisconnected
 	}
 
-	stdout, stderr := helpers.Cmd(heimdall_command(), args...)
+	start := time.Now()
+	stdout, stderr := Backend.Run(args...)
+	logger.With(logger.Fields{"command": "heimdall " + strings.Join(args, " "), "duration": time.Since(start).String()}).Debug("Command finished")
 	if stdout != "" && stderr == "" {
 		return strings.Trim(strings.Trim(stdout, "\n"), " "), nil
 	} else if stdout == "" && stderr != "" {
@@ -47,17 +57,20 @@ func Cmd(args ...string) (stdout string, err error) {
 			return strings.Trim(strings.Trim(stderr, "\n"), " "), nil
 		}
 	} else {
-		return "", fmt.Errorf("disconnected")
+		return "", errs.ErrDisconnected
 	}
 }
 
 // Check for disconnection error
 func unavailable(err error) bool {
 	if err != nil {
-		if err.Error() == "disconnected" {
+		if errors.Is(err, errs.ErrDisconnected) {
 			return true
 		} else {
 			logger.LogError("Unknown heimdall error:", err)
+			if details := errs.Details(err); details != "" {
+				logger.Debug(details)
+			}
 		}
 	}
 
@@ -73,7 +86,7 @@ func available() bool {
 }
 
 func State() string {
-	stdout, _ := helpers.Cmd(heimdall_command(), "detect")
+	stdout, _ := Backend.Run("detect")
 
 	if stdout == "" {
 		return "disconnected"
@@ -96,14 +109,14 @@ func FlashRecovery(img_file string, partition string) error {
 	if strings.Contains(strings.ToLower(result), "upload successful") {
 		return nil
 	} else if strings.Contains(strings.ToLower(result), "failed to access device") {
-		logger.LogError("heimdall failed to access device", fmt.Errorf(result))
-		return fmt.Errorf("heimdall failed to access device")
+		logger.LogError("heimdall failed to access device", fmt.Errorf("%s", result))
+		return errs.Command("heimdall", []string{"flash", "--" + partition, img_file, "--no-reboot"}, result, errs.ErrDriverAccess)
 	} else if strings.Contains(strings.ToLower(result), "upload failed") {
 		logger.Log("heimdall failed to flash recovery")
-		return fmt.Errorf("heimdall failed to flash recovery")
+		return errs.Command("heimdall", []string{"flash", "--" + partition, img_file, "--no-reboot"}, result, errs.Wrap(errs.ErrFlashFailed, "heimdall failed to flash recovery"))
 	} else {
-		logger.LogError("unknown heimdall response:", fmt.Errorf(result))
-		return fmt.Errorf("unknown heimdall response: " + result)
+		logger.LogError("unknown heimdall response:", fmt.Errorf("%s", result))
+		return errs.Command("heimdall", []string{"flash", "--" + partition, img_file, "--no-reboot"}, result, errs.Wrap(errs.ErrUnknownResponse, "unknown heimdall response: " + result))
 	}
 }
 
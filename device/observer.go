@@ -3,12 +3,13 @@ package device
 import (
 	"time"
 	"errors"
-	"strings"
 
+	"github.com/amo13/anarchy-droid/get"
 	"github.com/amo13/anarchy-droid/logger"
 	"github.com/amo13/anarchy-droid/lookup"
 	"github.com/amo13/anarchy-droid/helpers"
 	"github.com/amo13/anarchy-droid/device/adb"
+	"github.com/amo13/anarchy-droid/device/quirks"
 	"github.com/amo13/anarchy-droid/device/twrp"
 	"github.com/amo13/anarchy-droid/device/fastboot"
 )
@@ -61,7 +62,7 @@ func (d *Device) doObservation(last_state string) string {
 		}
 	}
 
-	if d.State_request != "" && d.State != "disconnected" {
+	if d.State_request != "" && d.State != "disconnected" && !IsUsbOnly(d.State) {
 		d.HandleStateRequest(d.State_request)
 	}
 
@@ -104,6 +105,7 @@ func (d *Device) changeDetected(new_state string) {
 func (d *Device) StartOver() {
 	d.ObserveMe = false	// Stop observing old device object
 	D1 = NewDevice()
+	logger.SetField("serial", "")
 
 	// Read ADB props and fastboot vars if not done yet
 	if helpers.IsStringInSlice(D1.GetState(), []string{"android", "recovery", "fastboot"}) {
@@ -143,20 +145,7 @@ func (d *Device) ReadMissingProps() {
 		logger.Device_model = d.Model
 	}
 	if d.Codename == "" {
-		if d.Model != "" {
-			d.Codename, err = lookup.ModelToCodename(d.Model)
-			if err != nil {
-				if strings.Contains(err.Error(), "ambiguous") {
-					d.Codename_ambiguous = true
-				} else {
-					logger.LogError("Unable to lookup model to codename:", err)
-				}
-			} else {
-				d.Codename_ambiguous = false
-			}
-		} else if len(d.AdbProps) > 0 {
-			d.Codename = adb.CodenameFromPropMap(d.AdbProps)
-		}
+		d.detectCodename()
 
 		// Propagate the codename to the logger package
 		logger.Device_codename = d.Codename
@@ -165,9 +154,9 @@ func (d *Device) ReadMissingProps() {
 		if d.Codename != "" {
 			d.Brand, err = lookup.CodenameToBrand(d.Codename)
 			if err != nil {
-				switch err.Error() {
-				case "not found":
-				case "ambiguous":
+				switch {
+				case errors.Is(err, lookup.ErrNotFound):
+				case errors.Is(err, lookup.ErrAmbiguous):
 				default:
 					logger.LogError("Unable to lookup codename to brand:", err)
 				}
@@ -180,15 +169,15 @@ func (d *Device) ReadMissingProps() {
 		}
 	}
 	if d.IsBrandUnlockable == false && d.Brand != "" {
-		d.IsBrandUnlockable = helpers.IsStringInSlice(strings.ToLower(d.Brand), UnlockableBrands)
+		d.IsBrandUnlockable = quirks.Unlockable(d.Brand, d.Codename, profileQuirks(d.Brand, d.Codename))
 	}
 	if d.Name == "" {
 		if d.Codename != "" {
 			d.Name, err = lookup.CodenameToNameCsv(d.Codename)
 			if err != nil {
-				switch err.Error() {
-				case "not found":
-				case "ambiguous":
+				switch {
+				case errors.Is(err, lookup.ErrNotFound):
+				case errors.Is(err, lookup.ErrAmbiguous):
 				default:
 					logger.LogError("Unable to lookup codename to name from CSV:", err)
 				}
@@ -222,6 +211,9 @@ func (d *Device) ReadMissingProps() {
 		} else if d.State == "fastboot" && len(d.FastbootVars) > 0 {
 			d.SerialNumber = fastboot.SerialNumberFromVarMap(d.FastbootVars)
 		}
+
+		// Propagate the serial number to the logger package
+		logger.SetField("serial", d.SerialNumber)
 	}
 	if d.IsAB_checked == false {
 		if len(d.AdbProps) > 0 {
@@ -255,6 +247,26 @@ func (d *Device) ReadMissingProps() {
 			d.IsSupported_checked = true
 		}
 	}
+	if d.InstalledRomVersion == "" && len(d.AdbProps) > 0 {
+		d.InstalledRom, d.InstalledRomVersion = adb.InstalledRomFromPropMap(d.AdbProps)
+		if d.InstalledRom == "" && d.InstalledRomVersion != "" {
+			d.InstalledRom, _, err = get.GuessRomNameAndAndroidVersion(d.InstalledRomVersion)
+			if err != nil {
+				logger.Log("Unable to guess the installed rom from version " + d.InstalledRomVersion)
+			}
+		}
+		if d.InstalledRom != "" {
+			logger.Log("Installed rom: " + d.InstalledRom + " " + d.InstalledRomVersion)
+		}
+	}
+	if d.InstalledPackages == nil && d.State == "android" && d.InstalledRom != "" {
+		d.InstalledPackages, err = adb.InstalledPackages()
+		if err != nil {
+			logger.LogError("Unable to list the installed packages:", err)
+		} else {
+			d.InstalledGapps = adb.InstalledGappsFromPackages(d.InstalledPackages)
+		}
+	}
 	if d.State == "recovery" && d.TwrpVersionConnected == "" {
 		twrp_v, err := twrp.VersionConnected()
 		if err != nil {
@@ -282,7 +294,7 @@ func (d *Device) isSameDevice(state string) bool {
 		if model != d.Model {
 			codename, err := lookup.ModelToCodename(model)
 			if err != nil {
-				if !strings.Contains(err.Error(), "ambiguous") {
+				if !errors.Is(err, lookup.ErrAmbiguous) {
 					logger.LogError("Unable to lookup model to codename:", err)
 				}
 
@@ -317,9 +329,11 @@ func (d *Device) isSameDevice(state string) bool {
 
 		if model != d.Model {
 			codename, err := lookup.ModelToCodename(model)
-			if !strings.Contains(err.Error(), "ambiguous") {
-				logger.LogError("Unable to lookup model to codename:", err)
-			} else {
+			if err != nil {
+				if !errors.Is(err, lookup.ErrAmbiguous) {
+					logger.LogError("Unable to lookup model to codename:", err)
+				}
+
 				return true
 			}
 
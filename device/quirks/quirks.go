@@ -0,0 +1,171 @@
+package quirks
+
+import (
+	"time"
+	"strings"
+)
+
+// How brands and single devices differ from a generic android device
+// Supporting a new brand only needs an entry in Brands,
+// devices which differ from their brand get an entry in Devices
+
+// Unlock strategies
+const (
+	// No unlocking needed, e.g. on samsung devices
+	UnlockNone = "none"
+	// fastboot oem unlock
+	UnlockOem = "oem_unlock"
+	// fastboot oem unlock <code>
+	UnlockOemCode = "oem_unlock_code"
+	// fastboot oem unlock 0x<code>
+	UnlockOemHexCode = "oem_unlock_hex_code"
+	// fastboot flashing unlock, the code is entered on the device
+	UnlockFlashing = "flashing_unlock"
+)
+
+// Valid values of the unlock_method of the device profiles, empty keeps the quirks
+var UnlockMethods = []string{"", UnlockNone, UnlockOem, UnlockOemCode, UnlockOemHexCode, UnlockFlashing}
+
+// Sources of the data the manufacturer needs to hand out an unlock code
+const (
+	UnlockDataNone = "none"
+	// fastboot oem get_unlock_data
+	UnlockDataFastboot = "oem_get_unlock_data"
+	UnlockDataImei = "imei"
+	UnlockDataImeiSerial = "imei_serial"
+)
+
+// Valid values of the unlock_data of the device profiles
+var UnlockDataSources = []string{"", UnlockDataNone, UnlockDataFastboot, UnlockDataImei, UnlockDataImeiSerial}
+
+// Tools booting or flashing the recovery
+const (
+	RecoveryFastboot = "fastboot"
+	RecoveryHeimdall = "heimdall"
+)
+
+// Behaviour after unlocking
+const (
+	// Watch if the device reboots
+	AfterUnlockObserve = "observe"
+	// The device wipes itself and reboots to android
+	AfterUnlockWipe = "wipe"
+)
+
+type Quirks struct {
+	// Target of adb reboot to get into the bootloader
+	Bootloader_verb string
+	Unlock string
+	Unlock_data string
+	Recovery_boot string
+	// Time the bootloader needs after connecting before it accepts commands
+	Bootloader_delay time.Duration
+	// Time to wait after unlocking before checking the device again
+	Unlock_delay time.Duration
+	After_unlock string
+}
+
+// Used for the fields a brand or device does not set
+var Generic = Quirks{
+	Bootloader_verb: "fastboot",
+	Unlock: UnlockOem,
+	Unlock_data: UnlockDataNone,
+	Recovery_boot: RecoveryFastboot,
+	Unlock_delay: 5 * time.Second,
+	After_unlock: AfterUnlockObserve,
+}
+
+var Brands = map[string]Quirks{
+	"samsung": {
+		Bootloader_verb: "heimdall",
+		Unlock: UnlockNone,
+		Recovery_boot: RecoveryHeimdall,
+	},
+	"motorola": {
+		Unlock: UnlockOemCode,
+		Unlock_data: UnlockDataFastboot,
+	},
+	"sony": {
+		Unlock: UnlockOemHexCode,
+		Unlock_data: UnlockDataImei,
+	},
+	"fairphone": {
+		Unlock: UnlockFlashing,
+		Unlock_data: UnlockDataImeiSerial,
+		After_unlock: AfterUnlockWipe,
+	},
+	"oneplus": {
+		After_unlock: AfterUnlockWipe,
+	},
+	"nvidia": {},
+}
+
+// Keyed by codename
+var Devices = map[string]Quirks{
+	// Unlocked without a code from Fairphone
+	"FP2": {
+		Unlock_data: UnlockDataNone,
+	},
+}
+
+// True if this app knows how to unlock the bootloader of the brand or device
+// An override setting the unlock method makes any device unlockable
+func Unlockable(brand string, codename string, overrides ...Quirks) bool {
+	_, known := Brands[strings.ToLower(brand)]
+	if !known && codename != "" {
+		_, known = Devices[codename]
+	}
+	for _, o := range overrides {
+		known = known || o.Unlock != ""
+	}
+	return known
+}
+
+// Returns the quirks of the device, the codename may be empty
+// The overrides are applied last, e.g. the ones of the device profiles
+func Of(brand string, codename string, overrides ...Quirks) Quirks {
+	q := Generic
+	q.merge(Brands[strings.ToLower(brand)])
+	if codename != "" {
+		q.merge(Devices[codename])
+	}
+	for _, o := range overrides {
+		q.merge(o)
+	}
+	return q
+}
+
+// True if the user has to obtain an unlock code from the manufacturer
+func (q Quirks) NeedsUnlockData() bool {
+	return q.Unlock_data != UnlockDataNone
+}
+
+// True if the unlock code is passed to fastboot
+func (q Quirks) TakesUnlockCode() bool {
+	return q.Unlock == UnlockOemCode || q.Unlock == UnlockOemHexCode
+}
+
+// Overwrites the fields which are set in o
+func (q *Quirks) merge(o Quirks) {
+	if o.Bootloader_verb != "" {
+		q.Bootloader_verb = o.Bootloader_verb
+	}
+	if o.Unlock != "" {
+		q.Unlock = o.Unlock
+	}
+	if o.Unlock_data != "" {
+		q.Unlock_data = o.Unlock_data
+	}
+	if o.Recovery_boot != "" {
+		q.Recovery_boot = o.Recovery_boot
+	}
+	if o.Bootloader_delay != 0 {
+		q.Bootloader_delay = o.Bootloader_delay
+	}
+	if o.Unlock_delay != 0 {
+		q.Unlock_delay = o.Unlock_delay
+	}
+	if o.After_unlock != "" {
+		q.After_unlock = o.After_unlock
+	}
+}
@@ -0,0 +1,397 @@
+package sim
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/amo13/anarchy-droid/device/usb"
+)
+
+// Output formats mimic the real binaries closely enough
+// for the parsers in the adb, fastboot, heimdall and twrp packages
+
+const adbDisconnected = "error: no devices/emulators found\n"
+
+type adbRunner struct {
+	p *Phone
+}
+
+func (r *adbRunner) Run(args ...string) (stdout string, stderr string) {
+	p := r.p
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.advance()
+
+	if len(args) == 0 {
+		return "", "adb: no command given\n"
+	}
+	switch args[0] {
+	case "start-server", "kill-server", "version":
+		return "", ""
+	}
+
+	if p.mode != ModeAndroid && p.mode != ModeBooting && p.mode != ModeRecovery && p.mode != ModeSideload {
+		return "", adbDisconnected
+	}
+	failed, stdout, stderr := p.failure("adb", args)
+	if failed {
+		// TWRP leaves the sideload mode after a failed transfer or installation too
+		if args[0] == "sideload" && p.mode == ModeSideload {
+			p.schedule(to(ModeRecovery, 0))
+		}
+		return stdout, stderr
+	}
+
+	switch args[0] {
+	case "get-state":
+		if p.mode == ModeAndroid || p.mode == ModeBooting {
+			return "device\n", ""
+		}
+		return p.mode + "\n", ""
+	case "reboot":
+		target := ""
+		if len(args) > 1 {
+			target = args[1]
+		}
+		switch target {
+		case "bootloader":
+			p.reboot(ModeFastboot)
+		case "download":
+			p.reboot(ModeHeimdall)
+		case "recovery":
+			p.reboot(ModeRecovery)
+		case "sideload", "sideload-auto-reboot":
+			p.reboot(ModeSideload)
+		default:
+			p.reboot(ModeAndroid)
+		}
+		return "", ""
+	case "sideload":
+		if p.mode != ModeSideload || len(args) < 2 {
+			return "", "adb: sideload connection failed: closed\n"
+		}
+		p.wait(p.Config.Timings.Sideload)
+		// The rom installed by the sideload is custom built
+		p.props["ro.build.type"] = "userdebug"
+		p.log = append(p.log,
+			"Installing zip file '/sideload/package.zip'",
+			"Installing " + filepath.Base(args[len(args)-1]) + "...",
+			"Updater process ended with RC=0",
+			"I:Install took 5 second(s).")
+		p.schedule(to(ModeRecovery, 0))
+		return "Total xfer: 1.00x\n", ""
+	case "push":
+		if len(args) < 3 {
+			return "", "adb: push requires an argument\n"
+		}
+		// The remote path is either the file or its directory
+		remote := args[len(args)-1]
+		p.files[remote] = true
+		p.files[strings.TrimSuffix(remote, "/") + "/" + filepath.Base(args[1])] = true
+		return args[1] + ": 1 file pushed, 0 skipped.\n", ""
+	case "pull":
+		if len(args) < 3 {
+			return "", "adb: pull requires an argument\n"
+		}
+		return p.pull(args[1], args[2])
+	case "shell":
+		if p.mode == ModeSideload {
+			return "", "error: closed\n"
+		}
+		return p.shell(args[1:])
+	case "remount", "root", "unroot":
+		return "", ""
+	}
+
+	return "", "adb: unknown command " + args[0] + "\n"
+}
+
+func (p *Phone) pull(remote string, local string) (stdout string, stderr string) {
+	if remote != "/tmp/recovery.log" || p.mode != ModeRecovery {
+		return "", "adb: error: failed to stat remote object '" + remote + "': No such file or directory\n"
+	}
+
+	info, err := os.Stat(local)
+	if (err == nil && info.IsDir()) || strings.HasSuffix(local, "/") {
+		local = filepath.Join(local, filepath.Base(remote))
+	}
+	err = ioutil.WriteFile(local, []byte(p.recoveryLog()), 0644)
+	if err != nil {
+		return "", "adb: error: cannot create '" + local + "': " + err.Error() + "\n"
+	}
+
+	return remote + ": 1 file pulled, 0 skipped.\n", ""
+}
+
+func (p *Phone) recoveryLog() string {
+	return "Starting TWRP " + p.Config.Twrp_version + " on " + p.Config.Model + "\n" +
+		"/data | /dev/block/bootdevice/by-name/userdata | Size: 24576MB\n" +
+		"Set page: 'main'\n" +
+		"Set page: 'clear_vars'\n" +
+		"Set page: 'main2'\n" +
+		strings.Join(append(p.log, ""), "\n")
+}
+
+func (p *Phone) shell(args []string) (stdout string, stderr string) {
+	if len(args) == 0 {
+		return "", ""
+	}
+
+	switch args[0] {
+	case "getprop":
+		if len(args) > 1 {
+			if p.mode == ModeBooting && (args[1] == "dev.bootcomplete" || args[1] == "sys.boot_completed") {
+				return "\n", ""
+			}
+			return p.props[args[1]] + "\n", ""
+		}
+		keys := make([]string, 0, len(p.props))
+		for k := range p.props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		result := ""
+		for _, k := range keys {
+			result = result + "[" + k + "]: [" + p.props[k] + "]\n"
+		}
+		return result, ""
+	case "setprop":
+		if len(args) > 2 {
+			p.props[args[1]] = args[2]
+		}
+		return "", ""
+	case "whoami":
+		if p.mode == ModeRecovery {
+			return "root\n", ""
+		}
+		return "shell\n", ""
+	case "service":
+		// Parcel with one digit followed by a dot per character
+		return "Result: Parcel(\n  0x00000000: 00000000 0000000f '" + strings.Join(strings.Split(p.Config.Imei, ""), ".") + ".')\n", ""
+	case "dumpsys":
+		return "Phone Subscriber Info:\n  Device ID=" + p.Config.Imei + "\n", ""
+	case "am":
+		return "Starting: Intent { cmp=" + args[len(args)-1] + " }\n", ""
+	case "pm":
+		result := ""
+		for _, name := range p.Config.Packages {
+			result = result + "package:" + name + "\n"
+		}
+		return result, ""
+	case "ls":
+		if len(args) > 1 && p.files[args[1]] {
+			return args[1] + "\n", ""
+		}
+		return "", "ls: " + strings.Join(args[1:], " ") + ": No such file or directory\n"
+	case "cat":
+		if len(args) < 2 {
+			return "", ""
+		}
+		switch args[1] {
+		case "/proc/mounts":
+			if p.data_mounted {
+				return "/dev/block/bootdevice/by-name/userdata /data ext4 rw,seclabel,relatime 0 0\n", ""
+			}
+			return "rootfs / rootfs rw 0 0\n", ""
+		case "/etc/fstab":
+			return "/dev/block/bootdevice/by-name/userdata /data ext4 rw 0 0\n", ""
+		case "/etc/recovery.fstab":
+			return "/data ext4 /dev/block/bootdevice/by-name/userdata flags=encryptable=footer\n", ""
+		}
+		return "", "cat: " + args[1] + ": No such file or directory\n"
+	case "mount":
+		p.data_mounted = true
+		return "", ""
+	case "umount":
+		p.data_mounted = false
+		return "", ""
+	case "twrp":
+		if p.mode != ModeRecovery {
+			return "", "/system/bin/sh: twrp: not found\n"
+		}
+		return p.twrp(args[1:])
+	}
+
+	return "", ""
+}
+
+// Simulates the TWRP OpenRecoveryScript commands
+func (p *Phone) twrp(args []string) (stdout string, stderr string) {
+	if len(args) == 0 {
+		return "", ""
+	}
+
+	switch args[0] {
+	case "version":
+		return "TWRP version " + p.Config.Twrp_version + "\n", ""
+	case "wipe":
+		p.wait(p.Config.Timings.Wipe)
+		return "Wiping " + strings.Join(args[1:], " ") + "\nDone processing script file\n", ""
+	case "format":
+		p.wait(p.Config.Timings.Wipe)
+		// Formatting data also removes the custom rom
+		p.props["ro.build.type"] = "user"
+		return "Formatting Data using make_ext4fs...\nDone processing script file\n", ""
+	case "sideload":
+		p.schedule(to(ModeSideload, 0))
+		return "Starting ADB sideload feature...\n", ""
+	}
+
+	return "Unrecognized script command: '" + args[0] + "'\n", ""
+}
+
+type fastbootRunner struct {
+	p *Phone
+}
+
+func (r *fastbootRunner) Run(args ...string) (stdout string, stderr string) {
+	p := r.p
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.advance()
+
+	if p.mode != ModeFastboot {
+		if len(args) > 0 && args[0] == "devices" {
+			return "", ""
+		}
+		return "", "< waiting for any device >\n"
+	}
+	if len(args) == 0 {
+		return "", "fastboot: usage: no command\n"
+	}
+	failed, stdout, stderr := p.failure("fastboot", args)
+	if failed {
+		return stdout, stderr
+	}
+
+	switch args[0] {
+	case "devices":
+		return p.Config.Serial + "\tfastboot\n", ""
+	case "getvar":
+		unlocked := "no"
+		if p.unlocked {
+			unlocked = "yes"
+		}
+		slot_count := "0"
+		if p.Config.Ab {
+			slot_count = "2"
+		}
+		return "", "(bootloader) product: " + p.Config.Codename + "\n" +
+			"(bootloader) serialno: " + p.Config.Serial + "\n" +
+			"(bootloader) slot-count: " + slot_count + "\n" +
+			"(bootloader) unlocked: " + unlocked + "\n" +
+			"all: \nFinished. Total time: 0.010s\n"
+	case "reboot":
+		if len(args) > 1 && args[1] == "bootloader" {
+			p.reboot(ModeFastboot)
+		} else {
+			p.reboot(ModeAndroid)
+		}
+		return "", "Rebooting\nFinished. Total time: 0.050s\n"
+	case "oem", "flashing":
+		if len(args) > 1 && args[1] == "get_unlock_data" {
+			if p.unlocked {
+				return "", "(bootloader) Device already unlocked\nOKAY [  0.010s]\n"
+			}
+			return "", "(bootloader) 3A25950132030808#\n(bootloader) 5A593232314B4C46\n(bootloader) 00000000#E5D9C3A1\n(bootloader) 6F1E2C4B00000000\nOKAY [  0.050s]\nFinished. Total time: 0.050s\n"
+		}
+		if len(args) > 1 && args[1] == "unlock" {
+			p.unlocked = true
+			return "", "OKAY [  0.100s]\nFinished. Total time: 0.100s\n"
+		}
+		return "", "FAILED (remote: 'unknown command')\n"
+	case "boot":
+		if !p.unlocked {
+			return "", "Sending 'boot.img'\nFAILED (remote: 'not allowed in locked state')\n"
+		}
+		p.wait(p.Config.Timings.Flash)
+		p.schedule(to(ModeOff, 0), to(ModeRecovery, p.Config.Timings.Reboot))
+		return "", "Sending 'boot.img' (32768 KB)  OKAY [  1.000s]\nBooting  OKAY [  0.500s]\nFinished. Total time: 1.600s\n"
+	case "flash":
+		if len(args) < 3 {
+			return "", "fastboot: usage: no image file specified\n"
+		}
+		if !p.unlocked {
+			return "", "Sending '" + args[1] + "'\nFAILED (remote: 'not allowed in locked state')\n"
+		}
+		p.wait(p.Config.Timings.Flash)
+		result := "Sending '" + args[1] + "' (32768 KB)  OKAY [  1.000s]\nWriting '" + args[1] + "'  OKAY [  0.300s]\nFinished. Total time: 1.400s\n"
+		if strings.HasPrefix(strings.ToLower(args[1]), "recovery") {
+			p.twrp_installed = true
+			p.userBootsRecovery()
+		}
+		return "", result
+	}
+
+	return "", "fastboot: usage: unknown command " + args[0] + "\n"
+}
+
+type heimdallRunner struct {
+	p *Phone
+}
+
+func (r *heimdallRunner) Run(args ...string) (stdout string, stderr string) {
+	p := r.p
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.advance()
+
+	if p.mode != ModeHeimdall {
+		if len(args) > 0 && args[0] == "detect" {
+			return "", ""
+		}
+		return "", "ERROR: Failed to detect compatible download-mode device.\n"
+	}
+	if len(args) == 0 {
+		return "", "ERROR: no action specified\n"
+	}
+	failed, stdout, stderr := p.failure("heimdall", args)
+	if failed {
+		return stdout, stderr
+	}
+
+	switch args[0] {
+	case "detect":
+		return "Device detected\n", ""
+	case "print-pit":
+		p.reboot(ModeAndroid)
+		return "Downloading device's PIT file...\nPIT file download successful.\nRebooting device...\n", ""
+	case "flash":
+		p.wait(p.Config.Timings.Flash)
+		partition := ""
+		if len(args) > 1 {
+			partition = strings.TrimPrefix(args[1], "--")
+		}
+		if strings.HasPrefix(strings.ToLower(partition), "recovery") {
+			p.twrp_installed = true
+			p.userBootsRecovery()
+		}
+		return "Uploading " + partition + "\n100%\n" + partition + " upload successful\n", ""
+	}
+
+	return "", "ERROR: unknown action " + args[0] + "\n"
+}
+
+type usbLister struct {
+	p *Phone
+}
+
+// Only the modes adb, fastboot and heimdall cannot see are listed
+func (l *usbLister) List() ([]*usb.Device, error) {
+	p := l.p
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.advance()
+
+	switch p.mode {
+	case ModeEdl:
+		return []*usb.Device{{Name: "1-1", Vendor_id: "05c6", Product_id: "9008", Manufacturer: "Qualcomm CDMA Technologies MSM", Product: "QUSB__BULK"}}, nil
+	case ModeMtkPreloader:
+		return []*usb.Device{{Name: "1-1", Vendor_id: "0e8d", Product_id: "2000", Manufacturer: "MediaTek", Product: "MT65xx Preloader"}}, nil
+	}
+
+	return []*usb.Device{}, nil
+}
@@ -0,0 +1,345 @@
+package sim
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"strings"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/device/adb"
+	"github.com/amo13/anarchy-droid/device/fastboot"
+	"github.com/amo13/anarchy-droid/device/heimdall"
+	"github.com/amo13/anarchy-droid/device/usb"
+)
+
+// Simulated phone answering the adb, fastboot and heimdall commands
+// like a real device would, so the complete flashing procedure can be
+// rehearsed and tested without a phone. Example configuration:
+//
+//	model: Moto G5 Plus
+//	brand: motorola
+//	unlocked: false
+//	timings:
+//	  reboot: 2s
+//	  sideload: 10s
+//	failures:
+//	  - backend: adb
+//	    command: sideload
+//	    times: 1
+//	    stderr: "error: device offline"
+//	  - backend: adb
+//	    command: sideload
+//	    times: 1
+//	    log: |
+//	      Installing zip file '/sideload/package.zip'
+//	      E3004: This package is for device: other; this device is sim.
+//	      Updater process ended with ERROR: 7
+
+const (
+	ModeOff = "off"	// rebooting or switched off, not reachable
+	ModeAndroid = "android"
+	ModeBooting = "booting"
+	ModeRecovery = "recovery"
+	ModeStockRecovery = "stock_recovery"	// no adb access
+	ModeSideload = "sideload"
+	ModeFastboot = "fastboot"
+	ModeHeimdall = "heimdall"
+	// Only visible on the usb bus, the simulated user gets the phone out of them after a while
+	ModeEdl = "edl"
+	ModeMtkPreloader = "mtk_preloader"
+)
+
+type Config struct {
+	Model string `yaml:"model"`
+	Codename string `yaml:"codename,omitempty"`
+	Brand string `yaml:"brand,omitempty"`
+	Android_version string `yaml:"android_version,omitempty"`
+	Serial string `yaml:"serial,omitempty"`
+	Imei string `yaml:"imei,omitempty"`
+	Arch string `yaml:"arch,omitempty"`
+	Ab bool `yaml:"ab,omitempty"`
+	Unlocked bool `yaml:"unlocked,omitempty"`
+	// TWRP is installed on the recovery partition
+	Twrp bool `yaml:"twrp,omitempty"`
+	Twrp_version string `yaml:"twrp_version,omitempty"`
+	// Mode the phone is in when the simulation starts
+	Start string `yaml:"start,omitempty"`
+	// Additional adb props, overriding the generated ones
+	Props map[string]string `yaml:"props,omitempty"`
+	Packages []string `yaml:"packages,omitempty"`
+	Timings Timings `yaml:"timings,omitempty"`
+	Failures []*Failure `yaml:"failures,omitempty"`
+}
+
+type Timings struct {
+	// Disconnected while rebooting
+	Reboot time.Duration `yaml:"reboot,omitempty"`
+	// Connected to adb but boot not completed
+	Boot time.Duration `yaml:"boot,omitempty"`
+	Sideload time.Duration `yaml:"sideload,omitempty"`
+	Wipe time.Duration `yaml:"wipe,omitempty"`
+	// Sending an image with fastboot or heimdall
+	Flash time.Duration `yaml:"flash,omitempty"`
+	// Until the simulated user pressed the key combination for recovery
+	User time.Duration `yaml:"user,omitempty"`
+}
+
+// Answers matching commands with the given output instead of simulating them
+type Failure struct {
+	// adb, fastboot or heimdall
+	Backend string `yaml:"backend"`
+	// Prefix of the command arguments, e.g. "sideload" or "shell twrp wipe"
+	Command string `yaml:"command"`
+	// Fail only the first times, always if 0
+	Times int `yaml:"times,omitempty"`
+	Stdout string `yaml:"stdout,omitempty"`
+	Stderr string `yaml:"stderr,omitempty"`
+	// Lines added to the recovery log, e.g. of a failed installation
+	Log string `yaml:"log,omitempty"`
+	hits int
+}
+
+var DefaultTimings = Timings{
+	Reboot: 3 * time.Second,
+	Boot: 5 * time.Second,
+	Sideload: 5 * time.Second,
+	Wipe: 1 * time.Second,
+	Flash: 2 * time.Second,
+	User: 5 * time.Second,
+}
+
+type Phone struct {
+	Config *Config
+	mutex sync.Mutex
+	mode string
+	pending []*transition
+	unlocked bool
+	twrp_installed bool
+	data_mounted bool
+	props map[string]string
+	// Remote paths pushed with adb
+	files map[string]bool
+	// Recovery log lines written since TWRP booted
+	log []string
+}
+
+type transition struct {
+	mode string
+	after time.Duration
+	at time.Time
+}
+
+func to(mode string, after time.Duration) *transition {
+	return &transition{mode: mode, after: after}
+}
+
+func NewConfig(model string) *Config {
+	return &Config{
+		Model: model,
+		Timings: DefaultTimings,
+	}
+}
+
+func LoadConfig(file_path string) (*Config, error) {
+	yamldata, err := ioutil.ReadFile(file_path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewConfig("")
+	err = yaml.Unmarshal(yamldata, c)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func NewPhone(c *Config) *Phone {
+	if c.Android_version == "" {
+		c.Android_version = "11"
+	}
+	if c.Serial == "" {
+		c.Serial = "SIM0123456789"
+	}
+	if c.Imei == "" {
+		c.Imei = "356938035643809"
+	}
+	if c.Arch == "" {
+		c.Arch = "arm64-v8a"
+	}
+	if c.Twrp_version == "" {
+		c.Twrp_version = "3.6.2_9-0"
+	}
+	if c.Start == "" {
+		c.Start = ModeAndroid
+	}
+
+	p := &Phone{
+		Config: c,
+		mode: c.Start,
+		unlocked: c.Unlocked,
+		twrp_installed: c.Twrp,
+		data_mounted: true,
+		files: make(map[string]bool),
+	}
+
+	p.props = map[string]string{
+		"ro.product.model": c.Model,
+		"ro.product.brand": c.Brand,
+		"ro.product.manufacturer": c.Brand,
+		"ro.product.device": c.Codename,
+		"ro.build.product": c.Codename,
+		"ro.build.version.release": c.Android_version,
+		"ro.build.type": "user",
+		"ro.product.cpu.abi": c.Arch,
+		"ro.serialno": c.Serial,
+		"ro.build.ab_update": fmt.Sprintf("%t", c.Ab),
+		"dev.bootcomplete": "1",
+		"sys.boot_completed": "1",
+	}
+	for k, v := range c.Props {
+		p.props[k] = v
+	}
+
+	if p.mode == ModeEdl || p.mode == ModeMtkPreloader {
+		t := c.Timings
+		p.schedule(to(p.mode, 0), to(ModeOff, t.User),
+			to(ModeBooting, t.Reboot), to(ModeAndroid, t.Boot))
+	}
+
+	return p
+}
+
+// Routes all adb, fastboot and heimdall commands to the phone
+func Install(p *Phone) {
+	logger.Log("Simulating a " + p.Config.Model + " in " + p.Config.Start + " mode")
+	adb.Backend = &adbRunner{p}
+	fastboot.Backend = &fastbootRunner{p}
+	heimdall.Backend = &heimdallRunner{p}
+	usb.Backend = &usbLister{p}
+}
+
+// Returns the current mode after applying the due transitions
+func (p *Phone) Mode() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.advance()
+	return p.mode
+}
+
+// Must be called with the mutex held
+func (p *Phone) advance() {
+	now := time.Now()
+	for len(p.pending) > 0 && !now.Before(p.pending[0].at) {
+		if p.mode != p.pending[0].mode {
+			logger.Log("Simulation: " + p.mode + " -> " + p.pending[0].mode)
+		}
+		p.mode = p.pending[0].mode
+		p.pending = p.pending[1:]
+	}
+}
+
+// Replaces the pending transitions, each one is reached
+// after its delay counted from the previous one
+// Must be called with the mutex held
+func (p *Phone) schedule(transitions ...*transition) {
+	at := time.Now()
+	for _, t := range transitions {
+		at = at.Add(t.after)
+		t.at = at
+	}
+	p.pending = transitions
+	p.advance()
+}
+
+// Must be called with the mutex held
+func (p *Phone) reboot(target string) {
+	t := p.Config.Timings
+	p.log = nil
+	switch target {
+	case ModeRecovery:
+		if p.twrp_installed {
+			p.schedule(to(ModeOff, 0), to(ModeRecovery, t.Reboot))
+		} else {
+			// The stock recovery offers no adb, the simulated user reboots it after a while
+			p.schedule(to(ModeOff, 0), to(ModeStockRecovery, t.Reboot),
+				to(ModeOff, t.User), to(ModeBooting, t.Reboot),
+				to(ModeAndroid, t.Boot))
+		}
+	case ModeSideload:
+		if p.twrp_installed {
+			p.schedule(to(ModeOff, 0), to(ModeSideload, t.Reboot))
+		} else {
+			p.reboot(ModeRecovery)
+		}
+	case ModeFastboot, ModeHeimdall:
+		p.schedule(to(ModeOff, 0), to(target, t.Reboot))
+	default:
+		p.schedule(to(ModeOff, 0), to(ModeBooting, t.Reboot),
+			to(ModeAndroid, t.Boot))
+	}
+}
+
+// The simulated user holds the key combination to boot the flashed recovery
+// Must be called with the mutex held
+func (p *Phone) userBootsRecovery() {
+	t := p.Config.Timings
+	p.schedule(to(p.mode, 0), to(ModeOff, t.User),
+		to(ModeRecovery, t.Reboot))
+}
+
+// Returns the output of a configured failure matching the command
+// Must be called with the mutex held
+func (p *Phone) failure(backend string, args []string) (matched bool, stdout string, stderr string) {
+	f := p.matchFailure(backend, args)
+	if f == nil {
+		return false, "", ""
+	}
+
+	if f.Log != "" {
+		p.log = append(p.log, strings.Split(strings.TrimRight(f.Log, "\n"), "\n")...)
+	}
+	return true, f.Stdout, f.Stderr
+}
+
+// Must be called with the mutex held
+func (p *Phone) matchFailure(backend string, args []string) *Failure {
+	command := strings.Join(args, " ")
+	for _, f := range p.Config.Failures {
+		if f.Backend != backend || !strings.HasPrefix(command, f.Command) {
+			continue
+		}
+		if f.Times > 0 && f.hits >= f.Times {
+			continue
+		}
+		f.hits = f.hits + 1
+		logger.Log("Simulation: failing " + backend + " " + command)
+		return f
+	}
+
+	return nil
+}
+
+// Sleeps without holding the mutex so the phone can be observed meanwhile
+// Must be called with the mutex held
+func (p *Phone) wait(d time.Duration) {
+	p.mutex.Unlock()
+	time.Sleep(d)
+	p.mutex.Lock()
+}
+
+func (p *Phone) Unlocked() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.unlocked
+}
+
+func (p *Phone) TwrpInstalled() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.twrp_installed
+}
@@ -0,0 +1,244 @@
+package twrp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/helpers"
+)
+
+// Turns the recovery.log into typed events about the installed zip files
+
+const (
+	EventUpdaterStart = "updater_start"
+	EventUpdaterEnd = "updater_end"
+	// Output of the installer script shown on the device screen
+	EventUiPrint = "ui_print"
+	EventMountError = "mount_error"
+	EventOutOfSpace = "out_of_space"
+	EventSignatureFailure = "signature_failure"
+	// The zip is not meant for this device or its firmware
+	EventAssertFailure = "assert_failure"
+)
+
+type LogEvent struct {
+	Kind string
+	// Line number in the recovery.log, starting at 1
+	Line int
+	Text string
+	// Zip file of updater_start events
+	File string
+	// Return code of updater_end events, -1 if the updater was killed
+	Return_code int
+}
+
+func (e *LogEvent) String() string {
+	switch e.Kind {
+	case EventUpdaterStart:
+		return fmt.Sprintf("%d %s %s", e.Line, e.Kind, e.File)
+	case EventUpdaterEnd:
+		return fmt.Sprintf("%d %s %d", e.Line, e.Kind, e.Return_code)
+	default:
+		return fmt.Sprintf("%d %s %s", e.Line, e.Kind, e.Text)
+	}
+}
+
+var log_install_start = regexp.MustCompile(`^Installing zip file '(.*)'`)
+var log_updater_end = regexp.MustCompile(`Updater process ended with (?:RC=(\d+)|ERROR: (\d+)|signal: (\d+))`)
+var log_mount_error = regexp.MustCompile(`(?i)(failed to mount|unable to mount|cannot mount|mount: mounting .* failed)`)
+var log_out_of_space = regexp.MustCompile(`(?i)(no space left on device|not enough space|less than \d+ mb free space|unzip: failed to extract /dev/tmp/)`)
+var log_signature_failure = regexp.MustCompile(`(?i)(signature verification failed|failed to verify whole-file signature)`)
+var log_assert_failure = regexp.MustCompile(`(?i)(assert failed|this package is for (device|"[^"]*" devices)|E3004|package expects build fingerprint)`)
+// Lines logged by TWRP itself, everything else during an installation is the script's output
+var log_twrp_line = regexp.MustCompile(`^([IEW]:|Set page: |Updater process ended|Installing zip file|Zip signature|Verifying zip|Skipping zip|Set block_dev|ADB Sideload)`)
+
+// Keeps track of the lines already parsed, so the events
+// of each sideload can be told apart from the earlier ones
+type LogParser struct {
+	offset int
+	installing bool
+}
+
+// Parses the whole log
+func ParseLog(log string) []*LogEvent {
+	p := &LogParser{}
+	return p.Parse(log)
+}
+
+// Returns the events of the lines added since the last call
+// TWRP starts a new log when it boots, a shorter log is parsed from the beginning
+func (p *LogParser) Parse(log string) []*LogEvent {
+	lines := helpers.StringToLinesSlice(log)
+	if len(lines) < p.offset {
+		p.offset = 0
+		p.installing = false
+	}
+
+	events := []*LogEvent{}
+	for i := p.offset; i < len(lines); i++ {
+		e := p.parseLine(strings.TrimRight(lines[i], "\r"))
+		if e != nil {
+			e.Line = i + 1
+			events = append(events, e)
+		}
+	}
+	p.offset = len(lines)
+
+	return events
+}
+
+// Skips the lines logged so far
+func (p *LogParser) Skip(log string) {
+	p.offset = len(helpers.StringToLinesSlice(log))
+	p.installing = false
+}
+
+func (p *LogParser) parseLine(line string) *LogEvent {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+
+	if m := log_install_start.FindStringSubmatch(line); m != nil {
+		p.installing = true
+		return &LogEvent{Kind: EventUpdaterStart, Text: line, File: m[1]}
+	}
+	if m := log_updater_end.FindStringSubmatch(line); m != nil {
+		p.installing = false
+		rc := -1
+		for _, group := range m[1:3] {
+			if group != "" {
+				rc, _ = strconv.Atoi(group)
+			}
+		}
+		return &LogEvent{Kind: EventUpdaterEnd, Text: line, Return_code: rc}
+	}
+
+	switch {
+	case log_signature_failure.MatchString(line):
+		return &LogEvent{Kind: EventSignatureFailure, Text: line}
+	case log_assert_failure.MatchString(line):
+		return &LogEvent{Kind: EventAssertFailure, Text: line}
+	case log_out_of_space.MatchString(line):
+		return &LogEvent{Kind: EventOutOfSpace, Text: line}
+	case log_mount_error.MatchString(line):
+		return &LogEvent{Kind: EventMountError, Text: line}
+	}
+
+	if p.installing && !log_twrp_line.MatchString(line) {
+		return &LogEvent{Kind: EventUiPrint, Text: strings.TrimSpace(line)}
+	}
+
+	return nil
+}
+
+// Outcome of one installed zip file
+type InstallResult struct {
+	File string
+	// False if the updater has not finished (yet)
+	Finished bool
+	Return_code int
+	Events []*LogEvent
+}
+
+// Groups the events by installation, events before the first one are ignored
+func Installs(events []*LogEvent) []*InstallResult {
+	results := []*InstallResult{}
+	var current *InstallResult
+	for _, e := range events {
+		if e.Kind == EventUpdaterStart {
+			current = &InstallResult{File: e.File, Return_code: -1}
+			results = append(results, current)
+		}
+		if current == nil {
+			continue
+		}
+		current.Events = append(current.Events, e)
+		if e.Kind == EventUpdaterEnd {
+			current.Finished = true
+			current.Return_code = e.Return_code
+			current = nil
+		}
+	}
+
+	return results
+}
+
+func (r *InstallResult) Has(kind string) bool {
+	for _, e := range r.Events {
+		if e.Kind == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// True if the script printed a line containing s
+func (r *InstallResult) Printed(s string) bool {
+	for _, e := range r.Events {
+		if e.Kind == EventUiPrint && strings.Contains(e.Text, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns nil if the installation succeeded, otherwise an error naming the likely cause
+func (r *InstallResult) Err() error {
+	switch {
+	case r.Has(EventSignatureFailure):
+		return fmt.Errorf("signature verification failed")
+	case r.Has(EventAssertFailure):
+		return fmt.Errorf("the zip is not meant for this device or firmware")
+	case r.Has(EventOutOfSpace):
+		return fmt.Errorf("not enough space")
+	case !r.Finished:
+		return fmt.Errorf("the installation has not finished")
+	case r.Return_code != 0:
+		if r.Has(EventMountError) {
+			return fmt.Errorf("installation failed with code %d after a mount error", r.Return_code)
+		}
+		return fmt.Errorf("installation failed with code %d", r.Return_code)
+	}
+
+	return nil
+}
+
+// Tracks the recovery.log across sideloads
+var sideload_parser = &LogParser{}
+
+// Remembers the current end of the log, call before opening the sideload
+func MarkLog() error {
+	log, err := GetAndReadLog()
+	if err != nil {
+		return err
+	}
+
+	sideload_parser.Skip(log)
+	return nil
+}
+
+// Returns the result of the last zip file installed since MarkLog
+// TWRP has to be back from sideload mode to read its log
+func LastSideloadResult() (*InstallResult, error) {
+	log, err := GetAndReadLog()
+	if err != nil {
+		return nil, err
+	}
+
+	events := sideload_parser.Parse(log)
+	for _, e := range events {
+		logger.Debug("Recovery log: " + e.String())
+	}
+
+	installs := Installs(events)
+	if len(installs) == 0 {
+		return nil, fmt.Errorf("no installation found in the recovery log")
+	}
+
+	return installs[len(installs) - 1], nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/amo13/anarchy-droid/logger"
 	"github.com/amo13/anarchy-droid/helpers"
 	"github.com/amo13/anarchy-droid/device/adb"
+	"github.com/amo13/anarchy-droid/device/errs"
 )
 
 const Logpath = "log/"
@@ -22,7 +23,7 @@ func Cmd(args ...string) (stdout string, err error) {
 // Check for disconnection error or suddenly unauthorized error
 func unavailable(err error) bool {
 	if err != nil {
-		if err.Error() == "disconnected" || err.Error() == "unauthorized" {
+		if errs.IsUnavailable(err) {
 			return true
 		} else {
 			logger.LogError("Unknown ADB error:", err)
@@ -570,80 +571,40 @@ func IsDataUsable() (bool, error) {
 	}
 }
 
-func WasLastSideloadSuccesful() (bool, error) {
-	// Count the number of lines in the recovery.log before pulling a file anew
-	log, err := ReadLog()
-	if err != nil {
-		if !os.IsNotExist(err) {
-			log = ""
-		} else {
-			return false, err
-		}
-	}
-
-	loglines := helpers.StringToLinesSlice(log)
-	last_lines_count := len(loglines)
-
-	log, err = GetAndReadLog()
+// Result of the last zip file installed according to the recovery.log
+func lastInstall() (*InstallResult, error) {
+	log, err := GetAndReadLog()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	new_lines_count := len(loglines)
-	if last_lines_count >= new_lines_count {
-		last_lines_count = 0
+	installs := Installs(ParseLog(log))
+	if len(installs) == 0 {
+		return nil, fmt.Errorf("Unable to parse last sideload success")
 	}
 
-	// reverse loglines order
-	for i, j := 0, len(loglines)-1; i < j; i, j = i+1, j-1 {
-        loglines[i], loglines[j] = loglines[j], loglines[i]
-    }
-
-    last := ""
+	return installs[len(installs) - 1], nil
+}
 
-    // Find the last occurence of "Updater process ended with"
-	for _, line := range loglines[last_lines_count:] {
-		if strings.Contains(line, "Updater process ended with") {
-			re := regexp.MustCompile(`Updater process ended with (.*)`)
-			last = re.FindAllStringSubmatch(line, -1)[0][1]
-			break
-		}
+func WasLastSideloadSuccesful() (bool, error) {
+	last, err := lastInstall()
+	if err != nil {
+		return false, err
 	}
-
-	switch last {
-	case "RC=0":
-		return true, nil
-	case "ERROR: 1":
-		return false, nil
-	case "":
+	if !last.Finished {
 		return false, fmt.Errorf("Unable to parse last sideload success")
-	default:
-		return false, fmt.Errorf("Unknown sideload result in the TWRP log")
 	}
+
+	return last.Return_code == 0, nil
 }
 
 func IsNanodroidMissingSpace() (bool, error) {
-	log, err := GetAndReadLog()
+	last, err := lastInstall()
 	if err != nil {
 		return false, err
 	}
 
-	loglines := helpers.StringToLinesSlice(log)
-
-	// I know, this is not very reliable, but I had no better idea
-	// Improvements are welcome!
-	if len(loglines) >= 100 {
-		for _, line := range loglines[len(loglines):] {
-			if strings.Contains(line, "Less than 512 MB free space availabe from TWRP") ||
-			strings.Contains(line, "No space left on device") ||
-			strings.Contains(line, "not enough space available!") ||
-			strings.Contains(line, "unzip: failed to extract /dev/tmp/") {
-				return true, nil
-			}
-		}
-	}
-
-	return false, nil
+	return last.Has(EventOutOfSpace), nil
 }
 
 func RomHasNativeSigspoof() (bool, error) {
@@ -652,31 +613,14 @@ func RomHasNativeSigspoof() (bool, error) {
 		return false, err
 	}
 
-	loglines := helpers.StringToLinesSlice(log)
-
-	// reverse loglines order
-	for i, j := 0, len(loglines)-1; i < j; i, j = i+1, j-1 {
-        loglines[i], loglines[j] = loglines[j], loglines[i]
-    }
-
-    // Search for the last occurence of "Framework Patcher"
-    last_index := 0
-    for i, line := range loglines {
-    	if strings.Contains(line, "Framework Patcher") {
-    		last_index = i
-    		break
-    	}
-    }
-
-    if last_index != 0 {
-	    for _, line := range loglines[:last_index] {
-	    	if strings.Contains(line, "ROM has native signature spoofing already") {
-	    		return true, nil
-	    	}
-	    }
-    } else {
-    	logger.Log("Unable to find the last logs of the Framework Patcher")
-    }
+	// Search for the last installation of the Framework Patcher
+	installs := Installs(ParseLog(log))
+	for i := len(installs) - 1; i >= 0; i-- {
+		if installs[i].Printed("Framework Patcher") {
+			return installs[i].Printed("ROM has native signature spoofing already"), nil
+		}
+	}
 
-    return false, nil
+	logger.Log("Unable to find the last logs of the Framework Patcher")
+	return false, nil
 }
\ No newline at end of file
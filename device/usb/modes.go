@@ -0,0 +1,89 @@
+package usb
+
+import (
+	"errors"
+
+	"github.com/amo13/anarchy-droid/lookup"
+	"github.com/amo13/anarchy-droid/device/errs"
+)
+
+// Modes of connected phones which adb, fastboot and heimdall cannot see
+const (
+	// Qualcomm emergency download mode
+	ModeEdl = "edl"
+	// MediaTek preloader or boot rom, entered briefly when connected while off
+	ModeMtkPreloader = "mtk_preloader"
+	// Samsung download mode which heimdall cannot access
+	ModeSamsungDownload = "samsung_download"
+	// adb or fastboot interface without access rights, e.g. missing udev rules
+	ModeNoPermission = "no_permission"
+	// Known phone connected for file transfer only
+	ModeDebuggingOff = "usb_debugging_off"
+)
+
+// Ordered by relevance, the first mode found is reported
+var Modes = []string{ModeEdl, ModeMtkPreloader, ModeSamsungDownload, ModeNoPermission, ModeDebuggingOff}
+
+const (
+	InterfaceAdb = "ff/42/01"
+	InterfaceFastboot = "ff/42/03"
+	InterfaceMtp = "ff/ff/00"
+	InterfacePtp = "06/01/01"
+)
+
+var Instructions = map[string]string{
+	ModeEdl: "Your device is in emergency download mode (EDL).\n\nHold the power button for about 10 seconds until it restarts, then let it boot normally.",
+	ModeMtkPreloader: "Your device is in the MediaTek preloader mode.\n\nDisconnect it, switch it on and connect it again once it has booted.",
+	ModeSamsungDownload: "Your Samsung device is in download mode, but it cannot be accessed.\n\nSet up the udev rules or run with root privileges, then reconnect the device.",
+	ModeNoPermission: "Your device is connected, but it cannot be accessed.\n\nSet up the udev rules or run with root privileges, then reconnect the device.",
+	ModeDebuggingOff: "Your device is connected, but USB debugging is off.\n\nIn Settings > About Phone: Tap 7 times on Build Number. Then in Settings > Developer Options: Activate USB Debugging.",
+}
+
+// Returns the mode of the device or an empty string if it is not one of the modes above
+func Classify(d *Device) string {
+	switch {
+	case d.Vendor_id == "05c6" && d.Product_id == "9008":
+		return ModeEdl
+	case d.Vendor_id == "0e8d" && (d.Product_id == "2000" || d.Product_id == "0003"):
+		return ModeMtkPreloader
+	case d.Vendor_id == "04e8" && (d.Product_id == "685d" || d.Product_id == "68c3"):
+		if !d.Accessible() {
+			return ModeSamsungDownload
+		}
+	case d.HasInterface(InterfaceAdb) || d.HasInterface(InterfaceFastboot):
+		// Otherwise adb and fastboot will see the device shortly
+		if !d.Accessible() {
+			return ModeNoPermission
+		}
+	case len(lookup.UsbVendorIdToBrands(d.Vendor_id)) > 0 && (d.HasInterface(InterfaceMtp) || d.HasInterface(InterfacePtp)):
+		return ModeDebuggingOff
+	}
+
+	return ""
+}
+
+// Returns the most relevant mode of the connected devices and the device in that mode
+// The mode is empty if there is none, also on systems without sysfs
+func Scan() (string, *Device, error) {
+	devices, err := List()
+	if errors.Is(err, errs.ErrNotImplemented) {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	found := make(map[string]*Device)
+	for _, d := range devices {
+		mode := Classify(d)
+		if mode != "" && found[mode] == nil {
+			found[mode] = d
+		}
+	}
+	for _, mode := range Modes {
+		if found[mode] != nil {
+			return mode, found[mode], nil
+		}
+	}
+
+	return "", nil, nil
+}
@@ -0,0 +1,165 @@
+package usb
+
+import (
+	"os"
+	"fmt"
+	"sort"
+	"strings"
+	"runtime"
+	"strconv"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/amo13/anarchy-droid/device/errs"
+)
+
+// Reads the usb devices connected to a linux host from sysfs
+
+var SysfsPath = "/sys/bus/usb/devices"
+var DevPath = "/dev/bus/usb"
+
+type Device struct {
+	// Name in sysfs, e.g. 1-4
+	Name string
+	Vendor_id string
+	Product_id string
+	Manufacturer string
+	Product string
+	Serial string
+	Bus int
+	Address int
+	// Interface classes, subclasses and protocols, e.g. ff/42/01 for adb
+	Interfaces []string
+}
+
+func (d *Device) String() string {
+	return fmt.Sprintf("%s %s:%s %s %s", d.Name, d.Vendor_id, d.Product_id, d.Manufacturer, d.Product)
+}
+
+// Path of the device node the tools open
+func (d *Device) Node() string {
+	return fmt.Sprintf("%s/%03d/%03d", DevPath, d.Bus, d.Address)
+}
+
+// True if the current user can open the device node, e.g. thanks to udev rules
+func (d *Device) Accessible() bool {
+	f, err := os.OpenFile(d.Node(), os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// True if one of the interfaces has the given class, subclass and protocol
+func (d *Device) HasInterface(class_subclass_protocol string) bool {
+	for _, i := range d.Interfaces {
+		if i == class_subclass_protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// Lists the usb devices, replaced by a simulated device for dry-runs
+type Lister interface {
+	List() ([]*Device, error)
+}
+
+var Backend Lister = sysfs{}
+
+// Returns the connected usb devices, hubs excluded
+func List() ([]*Device, error) {
+	return Backend.List()
+}
+
+// Reads the devices from sysfs
+type sysfs struct{}
+
+func (sysfs) List() ([]*Device, error) {
+	if runtime.GOOS != "linux" {
+		return nil, errs.Wrap(errs.ErrNotImplemented, "usb devices can only be listed on linux")
+	}
+
+	entries, err := ioutil.ReadDir(SysfsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []*Device{}
+	for _, entry := range entries {
+		name := entry.Name()
+		// Interfaces are named like 1-4:1.0, root hubs like usb1
+		if strings.Contains(name, ":") || strings.HasPrefix(name, "usb") {
+			continue
+		}
+		d := readDevice(filepath.Join(SysfsPath, name))
+		if d == nil || readAttribute(filepath.Join(SysfsPath, name), "bDeviceClass") == "09" {
+			continue
+		}
+		d.Name = name
+		d.Interfaces = readInterfaces(filepath.Join(SysfsPath, name), name)
+		devices = append(devices, d)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices, nil
+}
+
+// Returns the device with the given serial number
+func Find(serial string) (*Device, error) {
+	devices, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		if serial != "" && d.Serial == serial {
+			return d, nil
+		}
+	}
+
+	return nil, errs.Wrap(errs.ErrDisconnected, "no usb device with serial number " + serial)
+}
+
+func readDevice(dir string) *Device {
+	vid := readAttribute(dir, "idVendor")
+	if vid == "" {
+		return nil
+	}
+	bus, _ := strconv.Atoi(readAttribute(dir, "busnum"))
+	address, _ := strconv.Atoi(readAttribute(dir, "devnum"))
+
+	return &Device{
+		Vendor_id: strings.ToLower(vid),
+		Product_id: strings.ToLower(readAttribute(dir, "idProduct")),
+		Manufacturer: readAttribute(dir, "manufacturer"),
+		Product: readAttribute(dir, "product"),
+		Serial: readAttribute(dir, "serial"),
+		Bus: bus,
+		Address: address,
+	}
+}
+
+func readInterfaces(dir string, name string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, name + ":*"))
+	interfaces := []string{}
+	for _, m := range matches {
+		class := readAttribute(m, "bInterfaceClass")
+		if class == "" {
+			continue
+		}
+		interfaces = append(interfaces, strings.ToLower(class + "/" + readAttribute(m, "bInterfaceSubClass") + "/" + readAttribute(m, "bInterfaceProtocol")))
+	}
+
+	return interfaces
+}
+
+func readAttribute(dir string, attribute string) string {
+	content, err := ioutil.ReadFile(filepath.Join(dir, attribute))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
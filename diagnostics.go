@@ -0,0 +1,184 @@
+package main
+
+import (
+	"github.com/amo13/anarchy-droid/get"
+	"github.com/amo13/anarchy-droid/device"
+	"github.com/amo13/anarchy-droid/lookup"
+	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/helpers"
+	"github.com/amo13/anarchy-droid/flashplan"
+	"github.com/amo13/anarchy-droid/device/adb"
+	"github.com/amo13/anarchy-droid/device/twrp"
+	"github.com/amo13/anarchy-droid/device/fastboot"
+
+	"os"
+	"fmt"
+	"sort"
+	"time"
+	"runtime"
+	"strings"
+	"io/ioutil"
+	"archive/zip"
+	"path/filepath"
+)
+
+// Collects the evidence needed to investigate a failed installation into one zip file
+// Everything is redacted so the bundle can be attached to a public ticket
+
+func diagnosticsFilename() string {
+	return "log/diagnostics-" + time.Now().Format("2006-01-02-150405") + ".zip"
+}
+
+// Writes the diagnostic bundle and returns the list of files it contains
+func exportDiagnostics(zip_path string) ([]string, error) {
+	logger.Log("Exporting diagnostics to " + zip_path)
+	logger.AddSecret(logger.SecretSerial, device.D1.SerialNumber)
+	logger.AddSecret(logger.SecretImei, device.D1.Imei)
+
+	files := map[string]string{
+		"info.txt": diagnosticsInfo(),
+	}
+
+	for _, name := range []string{AppName + ".log.1", AppName + ".log"} {
+		content, err := ioutil.ReadFile("log/" + name)
+		if err == nil {
+			files[name] = string(content)
+		}
+	}
+
+	d := device.D1
+	if d.State == "recovery" {
+		content, err := twrp.GetAndReadLog()
+		if err != nil {
+			logger.Log("Unable to pull the recovery log:", err.Error())
+		} else {
+			files["recovery.log"] = content
+		}
+	} else {
+		// Pulled during the last installation
+		content, err := twrp.ReadLog()
+		if err == nil {
+			files["recovery.log"] = content
+		}
+	}
+
+	props := d.AdbProps
+	if len(props) == 0 && helpers.IsStringInSlice(d.State, []string{"android", "recovery"}) {
+		props, _ = adb.GetPropMap()
+	}
+	if len(props) > 0 {
+		files["getprop.txt"] = diagnosticsMap(props)
+	}
+
+	vars := d.FastbootVars
+	if len(vars) == 0 && d.State == "fastboot" {
+		vars, _ = fastboot.GetVarMap()
+	}
+	if len(vars) > 0 {
+		files["getvar.txt"] = diagnosticsMap(vars)
+	}
+
+	files["available.txt"] = get.A1.String()
+
+	content, err := ioutil.ReadFile(LastPlanFile)
+	if err == nil {
+		files["flashplan.yml"] = string(content)
+	}
+	if flashplan.HasCheckpoint(d.SerialNumber) {
+		checkpoint, err := flashplan.LoadCheckpoint(d.SerialNumber)
+		if err == nil {
+			files["checkpoint.txt"] = checkpoint.String() + "\n\n" + checkpoint.Plan.String()
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(zip_path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(zip_path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zw := zip.NewWriter(f)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write([]byte(logger.Redact(files[name])))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+func diagnosticsInfo() string {
+	d := device.D1
+	lines := []string{
+		"App:           " + AppName + " " + AppVersion + " (" + BuildDate + ")",
+		"OS:            " + runtime.GOOS + " " + runtime.GOARCH,
+		"Exported:      " + time.Now().Format(time.RFC3339),
+		"",
+		"State:         " + d.State,
+		"State history: " + strings.Join(d.States_history, " < "),
+		"Brand:         " + d.Brand,
+		"Model:         " + d.Model,
+		"Codename:      " + d.Codename,
+		"Detection:     " + diagnosticsDetection(d),
+		"Architecture:  " + d.Arch,
+		"Serial number: " + d.SerialNumber,
+		"IMEI:          " + d.Imei,
+		fmt.Sprintf("A/B device:    %t", d.IsAB),
+		fmt.Sprintf("Unlocked:      %t", d.IsUnlocked),
+		fmt.Sprintf("Supported:     %t", d.IsSupported),
+		"Installed rom: " + d.InstalledRom + " " + d.InstalledRomVersion,
+		"TWRP version:  " + d.TwrpVersionConnected,
+		"",
+		"Database:      " + diagnosticsDatabase(),
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// The codename candidates and why they were considered
+func diagnosticsDetection(d device.Device) string {
+	guesses := []string{}
+	for _, g := range d.Codename_guesses {
+		guesses = append(guesses, fmt.Sprintf("%s %.2f (%s)", g.Codename, g.Confidence, strings.Join(g.Reasons, ", ")))
+	}
+	return strings.Join(guesses, "; ")
+}
+
+func diagnosticsDatabase() string {
+	version, is_bundled := lookup.DatabaseVersion()
+	if is_bundled {
+		return fmt.Sprintf("%d (bundled)", version)
+	}
+	return fmt.Sprintf("%d (downloaded)", version)
+}
+
+func diagnosticsMap(m map[string]string) string {
+	keys := helpers.KeysOfMap(m)
+	sort.Strings(keys)
+	result := ""
+	for _, key := range keys {
+		result = result + key + ": " + m[key] + "\n"
+	}
+
+	return result
+}
@@ -6,8 +6,14 @@ import(
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/widget"
 
+	"github.com/amo13/anarchy-droid/get"
 	"github.com/amo13/anarchy-droid/device"
 	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/flashplan"
+
+	"fmt"
+	"time"
+	"strings"
 )
 
 var Lbl_flashing_title *widget.Label
@@ -41,6 +47,60 @@ func flashingScreen() fyne.CanvasObject {
 	return box
 }
 
+// Displays the events of the flash plan engine on the flashing screen
+type guiFlashUi struct {
+	start_over bool	// Go back to the main screen once finished
+}
+
+// Receives the unlock code from the unlock screens
+var gui_unlock_code = make(chan string)
+
+func (u *guiFlashUi) Event(e flashplan.Event) {
+	switch e.Kind {
+	case flashplan.EventProgress:
+		Lbl_progressbar.SetText(e.Text)
+	case flashplan.EventInstructions:
+		Lbl_flashing_instructions.SetText(e.Text)
+	case flashplan.EventBusy:
+		Progressbar.Start()
+	case flashplan.EventIdle, flashplan.EventFailed:
+		Progressbar.Stop()
+	case flashplan.EventFinished:
+		if u.start_over {
+			time.Sleep(20 * time.Second)
+
+			// Reset everything
+			device.D1.StartOver()
+			get.A1 = get.NewAvailable()
+			w.SetContent(mainScreen())
+		}
+	}
+}
+
+func (u *guiFlashUi) UnlockCode(brand string) (string, error) {
+	switch strings.ToLower(brand) {
+	case "sony":
+		w.SetContent(sonyUnlockScreen())
+	case "motorola":
+		w.SetContent(motorolaUnlockScreen())
+	case "fairphone":
+		w.SetContent(fairphoneUnlockScreen())
+	default:
+		return "", fmt.Errorf("no unlock screen for %s", brand)
+	}
+
+	return <-gui_unlock_code, nil
+}
+
+// Called from the buttons of the unlock screens
+func guiUnlockStep(unlock_code string) {
+	// Start goroutine to prevent blocking the UI calling this function with a button
+	go func() {
+		w.SetContent(flashingScreen())
+		gui_unlock_code <- unlock_code
+	}()
+}
+
 func updateFlashingScreen() {
 	// Display requested and current device states
 	if device.D1.State_request != "" {
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/amo13/anarchy-droid/flashplan"
+)
+
+// Everything the flashing procedure needs to know besides the device
+// and the chosen rom and TWRP items in get.A1.User
+// The gui reads them from its widgets, the command line from its flags
+// and the api server from the json body of a flash request
+type FlashOptions struct {
+	User_rom bool `json:"-"`	// get.A1.User.Rom.Href is a local file
+	User_twrp bool `json:"-"`	// get.A1.User.Twrp.Img.Href is a local file
+	Skip_unlock bool `json:"skip_unlock"`
+	Skip_wipe_data bool `json:"skip_wipe_data"`
+	Skip_flash_twrp bool `json:"skip_flash_twrp"`
+	Copy_partitions bool `json:"copy_partitions"`
+	Reboot_after_installation bool `json:"reboot_after_installation"`
+	Gapps string `json:"gapps"`	// "MicroG", "MinMicroG", "OpenGapps" or "Nothing"
+	Opengapps_version string `json:"opengapps_version"`
+	Opengapps_variant string `json:"opengapps_variant"`
+	Fdroid bool `json:"fdroid"`
+	Aurora bool `json:"aurora"`
+	Playstore bool `json:"playstore"`
+	Sigspoof bool `json:"sigspoof"`
+	Gsync bool `json:"gsync"`
+	Swype bool `json:"swype"`
+	Unlock_code string `json:"unlock_code"`	// Unlock without asking the user if not empty
+}
+
+// Renders the events of the flash plan engine
+type FlashUi interface {
+	Event(e flashplan.Event)
+	// Blocks until the user provided the unlock code for the given brand
+	UnlockCode(brand string) (string, error)
+}
+
+// Options and ui of the running flashing procedure
+var Opts = &FlashOptions{}
+var Ui FlashUi = &guiFlashUi{}
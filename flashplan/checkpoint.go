@@ -0,0 +1,177 @@
+package flashplan
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"os"
+	"fmt"
+	"time"
+	"regexp"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Checkpoints are written after the files were downloaded and after every
+// completed step, so that an installation interrupted by a crash or a
+// sleeping laptop can be resumed when the same device is connected again
+
+const CheckpointDir = "log/checkpoints/"
+
+type Checkpoint struct {
+	Serial string `yaml:"serial"`
+	Updated string `yaml:"updated"`
+	// Number of steps completed, the next step to run is Plan.Steps[Completed]
+	Completed int `yaml:"completed"`
+	// The last completed step failed but was allowed to
+	Previous_failed bool `yaml:"previous_failed,omitempty"`
+	// TWRP has been flashed to the recovery partition, not only booted
+	Twrp_installed bool `yaml:"twrp_installed,omitempty"`
+	Plan *Plan `yaml:"plan"`
+}
+
+var unsafe_filename_chars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+func checkpointPath(serial string) string {
+	return filepath.Join(CheckpointDir, unsafe_filename_chars.ReplaceAllString(serial, "_") + ".yml")
+}
+
+func HasCheckpoint(serial string) bool {
+	if serial == "" {
+		return false
+	}
+	_, err := os.Stat(checkpointPath(serial))
+	return err == nil
+}
+
+func LoadCheckpoint(serial string) (*Checkpoint, error) {
+	if serial == "" {
+		return nil, fmt.Errorf("unknown serial number")
+	}
+
+	yamldata, err := ioutil.ReadFile(checkpointPath(serial))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Checkpoint{}
+	err = yaml.Unmarshal(yamldata, c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Plan == nil {
+		return nil, fmt.Errorf("checkpoint without plan")
+	}
+	if c.Plan.Files == nil {
+		c.Plan.Files = make(map[string]*File)
+	}
+	if c.Completed < 0 || c.Completed > len(c.Plan.Steps) {
+		return nil, fmt.Errorf("checkpoint after step %d of %d", c.Completed, len(c.Plan.Steps))
+	}
+
+	return c, c.Plan.Validate()
+}
+
+func (c *Checkpoint) Save() error {
+	c.Updated = time.Now().Format(time.RFC3339)
+
+	yamldata, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(CheckpointDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash cannot leave a truncated checkpoint
+	tmp := checkpointPath(c.Serial) + ".tmp"
+	err = ioutil.WriteFile(tmp, yamldata, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, checkpointPath(c.Serial))
+}
+
+func RemoveCheckpoint(serial string) error {
+	err := os.Remove(checkpointPath(serial))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Returns a plan with the steps left to run
+// The device may have rebooted since the interruption, so TWRP is booted
+// again before the first remaining step needing it, and props set right before
+// the interruption are set again because they do not survive a reboot
+func (c *Checkpoint) ResumePlan() *Plan {
+	p := NewPlan(c.Plan.Codename)
+	p.Created = time.Now().Format(time.RFC3339)
+	p.Files = c.Plan.Files
+	p.Final_instructions = c.Plan.Final_instructions
+
+	start := c.Completed
+	for start > 0 && c.Plan.Steps[start - 1].Action == ActionSetProps {
+		start = start - 1
+	}
+	remaining := c.Plan.Steps[start:]
+
+	var last_boot *Step
+	for _, step := range c.Plan.Steps[:start] {
+		if step.Action == ActionBootRecovery {
+			last_boot = step
+		}
+	}
+
+	steps := []*Step{}
+	leading := true
+	for _, step := range remaining {
+		s := *step
+		if leading && s.After_failure {
+			if !c.Previous_failed {
+				continue
+			}
+			// The step which failed has been run before the interruption
+			s.After_failure = false
+		} else {
+			leading = false
+		}
+		steps = append(steps, &s)
+	}
+
+	booted := last_boot == nil
+	for _, step := range steps {
+		if !booted && needsRecovery(step) {
+			boot := *last_boot
+			boot.Installed = c.Twrp_installed
+			boot.Description = "Booting TWRP again to resume the installation..."
+			boot.Instructions = ""
+			boot.Report = "Resume: Boot recovery"
+			boot.Continue_on_error = false
+			boot.After_failure = false
+			p.Add(&boot)
+			booted = true
+		}
+		if step.Action == ActionBootRecovery {
+			booted = true
+		}
+		p.Add(step)
+	}
+
+	return p
+}
+
+func needsRecovery(step *Step) bool {
+	switch step.Action {
+	case ActionWipe, ActionSideloadRom, ActionSideloadZip, ActionSetProps:
+		return true
+	}
+
+	return false
+}
+
+func (c *Checkpoint) String() string {
+	return fmt.Sprintf("%d of %d steps completed on %s (%s)", c.Completed, len(c.Plan.Steps), c.Serial, c.Updated)
+}
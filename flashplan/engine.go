@@ -0,0 +1,573 @@
+package flashplan
+
+import (
+	"github.com/amo13/anarchy-droid/get"
+	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/helpers"
+	"github.com/amo13/anarchy-droid/device"
+	"github.com/amo13/anarchy-droid/device/adb"
+	"github.com/amo13/anarchy-droid/device/errs"
+	"github.com/amo13/anarchy-droid/device/quirks"
+	"github.com/amo13/anarchy-droid/device/twrp"
+
+	"fmt"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	"strings"
+	"strconv"
+	"runtime"
+	"path/filepath"
+)
+
+const (
+	EventProgress = "progress"	// Short description of the current step
+	EventInstructions = "instructions"	// What the user needs to do or know right now
+	EventBusy = "busy"	// Something is going on which can take a while
+	EventIdle = "idle"
+	EventStepStarted = "step_started"
+	EventStepFinished = "step_finished"
+	EventStepFailed = "step_failed"
+	EventFinished = "finished"	// All steps succeeded
+	EventFailed = "failed"
+)
+
+type Event struct {
+	Kind string `json:"kind"`
+	Step int `json:"step"`	// Number of the step starting at 1, 0 outside of the steps
+	Action string `json:"action,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// Executes a plan on device.D1
+type Engine struct {
+	Plan *Plan
+	// Receives every event, called from the goroutine running the plan
+	Events func(e Event)
+	// Blocks until the user provided the unlock code for the given brand
+	UnlockCode func(brand string) (string, error)
+	// Serial number of the device to save checkpoints for, none are saved if empty
+	Serial string
+
+	step int
+	busy bool
+	twrp_installed bool
+	previous_failed bool
+}
+
+func NewEngine(plan *Plan, events func(e Event), unlock_code func(brand string) (string, error)) *Engine {
+	return &Engine{
+		Plan: plan,
+		Events: events,
+		UnlockCode: unlock_code,
+	}
+}
+
+func (e *Engine) emit(kind string, text string) {
+	if e.Events == nil {
+		return
+	}
+
+	action := ""
+	if e.step > 0 {
+		action = e.Plan.Steps[e.step - 1].Action
+	}
+	e.Events(Event{Kind: kind, Step: e.step, Action: action, Text: text})
+}
+
+func (e *Engine) progress(text string) {
+	e.emit(EventProgress, text)
+}
+
+func (e *Engine) instructions(text string) {
+	e.emit(EventInstructions, text)
+}
+
+func (e *Engine) setBusy(busy bool) {
+	if busy == e.busy {
+		return
+	}
+	e.busy = busy
+	if busy {
+		e.emit(EventBusy, "")
+	} else {
+		e.emit(EventIdle, "")
+	}
+}
+
+func (e *Engine) fail(err error) error {
+	e.setBusy(false)
+	e.emit(EventFailed, err.Error())
+	return err
+}
+
+// Downloads the missing files and runs all steps
+// Blocks until the plan finished or failed
+func (e *Engine) Run() error {
+	err := e.Plan.Validate()
+	if err != nil {
+		return e.fail(err)
+	}
+	if e.Plan.Codename != "" && device.D1.Codename != "" && !strings.EqualFold(e.Plan.Codename, device.D1.Codename) {
+		return e.fail(fmt.Errorf("the plan is for %s but a %s is connected", e.Plan.Codename, device.D1.Codename))
+	}
+
+	err = e.Download()
+	if err != nil {
+		return err
+	}
+	e.checkpoint(0)
+
+	device.D1.Flashing = true
+
+	for i, step := range e.Plan.Steps {
+		if step.After_failure && !e.previous_failed {
+			continue
+		}
+		e.step = i + 1
+
+		if !device.D1.Flashing {
+			logger.Log("User cancelled flashing")
+			e.removeCheckpoint()
+			return e.fail(errs.ErrCancelled)
+		}
+
+		step_log := logger.With(logger.Fields{"step": strconv.Itoa(e.step), "action": step.Action})
+		step_log.Info(fmt.Sprintf("Step %d: %s", e.step, step.Action))
+		started := time.Now()
+		if step.Report != "" {
+			go logger.Report(map[string]string{"progress":step.Report})
+		}
+		e.emit(EventStepStarted, step.Description)
+		if step.Instructions != "" {
+			e.instructions(step.Instructions)
+		}
+		if step.Description != "" {
+			e.progress(step.Description)
+		}
+		e.setBusy(true)
+
+		err = e.runStep(step)
+		step_log = step_log.With(logger.Fields{"duration": time.Since(started).Round(time.Second).String()})
+		if err != nil {
+			fields := logger.Fields{"error": err.Error()}
+			if details := errs.Details(err); details != "" {
+				fields["command"] = details
+			}
+			step_log.With(fields).Warn("Step failed")
+			e.emit(EventStepFailed, err.Error())
+			if step.Continue_on_error && !errors.Is(err, errs.ErrCancelled) {
+				logger.LogError(fmt.Sprintf("Step %d (%s) failed:", e.step, step.Action), err)
+				logger.Log("Proceeding anyway...")
+				e.previous_failed = true
+				e.checkpoint(i + 1)
+				continue
+			}
+
+			logger.LogError(fmt.Sprintf("Step %d (%s) failed:", e.step, step.Action), err)
+			if errors.Is(err, errs.ErrCancelled) {
+				e.removeCheckpoint()
+			}
+			e.instructions(failureInstructions(step, err))
+			return e.fail(err)
+		}
+
+		e.previous_failed = false
+		e.checkpoint(i + 1)
+		step_log.Info("Step finished")
+		e.emit(EventStepFinished, "")
+		time.Sleep(1 * time.Second)
+	}
+
+	e.step = 0
+	e.removeCheckpoint()
+	logger.Log("Finished.")
+	e.setBusy(false)
+	e.progress("")
+	if e.Plan.Final_instructions != "" {
+		e.instructions(e.Plan.Final_instructions)
+	}
+	e.emit(EventFinished, "")
+
+	return nil
+}
+
+// Saves the progress so the plan can be resumed after an interruption
+func (e *Engine) checkpoint(completed int) {
+	if e.Serial == "" {
+		return
+	}
+
+	c := &Checkpoint{
+		Serial: e.Serial,
+		Completed: completed,
+		Previous_failed: e.previous_failed,
+		Twrp_installed: e.twrp_installed,
+		Plan: e.Plan,
+	}
+	err := c.Save()
+	if err != nil {
+		logger.LogError("Unable to save the checkpoint:", err)
+	}
+}
+
+func (e *Engine) removeCheckpoint() {
+	if e.Serial == "" {
+		return
+	}
+
+	err := RemoveCheckpoint(e.Serial)
+	if err != nil {
+		logger.LogError("Unable to remove the checkpoint:", err)
+	}
+}
+
+func failureInstructions(step *Step, err error) string {
+	switch step.Action {
+	case ActionUnlock:
+		if errors.Is(err, errs.ErrUnlockNotAllowed) {
+			return "Unlocking the bootloader not allowed. OEM unlock has apparently not been enabled. Please enable it in your device settings and restart the application."
+		}
+		return "Unlocking the bootloader failed:\n" + err.Error()
+	case ActionBootRecovery:
+		if errors.Is(err, errs.ErrRecoveryBootFailed) {
+			return "Manually booting TWRP failed.\n\nPlease restart and try again."
+		}
+		return "Error booting TWRP:\n" + err.Error()
+	default:
+		return "Error during installation:\n" + err.Error()
+	}
+}
+
+// Downloads all files with a download link in parallel
+func (e *Engine) Download() error {
+	keys := []string{}
+	for _, key := range e.Plan.FileKeys() {
+		if e.Plan.Files[key].Href != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	logger.Log("Downloading files...")
+	e.progress("Downloading files...")
+	e.setBusy(true)
+
+	var wg sync.WaitGroup
+	failures := make(chan error, len(keys))
+	wg.Add(len(keys))
+	for _, key := range keys {
+		go func(key string, f *File) {
+			defer wg.Done()
+
+			err := get.DownloadItem(f.Path, &get.Item{
+				Href: f.Href,
+				Filename: filepath.Base(f.Path),
+				Checksum: f.Checksum,
+				Checksum_type: f.Checksum_type,
+				Checksum_url_suffix: f.Checksum_url_suffix,
+			})
+			if err != nil {
+				logger.LogError("Error retrieving " + key + " from " + f.Href + " :", err)
+				failures <- fmt.Errorf(key + ": " + err.Error())
+			}
+		}(key, e.Plan.Files[key])
+	}
+	wg.Wait()
+	close(failures)
+
+	for err := range failures {
+		e.instructions("Failed to download the necessary files:\n" + err.Error())
+		return e.fail(err)
+	}
+
+	e.setBusy(false)
+	paths := make(map[string]string)
+	for key, f := range e.Plan.Files {
+		paths[key] = f.Path
+	}
+	logger.Log("Files downloaded successfully:", helpers.MapToString(paths))
+	e.progress("Files downloaded successfully!")
+
+	return nil
+}
+
+func (e *Engine) runStep(step *Step) error {
+	switch step.Action {
+	case ActionUnlock:
+		return e.unlock(step)
+	case ActionBootRecovery:
+		return e.bootRecovery(step)
+	case ActionWipe:
+		return e.wipe(step)
+	case ActionSideloadRom:
+		err := device.D1.FlashZip(e.Plan.Files[step.File].Path)
+		if err == nil {
+			err = e.checkSideload(step)
+		}
+		if err == nil && device.D1.IsAB {
+			// Flashing an AB rom replaces the recovery (at least LineageOS does so)
+			e.twrp_installed = false
+		}
+		return err
+	case ActionSideloadZip:
+		err := device.D1.FlashZip(e.Plan.Files[step.File].Path)
+		if err == nil {
+			err = e.checkSideload(step)
+		}
+		return err
+	case ActionSetProps:
+		keys := helpers.KeysOfMap(step.Props)
+		sort.Strings(keys)
+		for _, key := range keys {
+			err := adb.SetProp(key, step.Props[key])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case ActionReboot:
+		return e.reboot(step)
+	}
+
+	return fmt.Errorf("unknown action %s", step.Action)
+}
+
+// Reads the outcome of the sideloaded zip from the recovery log
+// An unreadable log does not fail the step, adb sideload reported success after all
+func (e *Engine) checkSideload(step *Step) error {
+	if device.D1.State != "recovery" {
+		device.D1.State_request = "recovery"
+		<-device.D1.State_reached	// Blocks until TWRP is back from sideload mode
+	}
+
+	result, err := twrp.LastSideloadResult()
+	if err != nil {
+		logger.Log("Unable to check the installation of " + step.File + " in the recovery log:", err.Error())
+		return nil
+	}
+
+	err = result.Err()
+	if err != nil {
+		logger.With(logger.Fields{"file": step.File, "return_code": strconv.Itoa(result.Return_code)}).Warn("Installation failed according to the recovery log")
+		return fmt.Errorf("installing %s failed: %s", step.File, err.Error())
+	}
+
+	logger.Log("Installation of " + step.File + " finished successfully")
+	return nil
+}
+
+// True if the user has to obtain an unlock code from the manufacturer,
+// e.g. on Sony, Motorola and Fairphone (except for the FP2)
+func NeedsUnlockCode(brand string, codename string) bool {
+	return device.QuirksOf(brand, codename).NeedsUnlockData()
+}
+
+func (e *Engine) unlock(step *Step) error {
+	if device.D1.IsUnlocked {
+		logger.Log("The bootloader is already unlocked.")
+		return nil
+	}
+
+	unlock_code := step.Unlock_code
+	if unlock_code == "" && NeedsUnlockCode(device.D1.Brand, device.D1.Codename) {
+		if e.UnlockCode == nil {
+			return fmt.Errorf("%s devices need an unlock code from the manufacturer", device.D1.Brand)
+		}
+
+		e.setBusy(false)
+		code, err := e.UnlockCode(device.D1.Brand)
+		if err != nil {
+			return err
+		}
+		e.setBusy(true)
+
+		// The user might have found out that it is unlocked already
+		if device.D1.IsUnlocked {
+			return nil
+		}
+		unlock_code = code
+	}
+
+	logger.Log("Trying to unlock the bootloader...")
+	err := device.D1.DoUnlock(unlock_code)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Bootloader unlocked successfully!")
+	e.progress("Bootloader unlocked successfully!")
+	go logger.Report(map[string]string{"progress":"Unlock successful"})
+
+	// Observe if the device reboots, it is only visible on usb without debugging
+	// If yes, simply notify the user about the factory reset
+	// and ask him to activate usb debugging in the settings again
+	q := device.D1.Quirks()
+	time.Sleep(q.Unlock_delay)
+	if q.After_unlock == quirks.AfterUnlockWipe || device.D1.State == "disconnected" || device.IsUsbOnly(device.D1.State) {
+		e.instructions("Your device has been wiped and is now rebooting. This means unlocking the bootloader was probably successful!\nPlease reactivate USB Debugging in the system settings to continue: In Settings > About Phone: Tap 7 times on Build Number. Then in Settings > Developer Options: Activate USB Debugging.")
+	}
+
+	return nil
+}
+
+// Boots TWRP and waits until it is ready
+func (e *Engine) bootRecovery(step *Step) error {
+	if step.Installed || e.twrp_installed {
+		if device.D1.State == "recovery" {
+			device.D1.Reboot("recovery")
+			time.Sleep(5 * time.Second)
+		}
+	} else {
+		timeout := step.Timeout
+		if timeout == 0 {
+			timeout = 60
+		}
+
+		err := e.bootTwrpImage(e.Plan.Files[step.File].Path, timeout)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+
+	device.D1.State_request = "recovery"
+	<-device.D1.State_reached	// blocks until recovery is reached
+
+	// User might need to unlock the data partition with a pattern
+	e.waitForTwrpReady()
+
+	return nil
+}
+
+func (e *Engine) bootTwrpImage(img_file string, timeout int) error {
+	logger.Log("Trying to boot/flash TWRP...")
+
+	if runtime.GOOS == "windows" {
+		e.instructions("Waiting for bootloader... Please have some patience: Windows might need to install drivers. If the drivers appear to be missing, a driver installation tool will automatically be launched for you...")
+	}
+
+	reboot_instructions, err := device.D1.BootRecovery(img_file, timeout)
+	if err != nil {
+		if errors.Is(err, errs.ErrDriverAccess) {
+			e.instructions("Please allow Zadig to launch and install/replace the drivers for your device.\nSelect from the list what could be your device and press the \"Replace Driver\" button.\n(Sometimes it can be names like 05c6:9008, SGH-T959V or Generic Serial. If the list is empty, click on \"Show all devices\" in the menu.)")
+			err = device.D1.InstallDriversWithZadig()
+			if err != nil {
+				logger.LogError("Failed to download zadig", err)
+				return err
+			}
+			// Retry and give the user 20 minutes to install drivers on windows
+			reboot_instructions, err = device.D1.BootRecovery(img_file, 1200)
+			if err != nil {
+				return err
+			}
+		} else if errors.Is(err, errs.ErrTimeout) {
+			logger.Log("Trying to download and launch a driver installer...")
+			if device.D1.Quirks().Recovery_boot == quirks.RecoveryHeimdall {
+				e.instructions("Please install/replace the drivers for your device...\nSelect from the list what could be your device and press the button. (Sometimes it can be names like 05c6:9008, SGH-T959V or Generic Serial.)")
+				err = device.D1.InstallDriversWithZadig()
+				if err != nil {
+					return fmt.Errorf("Failed to download or launch zadig for driver installation: " + err.Error())
+				}
+			} else {
+				e.instructions("Please install/replace the drivers for your device... An installer should open automatically.")
+				err = device.D1.InstallUniversalDrivers()
+				if err != nil {
+					return fmt.Errorf("Failed to download or launch universal driver installer: " + err.Error())
+				}
+			}
+			// Retry and give the user 20 minutes to install drivers on windows
+			reboot_instructions, err = device.D1.BootRecovery(img_file, 1200)
+			if err != nil {
+				if errors.Is(err, errs.ErrDriverAccess) || errors.Is(err, errs.ErrTimeout) {
+					return fmt.Errorf("Failed to install drivers. You might need to reboot your computer and try again.")
+				}
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+
+	// Displays instructions and waits if needed
+	return e.checkManualRecoveryBoot(reboot_instructions)
+}
+
+func (e *Engine) checkManualRecoveryBoot(reboot_instructions string) error {
+	// Some devices can't boot TWRP directly from the bootloader
+	// but need TWRP to be flashed to the recovery partition first
+	// and then the user needs to hold a combination of hardware keys.
+	// In that case, display the instructions to the user and
+	// wait (block here) until the device is connected in recovery.
+	if reboot_instructions != "" {
+		e.instructions(reboot_instructions)
+		go logger.Report(map[string]string{"progress":"Manually boot recovery"})
+
+		// In that case, TWRP has been actually flashed/installed
+		// and not only temporarily booted
+		e.twrp_installed = true
+
+		for helpers.IsStringInSlice(device.D1.State, []string{"fastboot", "heimdall", "disconnected"}) || device.IsUsbOnly(device.D1.State) {
+			time.Sleep(1 * time.Second)
+		}
+
+		if device.D1.State != "recovery" {
+			go logger.Report(map[string]string{"progress":"Manually booting recovery failed"})
+			return errs.ErrRecoveryBootFailed
+		} else {
+			go logger.Report(map[string]string{"progress":"Manually booting recovery succeeded"})
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) waitForTwrpReady() {
+	ready, err := twrp.IsReady()
+	if err != nil {
+		logger.LogError("Unable to check if TWRP is ready:", err)
+	}
+	for !ready {
+		e.instructions("Waiting for TWRP to be ready...\n\nIf you can, please unlock TWRP on your device screen.")
+		time.Sleep(1 * time.Second)
+		ready, err = twrp.IsReady()
+		if err != nil {
+			logger.LogError("Unable to check if TWRP is ready:", err)
+		}
+	}
+}
+
+func (e *Engine) wipe(step *Step) error {
+	if device.D1.State != "recovery" {
+		device.D1.State_request = "recovery"
+		<-device.D1.State_reached	// blocks until recovery is reached
+	}
+
+	if step.Wipe == "clean" {
+		logger.Log("Clean-Wiping the device...")
+		return twrp.WipeClean()
+	}
+
+	logger.Log("Dirty-Wiping the device...")
+	return twrp.WipeDirty()
+}
+
+func (e *Engine) reboot(step *Step) error {
+	if step.Wait {
+		device.D1.State_request = step.Target
+		<-device.D1.State_reached	// blocks until the target is reached
+		return nil
+	}
+
+	// Let a running sideload finish first
+	if device.D1.State == "sideload" || device.D1.State == "recovery" {
+		device.D1.State_request = "recovery"
+		<-device.D1.State_reached	// blocks until recovery is reached
+	}
+
+	return device.D1.Reboot(step.Target)
+}
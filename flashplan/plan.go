@@ -0,0 +1,209 @@
+package flashplan
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"os"
+	"fmt"
+	"sort"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// A flash plan lists every step of an installation in order, so that the
+// exact same procedure can be reviewed, shared and run again
+// It is built by the gui, the command line or the api server from their options
+// and executed by the Engine, which reports its progress as events
+
+const PlanVersion = 1
+
+const (
+	ActionUnlock = "unlock"
+	ActionBootRecovery = "boot_recovery"
+	ActionWipe = "wipe"
+	ActionSideloadRom = "sideload_rom"
+	ActionSideloadZip = "sideload_zip"
+	ActionSetProps = "set_props"
+	ActionReboot = "reboot"
+)
+
+var Actions = []string{ActionUnlock, ActionBootRecovery, ActionWipe, ActionSideloadRom, ActionSideloadZip, ActionSetProps, ActionReboot}
+
+type Plan struct {
+	Version int `yaml:"version"`
+	Codename string `yaml:"codename,omitempty"`
+	Created string `yaml:"created,omitempty"`
+	// Files referenced by the steps, downloaded before the first step if missing
+	Files map[string]*File `yaml:"files,omitempty"`
+	Steps []*Step `yaml:"steps"`
+	// Shown once all steps succeeded
+	Final_instructions string `yaml:"final_instructions,omitempty"`
+}
+
+type File struct {
+	Path string `yaml:"path"`
+	Href string `yaml:"href,omitempty"`
+	Checksum string `yaml:"checksum,omitempty"`
+	Checksum_type string `yaml:"checksum_type,omitempty"`
+	Checksum_url_suffix string `yaml:"checksum_url_suffix,omitempty"`
+}
+
+type Step struct {
+	Action string `yaml:"action"`
+	// Shown as progress while the step runs
+	Description string `yaml:"description,omitempty"`
+	// Shown to the user when the step starts
+	Instructions string `yaml:"instructions,omitempty"`
+	// Progress name for the usage statistics
+	Report string `yaml:"report,omitempty"`
+	// Key of the file in Files for boot_recovery, sideload_rom and sideload_zip
+	File string `yaml:"file,omitempty"`
+	// "clean" formats data, "dirty" only wipes the caches
+	Wipe string `yaml:"wipe,omitempty"`
+	// Set with adb before the next sideload, e.g. to configure an installer
+	Props map[string]string `yaml:"props,omitempty"`
+	// android, recovery or bootloader
+	Target string `yaml:"target,omitempty"`
+	// Block until the device reached the target
+	Wait bool `yaml:"wait,omitempty"`
+	// Seconds to wait for the bootloader when booting TWRP
+	Timeout int `yaml:"timeout,omitempty"`
+	// Reboot into the installed recovery instead of booting the TWRP image
+	Installed bool `yaml:"installed,omitempty"`
+	Unlock_code string `yaml:"unlock_code,omitempty"`
+	// Log errors and proceed with the next step
+	Continue_on_error bool `yaml:"continue_on_error,omitempty"`
+	// Only run if the previous step failed
+	After_failure bool `yaml:"after_failure,omitempty"`
+}
+
+func NewPlan(codename string) *Plan {
+	return &Plan{
+		Version: PlanVersion,
+		Codename: codename,
+		Files: make(map[string]*File),
+		Steps: []*Step{},
+	}
+}
+
+// Appends a step and returns it
+func (p *Plan) Add(step *Step) *Step {
+	p.Steps = append(p.Steps, step)
+	return step
+}
+
+func (p *Plan) Validate() error {
+	if p.Version > PlanVersion {
+		return fmt.Errorf("unsupported plan version %d", p.Version)
+	}
+
+	for i, step := range p.Steps {
+		if step == nil {
+			return fmt.Errorf("step %d is empty", i + 1)
+		}
+		switch step.Action {
+		case ActionBootRecovery:
+			if !step.Installed && p.Files[step.File] == nil {
+				return fmt.Errorf("step %d: unknown file %q", i + 1, step.File)
+			}
+			if !step.Installed && p.Files[step.File].Path == "" {
+				return fmt.Errorf("step %d: file %q has no path", i + 1, step.File)
+			}
+		case ActionSideloadRom, ActionSideloadZip:
+			// Checked before anything is wiped
+			if p.Files[step.File] == nil {
+				return fmt.Errorf("step %d: unknown file %q", i + 1, step.File)
+			}
+			if p.Files[step.File].Path == "" {
+				return fmt.Errorf("step %d: file %q has no path", i + 1, step.File)
+			}
+		case ActionWipe:
+			if step.Wipe != "clean" && step.Wipe != "dirty" {
+				return fmt.Errorf("step %d: wipe must be clean or dirty", i + 1)
+			}
+		case ActionReboot:
+			if step.Target != "android" && step.Target != "recovery" && step.Target != "bootloader" {
+				return fmt.Errorf("step %d: unknown reboot target %q", i + 1, step.Target)
+			}
+		case ActionUnlock, ActionSetProps:
+		default:
+			return fmt.Errorf("step %d: unknown action %q", i + 1, step.Action)
+		}
+	}
+
+	return nil
+}
+
+// Returns the keys of the files in a stable order
+func (p *Plan) FileKeys() []string {
+	keys := make([]string, 0, len(p.Files))
+	for key := range p.Files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func Parse(yamldata []byte) (*Plan, error) {
+	p := &Plan{}
+	err := yaml.Unmarshal(yamldata, p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Files == nil {
+		p.Files = make(map[string]*File)
+	}
+
+	return p, p.Validate()
+}
+
+func Load(file_path string) (*Plan, error) {
+	yamldata, err := ioutil.ReadFile(file_path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(yamldata)
+}
+
+func (p *Plan) Save(file_path string) error {
+	yamldata, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(file_path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file_path, yamldata, 0644)
+}
+
+func (p *Plan) String() string {
+	result := ""
+	if p.Codename != "" {
+		result = result + "Plan for " + p.Codename + ":\n"
+	}
+	for i, step := range p.Steps {
+		result = result + fmt.Sprintf("%2d. %s", i + 1, step.Action)
+		if step.File != "" && p.Files[step.File] != nil {
+			result = result + " " + filepath.Base(p.Files[step.File].Path)
+		}
+		if step.Wipe != "" {
+			result = result + " " + step.Wipe
+		}
+		if step.Target != "" {
+			result = result + " " + step.Target
+		}
+		if step.After_failure {
+			result = result + " (after failure)"
+		}
+		if step.Continue_on_error {
+			result = result + " (optional)"
+		}
+		result = result + "\n"
+	}
+
+	return result
+}
@@ -48,6 +48,19 @@ func AospExtendedLatestAvailableFileName(codename string) (string, error) {
 }
 
 func AospExtendedParseApiResponse(codename string) (AospExtendedApiResponse, error) {
+	responses := AospExtendedParseApiResponses(codename)
+	if len(responses) == 0 {
+		ParsedAospExtendedApiResponse = AospExtendedApiResponse{Error: true}
+	} else {
+		ParsedAospExtendedApiResponse = responses[0]
+	}
+
+	return ParsedAospExtendedApiResponse, nil
+}
+
+// Returns the latest zip of each android version, the newest android version first
+func AospExtendedParseApiResponses(codename string) []AospExtendedApiResponse {
+	responses := []AospExtendedApiResponse{}
 	for _, version := range []string{"r", "q"} {
 		content, err := helpers.ReadFromURL("https://api.aospextended.com/ota_v2/" + codename + "/" + version)
 		if err != nil {
@@ -55,13 +68,14 @@ func AospExtendedParseApiResponse(codename string) (AospExtendedApiResponse, err
 			continue
 		}
 
-	    json.Unmarshal([]byte(content), &ParsedAospExtendedApiResponse)
-	    if !ParsedAospExtendedApiResponse.Error {
-	    	break
-	    }
+		var response AospExtendedApiResponse
+		err = json.Unmarshal([]byte(content), &response)
+		if err == nil && !response.Error && response.Url != "" {
+			responses = append(responses, response)
+		}
 	}
-	
-	return ParsedAospExtendedApiResponse, nil
+
+	return responses
 }
 
 
@@ -73,14 +87,11 @@ func AospExtendedLatestAvailableHref(codename string) (string, error) {
 		}
 	}
 
-	data, err := AospExtendedParseApiResponse(codename)
-	if err != nil {
-		return "", err
-	}
-
-	if data.Error || data.Url == "" {
+	responses := AospExtendedParseApiResponses(codename)
+	if len(responses) == 0 {
 		return "", fmt.Errorf("not available")
 	}
+	data := responses[0]
 
 	// Populate the A1 structs of availables
 	A1.Mutex.Lock()
@@ -90,6 +101,16 @@ func AospExtendedLatestAvailableHref(codename string) (string, error) {
 	A1.Upstream.Rom["AospExtended"].Href = data.Url
 	A1.Upstream.Rom["AospExtended"].Checksum_url_suffix = ""
 	A1.Upstream.Rom["AospExtended"].Filename = data.Filename
+	A1.Upstream.Rom["AospExtended"].Date = ParseBuildDate(data.Filename)
+	hrefs := make([]string, 0, len(responses))
+	for _, r := range responses {
+		hrefs = append(hrefs, r.Url)
+	}
+	A1.Upstream.Rom["AospExtended"].Builds = buildsFromHrefs("AospExtended", hrefs, "", func(filename string) (string, string) {
+		v := helpers.GenericParseVersion(filename)
+		av, _ := AospExtendedParseAndroidVersion(v)
+		return v, av
+	})
 	A1.Upstream.Rom["AospExtended"].Version = helpers.GenericParseVersion(A1.Upstream.Rom["AospExtended"].Filename)
 	av, err := AospExtendedParseAndroidVersion(A1.Upstream.Rom["AospExtended"].Version)
 	if err != nil {
@@ -105,6 +105,28 @@ type Item struct {
 	Version string
 	Android_version string
 	Checksum_url_suffix string
+	// Filled by providers offering structured build information
+	Date string	// YYYY-MM-DD
+	Size int64
+	Checksum string
+	Checksum_type string	// md5, sha1 or sha256
+	Builds []*Item	// All available builds including this one, newest first
+}
+
+// Chooses the TWRP to install, preferring an override from the archive
+// Returns false if no TWRP is available
+func (a *Available) SelectDefaultTwrp() bool {
+	if a.Archive.Override_twrp.Img.Href != "" {
+		a.User.Twrp = a.Archive.Override_twrp
+	} else if a.Upstream.Twrp.Img.Href != "" {
+		a.User.Twrp = a.Upstream.Twrp
+	} else if a.Archive.Twrp.Img.Href != "" {
+		a.User.Twrp = a.Archive.Twrp
+	} else {
+		return false
+	}
+
+	return true
 }
 
 func (a *Available) CanFlash() bool {
@@ -119,9 +141,28 @@ type RetrievalError struct {
 func (a *Available) Populate(codename string) error {
 	a.Reloading = true
 
+	// Declarative rom sources take precedence over built-in scrapers of the same name
+	sources, err := LoadRomSources()
+	if err != nil {
+		logger.LogError("Unable to load the declarative rom sources:", err)
+	}
+
 	var wg sync.WaitGroup
 	errs := make(chan RetrievalError)
-	wg.Add(21)
+	wg.Add(21 + len(sources))
+
+	for romname, _ := range sources {
+		go func(romname string) {
+			defer wg.Done()
+
+			_, err := RomSourceLatestAvailableHref(romname, codename)
+			if err != nil {
+				errs <- RetrievalError{romname, err}
+			}
+
+			logger.Log("Finished looking for " + romname)
+		}(romname)
+	}
 
 	go func() {
 		defer wg.Done()
@@ -279,6 +320,9 @@ func (a *Available) Populate(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("LineageOS") {
+			return
+		}
 
 		_, err := LineageosLatestAvailableHref(codename)
 		if err != nil {
@@ -290,6 +334,9 @@ func (a *Available) Populate(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("LineageOSMicroG") {
+			return
+		}
 
 		_, err := LineageosMicrogLatestAvailableHref(codename)
 		if err != nil {
@@ -301,6 +348,9 @@ func (a *Available) Populate(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("Carbonrom") {
+			return
+		}
 
 		_, err := CarbonromLatestAvailableHref(codename)
 		if err != nil {
@@ -312,6 +362,9 @@ func (a *Available) Populate(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("ResurrectionRemix") {
+			return
+		}
 
 		_, err := ResurrectionRemixLatestAvailableHref(codename)
 		if err != nil {
@@ -323,6 +376,9 @@ func (a *Available) Populate(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("crDroid") {
+			return
+		}
 
 		_, err := CrDroidLatestAvailableHref(codename)
 		if err != nil {
@@ -333,6 +389,9 @@ func (a *Available) Populate(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("e-OS") {
+			return
+		}
 
 		_, err := EOSLatestAvailableHref(codename)
 		if err != nil {
@@ -344,6 +403,9 @@ func (a *Available) Populate(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("DivestOS") {
+			return
+		}
 
 		_, err := DivestosLatestAvailableHref(codename)
 		if err != nil {
@@ -401,9 +463,35 @@ func (a *Available) PopulateForApi(codename string) error {
 	// scraping info for the new device
 	A1 = NewAvailable()
 
+	sources, err := LoadRomSources()
+	if err != nil {
+		logger.LogError("API-Server: Unable to load the declarative rom sources:", err)
+	}
+
 	var wg sync.WaitGroup
 	errs := make(chan RetrievalError)
-	wg.Add(8)
+	wg.Add(8 + len(sources))
+
+	for romname, _ := range sources {
+		go func(romname string) {
+			defer wg.Done()
+
+			h, err := RomSourceLatestAvailableHref(romname, codename)
+			if err != nil {
+				errs <- RetrievalError{romname, err}
+			}
+
+			if h != "" {
+				a.Mutex.Lock()
+				defer a.Mutex.Unlock()
+				if a.Upstream.Rom[romname] == nil {
+					a.Upstream.Romlist = append(a.Upstream.Romlist, romname)
+				}
+				a.Upstream.Rom[romname] = &Item{}
+				a.Upstream.Rom[romname].Href = h
+			}
+		}(romname)
+	}
 
 	go func() {
 		defer wg.Done()
@@ -431,6 +519,9 @@ func (a *Available) PopulateForApi(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("LineageOS") {
+			return
+		}
 
 		h, err := LineageosLatestAvailableHref(codename)
 		if err != nil {
@@ -448,6 +539,9 @@ func (a *Available) PopulateForApi(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("LineageOSMicroG") {
+			return
+		}
 
 		h, err := LineageosMicrogLatestAvailableHref(codename)
 		if err != nil {
@@ -465,6 +559,9 @@ func (a *Available) PopulateForApi(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("Carbonrom") {
+			return
+		}
 
 		h, err := CarbonromLatestAvailableHref(codename)
 		if err != nil {
@@ -482,6 +579,9 @@ func (a *Available) PopulateForApi(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("ResurrectionRemix") {
+			return
+		}
 
 		h, err := ResurrectionRemixLatestAvailableHref(codename)
 		if err != nil {
@@ -499,6 +599,9 @@ func (a *Available) PopulateForApi(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("crDroid") {
+			return
+		}
 
 		h, err := CrDroidLatestAvailableHref(codename)
 		if err != nil {
@@ -516,6 +619,9 @@ func (a *Available) PopulateForApi(codename string) error {
 
 	go func() {
 		defer wg.Done()
+		if RomSourceDeclared("e-OS") {
+			return
+		}
 
 		h, err := EOSLatestAvailableHref(codename)
 		if err != nil {
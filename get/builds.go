@@ -0,0 +1,129 @@
+package get
+
+import (
+	"github.com/amo13/anarchy-droid/helpers"
+
+	"strings"
+	"regexp"
+	"strconv"
+	"sort"
+)
+
+// Every rom item carries the list of all builds its provider offers in Builds,
+// newest first, so that older builds can be chosen if the latest one is broken
+
+// Returns one item per download link, newest first
+// parse returns the rom version and android version for a given file name
+func buildsFromHrefs(romname string, hrefs []string, checksum_url_suffix string, parse func(filename string) (string, string)) []*Item {
+	builds := make([]*Item, 0, len(hrefs))
+	for _, href := range hrefs {
+		filename := BuildFileName(href)
+		version, android_version := parse(filename)
+		builds = append(builds, &Item{
+			Name: romname,
+			Href: href,
+			Filename: filename,
+			Version: version,
+			Android_version: android_version,
+			Checksum_url_suffix: checksum_url_suffix,
+			Date: ParseBuildDate(filename),
+		})
+	}
+
+	SortBuilds(builds)
+	return builds
+}
+
+// Returns the file name of a download link without sourceforge's "/download" or a query string
+func BuildFileName(href string) string {
+	filename := helpers.ExtractFileNameFromHref(strings.TrimSuffix(href, "/download"))
+	if i := strings.Index(filename, "?"); i >= 0 {
+		filename = filename[:i]
+	}
+
+	return filename
+}
+
+var build_date_regex = regexp.MustCompile(`(20\d{2})(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01])`)
+
+// Parses a YYYYMMDD build date in a file name and returns it as YYYY-MM-DD
+func ParseBuildDate(filename string) string {
+	m := build_date_regex.FindStringSubmatch(filename)
+	if len(m) != 4 {
+		return ""
+	}
+
+	return m[1] + "-" + m[2] + "-" + m[3]
+}
+
+// Sorts builds newest first, by date if known and by file name otherwise
+func SortBuilds(builds []*Item) {
+	sort.SliceStable(builds, func(i, j int) bool {
+		if builds[i].Date != builds[j].Date {
+			return builds[i].Date > builds[j].Date
+		}
+		return builds[i].Filename > builds[j].Filename
+	})
+}
+
+var major_version_regex = regexp.MustCompile(`\d+`)
+
+// Returns the leading integer of the version, e.g. 18 of "lineage-18.1-20210101-nightly-potter"
+// Returns -1 if there is none
+func ParseMajorVersion(s string) int {
+	if v := helpers.GenericParseVersion(s); v != "" {
+		s = v
+	} else {
+		s = build_date_regex.ReplaceAllString(s, "")
+	}
+
+	major, err := strconv.Atoi(major_version_regex.FindString(s))
+	if err != nil {
+		return -1
+	}
+	return major
+}
+
+// Returns the newest build of a rom which can be dirty flashed over the
+// installed version, i.e. with the same major rom version and a later date
+// Returns nil if no such build is available
+func NewerCompatibleBuild(rom *Item, installed_version string) *Item {
+	if rom == nil || installed_version == "" {
+		return nil
+	}
+
+	installed_date := ParseBuildDate(installed_version)
+	if installed_date == "" {
+		return nil
+	}
+	installed_major := ParseMajorVersion(installed_version)
+	// Unknown major versions are never dirty flashed over each other
+	if installed_major < 0 {
+		return nil
+	}
+
+	builds := rom.Builds
+	if len(builds) == 0 {
+		builds = []*Item{rom}
+	}
+
+	for _, build := range builds {
+		if build.Date <= installed_date {
+			continue
+		}
+		version := build.Version
+		if version == "" {
+			version = build.Filename
+		}
+		if ParseMajorVersion(version) != installed_major {
+			continue
+		}
+		// The latest build is the rom item itself
+		if build.Href == rom.Href {
+			return rom
+		}
+		return build
+	}
+
+	return nil
+}
@@ -90,7 +90,13 @@ func CarbonromLatestAvailableHref(codename string) (string, error) {
 	A1.Upstream.Rom["Carbonrom"].Name = "Carbonrom"
 	A1.Upstream.Rom["Carbonrom"].Href = latest_available
 	A1.Upstream.Rom["Carbonrom"].Checksum_url_suffix = ".md5sum"
+	A1.Upstream.Rom["Carbonrom"].Builds = buildsFromHrefs("Carbonrom", versions_available_filtered, ".md5sum", func(filename string) (string, string) {
+		v, _ := CarbonromParseVersion(filename)
+		av, _ := CarbonromParseAndroidVersion(v)
+		return v, av
+	})
 	A1.Upstream.Rom["Carbonrom"].Filename = helpers.ExtractFileNameFromHref(latest_available)
+	A1.Upstream.Rom["Carbonrom"].Date = ParseBuildDate(A1.Upstream.Rom["Carbonrom"].Filename)
 	v, err := CarbonromParseVersion(A1.Upstream.Rom["Carbonrom"].Filename)
 	if err != nil {
 		return latest_available, fmt.Errorf("unable to parse Carbonrom version in %s", A1.Upstream.Rom["Carbonrom"].Filename)
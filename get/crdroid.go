@@ -141,11 +141,19 @@ func CrDroidLatestAvailableHref(codename string) (string, error) {
 	A1.Upstream.Rom["crDroid"].Name = "crDroid"
 	A1.Upstream.Rom["crDroid"].Href = dl_url
 	A1.Upstream.Rom["crDroid"].Checksum_url_suffix = ""
+	// Builds of the latest crDroid major version only
+	// Older builds keep their sourceforge link which redirects to a mirror on download
+	A1.Upstream.Rom["crDroid"].Builds = buildsFromHrefs("crDroid", files_available_filtered, "", func(filename string) (string, string) {
+		v, _ := CrDroidParseVersion(filename)
+		av, _ := CrDroidParseAndroidVersion(filename)
+		return v, av
+	})
 	filename := helpers.ExtractFileNameFromHref(dl_url)
 	if strings.Contains(filename, ".zip?") && len(strings.Split(filename, ".zip?")) > 0 {
 		filename = strings.Split(filename, ".zip?")[0] + ".zip"
 	}
 	A1.Upstream.Rom["crDroid"].Filename = filename
+	A1.Upstream.Rom["crDroid"].Date = ParseBuildDate(A1.Upstream.Rom["crDroid"].Filename)
 	v, err := CrDroidParseVersion(A1.Upstream.Rom["crDroid"].Filename)
 	if err != nil {
 		return latest_available, fmt.Errorf("unable to parse CrDroid version in %s", A1.Upstream.Rom["crDroid"].Filename)
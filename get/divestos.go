@@ -13,6 +13,7 @@ type DivestosApiResponse struct {
 	Filename string `json:"filename"`
 	Version string `json:"version"`
 	Url string `json:"url"`
+	Size int64 `json:"size"`
 }
 
 var ParsedDivestosApiResponse DivestosApiResponse
@@ -64,24 +65,37 @@ func DivestosLatestAvailableFileName(codename string) (string, error) {
 }
 
 func DivestosParseApiResponse(codename string) (DivestosApiResponse, error) {
+	responses, err := DivestosParseApiResponses(codename)
+	if err != nil {
+		return ParsedDivestosApiResponse, err
+	}
+
+	// If there are more than one zip available,
+	// assume that the first one in the list is the latest.
+	ParsedDivestosApiResponse = responses[0]
+	return ParsedDivestosApiResponse, nil
+}
+
+// Returns all zips available for the codename
+func DivestosParseApiResponses(codename string) ([]DivestosApiResponse, error) {
 	url := "https://divestos.org/updater.php?base=LineageOS&device=" + codename
 
 	status_code, err := StatusCode(url)
 	if err != nil {
-		return ParsedDivestosApiResponse, err
+		return nil, err
 	}
 	if strings.HasPrefix(status_code, "4") {
-		return ParsedDivestosApiResponse, fmt.Errorf("not available")
+		return nil, fmt.Errorf("not available")
 	}
 
 	content, err := helpers.ReadFromURL(url)
 	if err != nil {
-		logger.Log("DivestosParseApiResponse:", err.Error())
-		return ParsedDivestosApiResponse, err
+		logger.Log("DivestosParseApiResponses:", err.Error())
+		return nil, err
 	}
 
 	if strings.Trim(string(content), " ") == "Unknown base/device" {
-		return ParsedDivestosApiResponse, fmt.Errorf("not available")
+		return nil, fmt.Errorf("not available")
 	}
 
 	var ApiResponseMap map[string][]DivestosApiResponse
@@ -92,12 +106,10 @@ func DivestosParseApiResponse(codename string) (DivestosApiResponse, error) {
     }
 
     if len(ApiResponseMap["response"]) == 0 {
-    	return ParsedDivestosApiResponse, fmt.Errorf("DivestOS gave an unexpected JSON response: " + string(content))
+    	return nil, fmt.Errorf("DivestOS gave an unexpected JSON response: " + string(content))
     }
-	
-	// If there are more than one zip available,
-	// assume that the first one in the list is the latest.
-	return ApiResponseMap["response"][0], nil
+
+	return ApiResponseMap["response"], nil
 }
 
 
@@ -109,10 +121,11 @@ func DivestosLatestAvailableHref(codename string) (string, error) {
 		}
 	}
 
-	data, err := DivestosParseApiResponse(codename)
+	responses, err := DivestosParseApiResponses(codename)
 	if err != nil {
 		return "", err
 	}
+	data := responses[0]
 
 	if data.Url == "" {
 		return "", fmt.Errorf("not available")
@@ -126,7 +139,26 @@ func DivestosLatestAvailableHref(codename string) (string, error) {
 	A1.Upstream.Rom["DivestOS"].Href = data.Url
 	A1.Upstream.Rom["DivestOS"].Checksum_url_suffix = ""
 	A1.Upstream.Rom["DivestOS"].Filename = data.Filename
+	A1.Upstream.Rom["DivestOS"].Date = ParseBuildDate(data.Filename)
+	A1.Upstream.Rom["DivestOS"].Size = data.Size
 	A1.Upstream.Rom["DivestOS"].Version = data.Version
+	hrefs := make([]string, 0, len(responses))
+	versions := make(map[string]string)
+	sizes := make(map[string]int64)
+	for _, r := range responses {
+		if r.Url != "" {
+			hrefs = append(hrefs, r.Url)
+			versions[BuildFileName(r.Url)] = r.Version
+			sizes[r.Url] = r.Size
+		}
+	}
+	A1.Upstream.Rom["DivestOS"].Builds = buildsFromHrefs("DivestOS", hrefs, "", func(filename string) (string, string) {
+		av, _ := DivestosParseAndroidVersion(versions[filename])
+		return versions[filename], av
+	})
+	for _, build := range A1.Upstream.Rom["DivestOS"].Builds {
+		build.Size = sizes[build.Href]
+	}
 	av, err := DivestosParseAndroidVersion(A1.Upstream.Rom["DivestOS"].Version)
 	if err != nil {
 		return data.Url, fmt.Errorf("unable to parse DivestOS Android version with %s: %s", A1.Upstream.Rom["DivestOS"].Version, err.Error())
@@ -126,6 +126,61 @@ func DownloadAndOverwriteFile(file_path string, url string, checksum_url_suffix
 	return nil
 }
 
+// Downloads an item and verifies it against the checksum known from its provider
+// Falls back to the checksum file next to the download if there is none
+func DownloadItem(file_path string, item *Item) error {
+	if item.Checksum == "" {
+		return DownloadFile(file_path, item.Href, item.Checksum_url_suffix)
+	}
+
+	// Keep an existing file if it matches
+	_, err := os.Stat(file_path)
+	if err == nil {
+		ok, err := VerifyChecksum(file_path, item.Checksum_type, item.Checksum)
+		if err == nil && ok {
+			return nil
+		}
+	}
+
+	err = DownloadAndOverwriteFile(file_path, item.Href, "")
+	if err != nil {
+		return err
+	}
+
+	ok, err := VerifyChecksum(file_path, item.Checksum_type, item.Checksum)
+	if err != nil {
+		os.Remove(file_path)
+		return err
+	}
+	if !ok {
+		os.Remove(file_path)
+		return fmt.Errorf("Integrity verification failed for %s", file_path)
+	}
+
+	return nil
+}
+
+// Compares the checksum of a file with a known checksum
+func VerifyChecksum(file_path string, checksum_type string, expected string) (bool, error) {
+	cs := ""
+	var err error
+	switch checksum_type {
+	case "md5":
+		cs, err = checksum.MD5sum(file_path)
+	case "sha1":
+		cs, err = checksum.SHA1sum(file_path)
+	case "sha256", "":
+		cs, err = checksum.SHA256sum(file_path)
+	default:
+		return false, fmt.Errorf("Cannot compute checksum of type %s: not implemented", checksum_type)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return strings.EqualFold(cs, expected), nil
+}
+
 // Return false only on explicit verification failure
 // Return true if the checksum file could not be downloaded
 func VerifyIntegrity(file_path string, url string, suffix string) (isCorrect bool, err error) {
@@ -90,7 +90,17 @@ func EOSLatestAvailableHref(codename string) (string, error) {
 	A1.Upstream.Rom["e-OS"].Name = "e-OS"
 	A1.Upstream.Rom["e-OS"].Href = device_url + latest_available
 	A1.Upstream.Rom["e-OS"].Checksum_url_suffix = ".sha256sum"
+	hrefs := make([]string, 0, len(versions_available_filtered))
+	for _, href := range versions_available_filtered {
+		hrefs = append(hrefs, device_url + href)
+	}
+	A1.Upstream.Rom["e-OS"].Builds = buildsFromHrefs("e-OS", hrefs, ".sha256sum", func(filename string) (string, string) {
+		v, _ := EOSParseVersion(filename)
+		av, _ := EOSParseAndroidVersion(v)
+		return v, av
+	})
 	A1.Upstream.Rom["e-OS"].Filename = helpers.ExtractFileNameFromHref(latest_available)
+	A1.Upstream.Rom["e-OS"].Date = ParseBuildDate(A1.Upstream.Rom["e-OS"].Filename)
 	v, err := EOSParseVersion(A1.Upstream.Rom["e-OS"].Filename)
 	if err != nil {
 		return latest_available, fmt.Errorf("unable to parse e-OS version in %s", A1.Upstream.Rom["e-OS"].Filename)
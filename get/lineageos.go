@@ -6,27 +6,32 @@ import (
 	"github.com/amo13/anarchy-droid/helpers"
 	"github.com/amo13/anarchy-droid/logger"
 
+	"encoding/json"
+	"net/http"
 	"strings"
 	"sort"
+	"time"
 	"fmt"
 )
 
 // Download latest LineageOS zip into flash folder and return the file name
 func Lineageos(codename string) (string, error) {
-	dl_url, err := LineageosLatestAvailableHref(codename)
+	_, err := LineageosLatestAvailableHref(codename)
 	if err != nil {
 		return "", err
 	}
 
-	// Parse the file name from the href
-	file_name := helpers.ExtractFileNameFromHref(dl_url)
+	item := A1.Upstream.Rom["LineageOS"]
+	if item == nil || item.Href == "" {
+		return "", fmt.Errorf("not available")
+	}
 
-	err = DownloadFile("flash/" + file_name, dl_url, "?sha256")
+	err = DownloadItem("flash/" + item.Filename, item)
 	if err != nil {
 		return "", err
 	}
 
-	return file_name, nil
+	return item.Filename, nil
 }
 
 // Returns file name of the latest available LineageOS zip
@@ -39,6 +44,41 @@ func LineageosLatestAvailableFileName(codename string) (string, error) {
 	return helpers.ExtractFileNameFromHref(href), nil
 }
 
+// Build as returned by the LineageOS updater API
+// A build consists of the rom zip and additional images like boot.img or recovery.img
+type LineageosBuild struct {
+	Date string `json:"date"`
+	Datetime int64 `json:"datetime"`
+	Type string `json:"type"`
+	Version string `json:"version"`
+	Os_patch_level string `json:"os_patch_level"`
+	Files []LineageosBuildFile `json:"files"`
+}
+
+type LineageosBuildFile struct {
+	Date string `json:"date"`
+	Datetime int64 `json:"datetime"`
+	Filename string `json:"filename"`
+	Filepath string `json:"filepath"`
+	Sha1 string `json:"sha1"`
+	Sha256 string `json:"sha256"`
+	Size int64 `json:"size"`
+	Url string `json:"url"`
+}
+
+// Response of the legacy updater API where each build is a single zip
+type lineageosV1Response struct {
+	Response []struct {
+		Datetime int64 `json:"datetime"`
+		Filename string `json:"filename"`
+		Id string `json:"id"`	// sha256 of the zip
+		Romtype string `json:"romtype"`
+		Size int64 `json:"size"`
+		Url string `json:"url"`
+		Version string `json:"version"`
+	} `json:"response"`
+}
+
 // Returns download link of the latest available LineageOS zip
 func LineageosLatestAvailableHref(codename string) (string, error) {
 	if A1.Upstream.Rom["LineageOS"] != nil {
@@ -47,62 +87,145 @@ func LineageosLatestAvailableHref(codename string) (string, error) {
 		}
 	}
 
-	url := "https://download.lineageos.org/" + codename
-
-	status_code, err := StatusCode(url)
+	builds, err := LineageosBuilds(codename)
 	if err != nil {
 		return "", err
 	}
-	if status_code == "404 Not Found" {
-		return "", fmt.Errorf("not available")
+
+	// Newest build first
+	sort.SliceStable(builds, func(i, j int) bool { return builds[i].Datetime > builds[j].Datetime })
+
+	all := make([]*Item, 0, len(builds))
+	for _, build := range builds {
+		item := lineageosItemFromBuild(build)
+		if item != nil {
+			all = append(all, item)
+		}
+	}
+	if len(all) == 0 {
+		return "", nil
 	}
 
-	versions_available := make([]string, 0)
+	// Keep the latest build free of references to itself
+	latest := &Item{}
+	*latest = *all[0]
+	latest.Builds = all
 
-	c := colly.NewCollector()
+	// Populate the A1 structs of availables
+	A1.Mutex.Lock()
+	defer A1.Mutex.Unlock()
+	A1.Upstream.Rom["LineageOS"] = latest
+	if latest.Version == "" {
+		return latest.Href, fmt.Errorf("unable to parse LineageOS version in %s", latest.Filename)
+	}
+	if latest.Android_version == "" {
+		return latest.Href, fmt.Errorf("unable to parse LineageOS Android version with %s", latest.Version)
+	}
 
-	c.OnError(func(_ *colly.Response, err error) {
+	return latest.Href, nil
+}
+
+// Returns the builds of a device from the updater API
+// Falls back to the legacy API if the builds API does not know the device
+func LineageosBuilds(codename string) ([]LineageosBuild, error) {
+	builds := make([]LineageosBuild, 0)
+	found, err := getJson("https://download.lineageos.org/api/v2/devices/" + codename + "/builds", &builds)
+	if err != nil {
 		logger.LogError("LineageOS:", err)
-	})
+	}
+	if found && len(builds) > 0 {
+		return builds, nil
+	}
 
-	c.OnHTML("td a", func(e *colly.HTMLElement) {
-		versions_available = append(versions_available, e.Attr("href"))
-	})
+	v1 := lineageosV1Response{}
+	found, err = getJson("https://download.lineageos.org/api/v1/" + codename + "/nightly/*", &v1)
+	if err != nil {
+		return nil, err
+	}
+	if !found || len(v1.Response) == 0 {
+		return nil, fmt.Errorf("not available")
+	}
 
-	c.Visit(url)
+	builds = make([]LineageosBuild, 0, len(v1.Response))
+	for _, r := range v1.Response {
+		date := time.Unix(r.Datetime, 0).UTC().Format("2006-01-02")
+		builds = append(builds, LineageosBuild{
+			Date: date,
+			Datetime: r.Datetime,
+			Type: r.Romtype,
+			Version: r.Version,
+			Files: []LineageosBuildFile{{
+				Date: date,
+				Datetime: r.Datetime,
+				Filename: r.Filename,
+				Sha256: r.Id,
+				Size: r.Size,
+				Url: r.Url,
+			}},
+		})
+	}
 
-	filter := func(s string) bool { return strings.HasSuffix(s, ".zip") }
-	versions_available_filtered := helpers.FilterStringSlice(versions_available, filter)
+	return builds, nil
+}
 
-	sort.Strings(versions_available_filtered)
+// Converts an updater API build into an Item for the rom zip
+// Other files of the build, e.g. boot.img, are not needed
+func lineageosItemFromBuild(build LineageosBuild) *Item {
+	for _, f := range build.Files {
+		if !strings.HasSuffix(f.Filename, ".zip") {
+			continue
+		}
 
-	latest_available := ""
-	if len(versions_available_filtered) > 0 {
-		latest_available = versions_available_filtered[len(versions_available_filtered)-1]
-	} else {
-		return "", nil
+		item := &Item{
+			Name: "LineageOS",
+			Href: f.Url,
+			Filename: f.Filename,
+			Version: build.Version,
+			Date: build.Date,
+			Size: f.Size,
+			Checksum: f.Sha256,
+			Checksum_type: "sha256",
+		}
+		if item.Checksum == "" && f.Sha1 != "" {
+			item.Checksum = f.Sha1
+			item.Checksum_type = "sha1"
+		}
+		if item.Checksum == "" {
+			item.Checksum_type = ""
+		}
+
+		if item.Version == "" {
+			item.Version, _ = LineageosParseVersion(item.Filename)
+		}
+		item.Android_version, _ = LineageosParseAndroidVersion(item.Version)
+		return item
 	}
 
-	// Populate the A1 structs of availables
-	A1.Mutex.Lock()
-	defer A1.Mutex.Unlock()
-	A1.Upstream.Rom["LineageOS"] = &Item{}
-	A1.Upstream.Rom["LineageOS"].Name = "LineageOS"
-	A1.Upstream.Rom["LineageOS"].Href = latest_available
-	A1.Upstream.Rom["LineageOS"].Checksum_url_suffix = "?sha256"
-	A1.Upstream.Rom["LineageOS"].Filename = helpers.ExtractFileNameFromHref(latest_available)
-	v, err := LineageosParseVersion(A1.Upstream.Rom["LineageOS"].Filename)
+	return nil
+}
+
+// Decodes a JSON response into v
+// Returns false without error if the server answers 404
+func getJson(url string, v interface{}) (bool, error) {
+	resp, err := http.Get(url)
 	if err != nil {
-		return latest_available, fmt.Errorf("unable to parse LineageOS version in %s", A1.Upstream.Rom["LineageOS"].Filename)
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
 	}
-	A1.Upstream.Rom["LineageOS"].Version = v
-	av, err := LineageosParseAndroidVersion(v)
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
 	if err != nil {
-		return latest_available, fmt.Errorf("unable to parse LineageOS Android version with %s", v)
+		return false, err
 	}
-	A1.Upstream.Rom["LineageOS"].Android_version = av
 
-	return latest_available, nil
+	return true, nil
 }
 
 func LineageosParseVersion(filename string) (string, error) {
@@ -132,6 +255,12 @@ func LineageosParseAndroidVersion(losversion string) (string, error) {
 		return "11", nil
 	case "19", "19.0", "19.1":
 		return "12", nil
+	case "20", "20.0":
+		return "13", nil
+	case "21", "21.0":
+		return "14", nil
+	case "22", "22.0", "22.1", "22.2":
+		return "15", nil
 	default:
 		return "", fmt.Errorf("Unable to tell android version of LineageOS %s", losversion)
 	}
@@ -219,7 +348,17 @@ func LineageosMicrogLatestAvailableHref(codename string) (string, error) {
 	A1.Upstream.Rom["LineageOSMicroG"].Name = "LineageOSMicroG"
 	A1.Upstream.Rom["LineageOSMicroG"].Href = dl_url
 	A1.Upstream.Rom["LineageOSMicroG"].Checksum_url_suffix = ".sha256sum"
+	hrefs := make([]string, 0, len(versions_available_filtered))
+	for _, href := range versions_available_filtered {
+		hrefs = append(hrefs, url + "/" + href)
+	}
+	A1.Upstream.Rom["LineageOSMicroG"].Builds = buildsFromHrefs("LineageOSMicroG", hrefs, ".sha256sum", func(filename string) (string, string) {
+		v, _ := LineageosMicrogParseVersion(filename)
+		av, _ := LineageosMicrogParseAndroidVersion(v)
+		return v, av
+	})
 	A1.Upstream.Rom["LineageOSMicroG"].Filename = helpers.ExtractFileNameFromHref(dl_url)
+	A1.Upstream.Rom["LineageOSMicroG"].Date = ParseBuildDate(A1.Upstream.Rom["LineageOSMicroG"].Filename)
 	v, err := LineageosMicrogParseVersion(A1.Upstream.Rom["LineageOSMicroG"].Filename)
 	if err != nil {
 		return dl_url, fmt.Errorf("unable to parse LineageOSMicroG version in %s", A1.Upstream.Rom["LineageOSMicroG"].Filename)
@@ -125,11 +125,18 @@ func ResurrectionRemixLatestAvailableHref(codename string) (string, error) {
 	A1.Upstream.Rom["ResurrectionRemix"].Name = "ResurrectionRemix"
 	A1.Upstream.Rom["ResurrectionRemix"].Href = dl_url
 	A1.Upstream.Rom["ResurrectionRemix"].Checksum_url_suffix = ""
+	// Older builds keep their sourceforge link which redirects to a mirror on download
+	A1.Upstream.Rom["ResurrectionRemix"].Builds = buildsFromHrefs("ResurrectionRemix", versions_available_filtered, "", func(filename string) (string, string) {
+		v, _ := ResurrectionRemixParseVersion(filename)
+		av, _ := ResurrectionRemixParseAndroidVersion(v)
+		return v, av
+	})
 	filename := helpers.ExtractFileNameFromHref(dl_url)
 	if strings.Contains(filename, ".zip?") && len(strings.Split(filename, ".zip?")) > 0 {
 		filename = strings.Split(filename, ".zip?")[0] + ".zip"
 	}
 	A1.Upstream.Rom["ResurrectionRemix"].Filename = filename
+	A1.Upstream.Rom["ResurrectionRemix"].Date = ParseBuildDate(A1.Upstream.Rom["ResurrectionRemix"].Filename)
 	v, err := ResurrectionRemixParseVersion(A1.Upstream.Rom["ResurrectionRemix"].Filename)
 	if err != nil {
 		return latest_available, fmt.Errorf("unable to parse ResurrectionRemix version in %s", A1.Upstream.Rom["ResurrectionRemix"].Filename)
@@ -0,0 +1,229 @@
+package get
+
+import (
+	"github.com/gocolly/colly"
+	"gopkg.in/yaml.v3"
+
+	"github.com/amo13/anarchy-droid/helpers"
+	"github.com/amo13/anarchy-droid/logger"
+
+	"strings"
+	"regexp"
+	"sort"
+	"fmt"
+)
+
+// Rom sources consisting of a simple HTML index page and a file name pattern
+// are described in rom_sources.yml and loaded at runtime, so they can be
+// added or fixed without releasing a new version of the application
+var RomSourcesYamlUrl string = "https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/get/rom_sources.yml"
+var RomSources map[string]*RomSource
+
+type RomSource struct {
+	Name string `yaml:"-"`
+	// Index page to scrape, "{codename}" is replaced with the device codename
+	Url string `yaml:"url"`
+	// CSS selector matching the elements containing the file links
+	Selector string `yaml:"selector"`
+	// Read the link from this attribute of the matched elements ("text" for the element text)
+	Attribute string `yaml:"attribute"`
+	// Prepended to relative links, defaults to the index page url
+	Base_url string `yaml:"base_url"`
+	// Regular expression the file names have to match
+	Filename_filter string `yaml:"filename_filter"`
+	// Regular expressions to parse the rom and android versions from the file name
+	// The first submatch is used if the expression contains a group
+	Version_regex string `yaml:"version_regex"`
+	Android_version_regex string `yaml:"android_version_regex"`
+	Checksum_url_suffix string `yaml:"checksum_url_suffix"`
+}
+
+// Loads the declarative rom source definitions if not done yet
+func LoadRomSources() (map[string]*RomSource, error) {
+	if RomSources != nil {
+		return RomSources, nil
+	}
+
+	content, err := helpers.ReadFromURL(RomSourcesYamlUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := ParseRomSources(content)
+	if err != nil {
+		return nil, err
+	}
+
+	RomSources = sources
+	return RomSources, nil
+}
+
+func ParseRomSources(yamldata []byte) (map[string]*RomSource, error) {
+	sources := make(map[string]*RomSource)
+	err := yaml.Unmarshal(yamldata, &sources)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, source := range sources {
+		if source == nil {
+			delete(sources, name)
+			continue
+		}
+
+		source.Name = name
+		err = source.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid rom source %s: %s", name, err.Error())
+		}
+	}
+
+	return sources, nil
+}
+
+func (s *RomSource) validate() error {
+	if s.Url == "" {
+		return fmt.Errorf("missing url")
+	}
+	if s.Selector == "" {
+		return fmt.Errorf("missing selector")
+	}
+	for _, expr := range []string{s.Filename_filter, s.Version_regex, s.Android_version_regex} {
+		_, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// True if a declarative definition replaces the built-in scraper for the given rom
+func RomSourceDeclared(romname string) bool {
+	return RomSources != nil && RomSources[romname] != nil
+}
+
+// Returns download link of the latest available zip of a declarative rom source
+func RomSourceLatestAvailableHref(romname string, codename string) (string, error) {
+	if A1.Upstream.Rom[romname] != nil {
+		if A1.Upstream.Rom[romname].Href != "" {
+			return A1.Upstream.Rom[romname].Href, nil
+		}
+	}
+
+	sources, err := LoadRomSources()
+	if err != nil {
+		return "", err
+	}
+	source := sources[romname]
+	if source == nil {
+		return "", fmt.Errorf("unknown rom source %s", romname)
+	}
+
+	url := strings.ReplaceAll(source.Url, "{codename}", codename)
+
+	status_code, err := StatusCode(url)
+	if err != nil {
+		return "", err
+	}
+	if status_code != "200 OK" {
+		return "", fmt.Errorf("not available")
+	}
+
+	versions_available := make([]string, 0)
+
+	c := colly.NewCollector()
+
+	c.OnError(func(_ *colly.Response, err error) {
+		logger.LogError(romname + ":", err)
+	})
+
+	c.OnHTML(source.Selector, func(e *colly.HTMLElement) {
+		if source.Attribute == "text" {
+			versions_available = append(versions_available, strings.TrimSpace(e.Text))
+		} else if source.Attribute != "" {
+			versions_available = append(versions_available, e.Attr(source.Attribute))
+		} else {
+			versions_available = append(versions_available, e.Attr("href"))
+		}
+	})
+
+	c.Visit(url)
+
+	filename_filter := regexp.MustCompile(source.Filename_filter)
+	filter := func(s string) bool { return filename_filter.MatchString(helpers.ExtractFileNameFromHref(s)) }
+	versions_available_filtered := helpers.FilterStringSlice(versions_available, filter)
+
+	sort.Strings(versions_available_filtered)
+
+	latest_available := ""
+	if len(versions_available_filtered) > 0 {
+		latest_available = versions_available_filtered[len(versions_available_filtered)-1]
+	} else {
+		return "", nil
+	}
+
+	dl_url := source.absoluteHref(latest_available, url)
+	filename := helpers.ExtractFileNameFromHref(dl_url)
+
+	// Populate the A1 structs of availables
+	A1.Mutex.Lock()
+	defer A1.Mutex.Unlock()
+	A1.Upstream.Rom[romname] = &Item{}
+	A1.Upstream.Rom[romname].Name = romname
+	A1.Upstream.Rom[romname].Href = dl_url
+	A1.Upstream.Rom[romname].Checksum_url_suffix = source.Checksum_url_suffix
+	hrefs := make([]string, 0, len(versions_available_filtered))
+	for _, href := range versions_available_filtered {
+		hrefs = append(hrefs, source.absoluteHref(href, url))
+	}
+	A1.Upstream.Rom[romname].Builds = buildsFromHrefs(romname, hrefs, source.Checksum_url_suffix, func(filename string) (string, string) {
+		return matchFirstGroup(source.Version_regex, filename), matchFirstGroup(source.Android_version_regex, filename)
+	})
+	A1.Upstream.Rom[romname].Filename = filename
+	A1.Upstream.Rom[romname].Date = ParseBuildDate(filename)
+	A1.Upstream.Rom[romname].Version = matchFirstGroup(source.Version_regex, filename)
+	A1.Upstream.Rom[romname].Android_version = matchFirstGroup(source.Android_version_regex, filename)
+	if A1.Upstream.Rom[romname].Version == "" {
+		return dl_url, fmt.Errorf("unable to parse %s version in %s", romname, filename)
+	}
+
+	return dl_url, nil
+}
+
+func (s *RomSource) absoluteHref(href string, index_url string) string {
+	if strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
+		return href
+	}
+
+	base := s.Base_url
+	if base == "" {
+		base = index_url
+	}
+
+	if strings.HasPrefix(href, "/") {
+		// Keep only scheme and host of the base url
+		parts := strings.SplitN(base, "/", 4)
+		if len(parts) >= 3 {
+			return parts[0] + "//" + parts[2] + href
+		}
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + href
+}
+
+// Returns the first submatch of expr in s, or the whole match if expr has no group
+func matchFirstGroup(expr string, s string) string {
+	if expr == "" {
+		return ""
+	}
+
+	match := regexp.MustCompile(expr).FindStringSubmatch(s)
+	if len(match) > 1 {
+		return match[1]
+	} else if len(match) == 1 {
+		return match[0]
+	}
+
+	return ""
+}
@@ -23,11 +23,16 @@ import (
 )
 
 func Cmd(command string, args ...string) (stdout string, stderr string) {
-    if strings.HasPrefix(command, "sudo ") || strings.Contains(command, "| sudo ") {
-        return Cmd("/bin/sh", "-c", command + " " + strings.Join(args, " "))
-    }
+    stdout, stderr, _ = run(command, args, "")
+    return stdout, stderr
+}
 
+// Returns the error of the process as well, stdin is written to the process if not empty
+func run(command string, args []string, stdin string) (stdout string, stderr string, err error) {
     c := exec.Command(command, args...)
+    if stdin != "" {
+        c.Stdin = strings.NewReader(stdin)
+    }
 
     cOut, err := c.StdoutPipe()
     if err != nil {
@@ -39,25 +44,23 @@ func Cmd(command string, args ...string) (stdout string, stderr string) {
     }
 
     err = c.Start()
-    // Do not send a bug report containing a sudo password
-    if err != nil && !strings.Contains(err.Error(), "sudo ") {
+    if err != nil {
         logger.LogError("Could not execute command " + command + ":", err)
+        return "", "", err
     }
 
     outBytes, err := io.ReadAll(cOut)
-    // Do not send a bug report containing a sudo password
-    if err != nil && !strings.Contains(err.Error(), "sudo ") {
+    if err != nil {
         logger.LogError("Unable to read STDOUT", err)
     }
     errBytes, err := io.ReadAll(cErr)
-    // Do not send a bug report containing a sudo password
-    if err != nil && !strings.Contains(err.Error(), "sudo ") {
+    if err != nil {
         logger.LogError("Unable to read STDERR", err)
     }
 
-    c.Wait()
+    err = c.Wait()
 
-    return string(outBytes), string(errBytes)
+    return string(outBytes), string(errBytes), err
 }
 
 func ReadFromURL(url string) ([]byte, error) {
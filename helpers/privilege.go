@@ -0,0 +1,166 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"path/filepath"
+)
+
+// Accessing usb devices needs root on linux unless udev rules are set up
+// The strategy decides how adb, fastboot and heimdall are started,
+// the arguments are always passed to the process directly and never through a shell
+
+type Privilege interface {
+	// udev, pkexec or sudo, pkexec is only used to install the udev rules
+	Name() string
+	// Returns the program to execute, its arguments and what to write to its stdin
+	Command(binary string, args []string) (command string, command_args []string, stdin string)
+	// Returns an error if commands cannot be run this way, e.g. because of a wrong password
+	Check() error
+}
+
+var Privileged Privilege = defaultPrivilege()
+
+func defaultPrivilege() Privilege {
+	if runtime.GOOS == "linux" {
+		return &Sudo{}
+	}
+	return NoPrivilege{}
+}
+
+// Returns the strategy with the given name
+// pkexec asks for the password on every command, use DevicePrivilege to access devices
+func NewPrivilege(name string, sudo_password string) (Privilege, error) {
+	switch strings.ToLower(name) {
+	case "udev", "none":
+		return NoPrivilege{}, nil
+	case "pkexec", "polkit":
+		return Pkexec{}, nil
+	case "sudo":
+		return &Sudo{Password: sudo_password}, nil
+	default:
+		return nil, fmt.Errorf("unknown privilege strategy %s, expected udev, pkexec or sudo", name)
+	}
+}
+
+// Returns the strategy to run adb, fastboot and heimdall with
+// pkexec is refused, the observer polls the device every second and polkit would ask each time
+func DevicePrivilege(name string, sudo_password string) (Privilege, error) {
+	p, err := NewPrivilege(name, sudo_password)
+	if err != nil {
+		return nil, err
+	}
+	if p.Name() == "pkexec" {
+		return nil, fmt.Errorf("pkexec would ask for the password on every adb and fastboot call, use sudo or install the udev rules")
+	}
+	return p, nil
+}
+
+// Runs the binaries as the current user, e.g. with udev rules or on windows and mac
+type NoPrivilege struct{}
+
+func (NoPrivilege) Name() string {
+	return "udev"
+}
+
+func (NoPrivilege) Command(binary string, args []string) (string, []string, string) {
+	return binary, args, ""
+}
+
+func (NoPrivilege) Check() error {
+	return nil
+}
+
+// Asks through the polkit agent of the desktop, once per command
+type Pkexec struct{}
+
+func (Pkexec) Name() string {
+	return "pkexec"
+}
+
+// pkexec does not keep the working directory, so the binary needs an absolute path
+func (Pkexec) Command(binary string, args []string) (string, []string, string) {
+	abs, err := filepath.Abs(binary)
+	if err == nil {
+		binary = abs
+	}
+	return "pkexec", append([]string{binary}, args...), ""
+}
+
+func (Pkexec) Check() error {
+	_, err := exec.LookPath("pkexec")
+	if err != nil {
+		return fmt.Errorf("pkexec is not installed")
+	}
+	return nil
+}
+
+// Without password, sudo asks on the terminal or uses its cached credentials
+type Sudo struct {
+	Password string
+}
+
+func (s *Sudo) Name() string {
+	return "sudo"
+}
+
+func (s *Sudo) Command(binary string, args []string) (string, []string, string) {
+	if s.Password == "" {
+		return "sudo", append([]string{"--", binary}, args...), ""
+	}
+	// -k always reads the password, otherwise cached credentials would leave it on the stdin of the binary
+	// -p "" keeps the prompt out of stderr
+	return "sudo", append([]string{"-k", "-S", "-p", "", "--", binary}, args...), s.Password + "\n"
+}
+
+func (s *Sudo) Check() error {
+	if s.Password == "" {
+		return s.checkWithoutPassword()
+	}
+	command, args, stdin := s.Command("true", nil)
+	_, stderr, err := run(command, args, stdin)
+	if err != nil {
+		if strings.Contains(stderr, "incorrect password") || strings.Contains(stderr, "Sorry, try again") {
+			return fmt.Errorf("wrong sudo password")
+		}
+		if strings.TrimSpace(stderr) == "" {
+			return fmt.Errorf("sudo failed: %v", err)
+		}
+		return fmt.Errorf("sudo failed: %s", strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// Uses the cached credentials or asks for the password on the terminal to cache them
+func (s *Sudo) checkWithoutPassword() error {
+	_, _, err := run("sudo", []string{"-n", "true"}, "")
+	if err == nil {
+		return nil
+	}
+
+	c := exec.Command("sudo", "-v")
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	err = c.Run()
+	if err != nil {
+		return fmt.Errorf("no sudo password given and sudo was unable to ask for it on the terminal")
+	}
+	return nil
+}
+
+// Runs one of the binaries shipped with the app using the configured privilege strategy
+func CmdPrivileged(binary string, args ...string) (stdout string, stderr string) {
+	command, command_args, stdin := Privileged.Command(binary, args)
+	stdout, stderr, _ = run(command, command_args, stdin)
+	return stdout, stderr
+}
+
+// Runs any command with the given privilege strategy, e.g. to install system files
+func CmdAs(p Privilege, command string, args ...string) (stdout string, stderr string, err error) {
+	command, command_args, stdin := p.Command(command, args)
+	return run(command, command_args, stdin)
+}
@@ -7,8 +7,11 @@ import(
 	"fyne.io/fyne/v2/widget"
 	"fyne.io/fyne/v2/dialog"
 
-	"strings"
+	"sync"
+	"time"
+	"errors"
 	"net/url"
+	"path/filepath"
 
 	"github.com/amo13/anarchy-droid/get"
 	"github.com/amo13/anarchy-droid/logger"
@@ -17,9 +20,13 @@ import(
 )
 
 var Btn_bootloop_help *widget.Button
+var Btn_export_diagnostics *widget.Button
 var Lbl_bootloop_entry *widget.Label
 var Lbl_bootloop_info *widget.Label
-var Entry_bootloop_model *widget.Entry
+var Entry_bootloop_model *widget.SelectEntry
+var bootloop_candidates map[string]lookup.Candidate	// Search results offered by Entry_bootloop_model
+var bootloop_candidates_mutex sync.Mutex	// The search runs in the background
+var bootloop_search_timer *time.Timer	// Delays the search until the user stops typing
 var Btn_bootloop_start_rescue *widget.Button
 
 
@@ -33,9 +40,15 @@ func btnBootloopHelpClicked() {
 func btnBootloopStartRescueClicked() {
 	if Entry_bootloop_model.Text == "" { return	}
 	
-	bootloop_codename, err := lookup.ModelToCodename(Entry_bootloop_model.Text)
+	var bootloop_codename string
+	var err error
+	if c, found := bootloopCandidate(Entry_bootloop_model.Text); found {
+		bootloop_codename = c.Codename
+	} else {
+		bootloop_codename, err = lookup.ModelToCodename(Entry_bootloop_model.Text)
+	}
 	if err != nil {
-		if strings.Contains(err.Error(), "ambiguous") {
+		if errors.Is(err, lookup.ErrAmbiguous) {
 			cc, err := lookup.ModelToCodenameCandidates(Entry_bootloop_model.Text)
 			if err != nil {
 				logger.LogError("Error retrieving codename candidates from model " + Entry_bootloop_model.Text, err)
@@ -58,11 +71,14 @@ func btnBootloopStartRescueClicked() {
 
 			Candidates.Options = helpers.UniqueNonEmptyElementsOfSlice(mc)
 
-			candidates_dialog := dialog.NewCustom("Select your device model", "OK", Candidates, w)
+			reported_model := Entry_bootloop_model.Text
+			candidates_dialog := dialog.NewCustom("Select your device model", "OK", container.NewVBox(Candidates, Chk_remember_candidate), w)
 			candidates_dialog.SetOnClosed(func() {
 				bootloop_codename, err = lookup.ModelToCodename(Candidates.Selected)
 				if err != nil {
 					logger.LogError("Unable to lookup model to codename:", err)
+				} else if Chk_remember_candidate.Checked {
+					rememberCandidate(reported_model, bootloop_codename)
 				}
 			})
 			candidates_dialog.Show()
@@ -92,30 +108,120 @@ func btnBootloopStartRescueClicked() {
 			}
 		}
 
-		err := bootloopRescue(bootloop_codename)
+		w.SetContent(flashingScreen())
+		active_screen = "flashingScreen"
+
+		err := bootloopRescue(bootloop_codename, guiFlashOptions(), &guiFlashUi{})
 		if err != nil {
 			logger.LogError("Failed to rescue from bootloop.", err)
 		}
 	} else {
-		Lbl_bootloop_info.SetText("Sorry, unknown device.")
+		results, err := lookup.Search(Entry_bootloop_model.Text, 10)
+		if err != nil {
+			logger.LogError("Unable to search for " + Entry_bootloop_model.Text + ":", err)
+		}
+		if len(results) > 0 {
+			setBootloopCandidates(results)
+			Lbl_bootloop_info.SetText("Unknown device. Please select it from the suggestions of the model field.")
+		} else {
+			Lbl_bootloop_info.SetText("Sorry, unknown device.")
+		}
 	}
 	
 	Btn_bootloop_start_rescue.Enable()
 }
 
+// Searches the devices matching what the user typed so far
+func entryBootloopModelChanged(text string) {
+	if _, found := bootloopCandidate(text); found || len(text) < 3 {
+		return
+	}
+
+	// Searching scans the whole index, so only search for the last change
+	bootloop_candidates_mutex.Lock()
+	defer bootloop_candidates_mutex.Unlock()
+	if bootloop_search_timer != nil {
+		bootloop_search_timer.Stop()
+	}
+	bootloop_search_timer = time.AfterFunc(250 * time.Millisecond, func() {
+		// The user has typed on in the meantime
+		if Entry_bootloop_model.Text != text {
+			return
+		}
+		// Building the search index can take a while the first time
+		results, err := lookup.Search(text, 10)
+		if err != nil {
+			logger.LogError("Unable to search for " + text + ":", err)
+			return
+		}
+		if Entry_bootloop_model.Text != text {
+			return
+		}
+		setBootloopCandidates(results)
+	})
+}
+
+func setBootloopCandidates(results []lookup.Candidate) {
+	candidates := make(map[string]lookup.Candidate)
+	options := []string{}
+	for _, c := range results {
+		if _, found := candidates[c.String()]; !found {
+			candidates[c.String()] = c
+			options = append(options, c.String())
+		}
+	}
+
+	bootloop_candidates_mutex.Lock()
+	bootloop_candidates = candidates
+	bootloop_candidates_mutex.Unlock()
+	Entry_bootloop_model.SetOptions(options)
+}
+
+// Returns the search result the user selected
+func bootloopCandidate(text string) (lookup.Candidate, bool) {
+	bootloop_candidates_mutex.Lock()
+	defer bootloop_candidates_mutex.Unlock()
+	c, found := bootloop_candidates[text]
+	return c, found
+}
+
+// Pulling the recovery log can take a while, so do not block the gui
+func btnExportDiagnosticsClicked() {
+	Btn_export_diagnostics.Disable()
+	go func() {
+		defer Btn_export_diagnostics.Enable()
+
+		zip_path := diagnosticsFilename()
+		_, err := exportDiagnostics(zip_path)
+		if err != nil {
+			logger.LogError("Unable to export the diagnostics:", err)
+			dialog.ShowError(err, w)
+			return
+		}
+
+		abs_path, err := filepath.Abs(zip_path)
+		if err != nil {
+			abs_path = zip_path
+		}
+		dialog.ShowInformation("Diagnostics exported", "Please attach this file to your report:\n" + abs_path + "\n\nSerial numbers, IMEIs and other personal data have been removed.", w)
+	}()
+}
+
 func initHelptabWidgets() {
 	Btn_bootloop_help = widget.NewButton("My device is not booting any more", btnBootloopHelpClicked)
-	Lbl_bootloop_entry = widget.NewLabel("Enter your device model:")
+	Lbl_bootloop_entry = widget.NewLabel("Search your device by its name, model or codename:")
 	Lbl_bootloop_info = widget.NewLabel("")
-	Entry_bootloop_model = widget.NewEntry()
+	Entry_bootloop_model = widget.NewSelectEntry([]string{})
+	Entry_bootloop_model.OnChanged = entryBootloopModelChanged
 	Btn_bootloop_start_rescue = widget.NewButton("Rescue", btnBootloopStartRescueClicked)
+	Btn_export_diagnostics = widget.NewButton("Export diagnostics", btnExportDiagnosticsClicked)
 }
 
 func setDefaultsHelptab() {
 	Lbl_bootloop_entry.Hide()
 	Lbl_bootloop_info.Hide()
 	Entry_bootloop_model.Hide()
-	Entry_bootloop_model.PlaceHolder = "For example: SM-G900F"
+	Entry_bootloop_model.PlaceHolder = "For example: Moto G5 Plus or SM-G900F"
 	Btn_bootloop_start_rescue.Hide()
 }
 
@@ -139,7 +245,7 @@ func helptab() fyne.CanvasObject {
 	link_to_universal_drivers := widget.NewHyperlinkWithStyle("Universal drivers", u2, fyne.TextAlignCenter, fyne.TextStyle{})
 
 
-	leftside := container.NewVBox(tryfirst, widget.NewLabel(""), container.NewCenter(container.NewHBox(link_to_official_drivers, link_to_universal_drivers)))
+	leftside := container.NewVBox(tryfirst, widget.NewLabel(""), container.NewCenter(container.NewHBox(link_to_official_drivers, link_to_universal_drivers)), widget.NewLabel(""), Btn_export_diagnostics)
 	leftcard := widget.NewCard("", "", leftside)
 
 	rightside := container.NewVBox(Btn_bootloop_help, Lbl_bootloop_entry, Entry_bootloop_model, Btn_bootloop_start_rescue, Lbl_bootloop_info)
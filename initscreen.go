@@ -11,16 +11,17 @@ import(
 
 	"github.com/amo13/anarchy-droid/get"
 	"github.com/amo13/anarchy-droid/device"
+	"github.com/amo13/anarchy-droid/lookup"
 	"github.com/amo13/anarchy-droid/logger"
 	"github.com/amo13/anarchy-droid/helpers"
 	"github.com/amo13/anarchy-droid/device/adb"
-	"github.com/amo13/anarchy-droid/device/fastboot"
-	"github.com/amo13/anarchy-droid/device/heimdall"
+	"github.com/amo13/anarchy-droid/device/errs"
 
 	"github.com/creativeprojects/go-selfupdate"
 
 	"os"
 	"fmt"
+	"errors"
 	"flag"
 	"time"
 	"context"
@@ -77,6 +78,56 @@ func initScreen() fyne.CanvasObject {
 	return container.NewVBox(Lbl_init_text1, layout.NewSpacer(), grid, layout.NewSpacer(), Lbl_init_infotext)
 }
 
+// Moves into the working directory of the app and creates the log directory
+func setupWorkingDirectory() {
+	// On MacOS, move into the user's Downloads folder
+	// to prevent being either inside the read-only
+	// application package or inside a jail folder
+	if runtime.GOOS == "darwin" {
+		u, _ := helpers.Cmd("whoami")
+		u = strings.ReplaceAll(u, "\n", "")
+		os.Chdir("/Users/" + u + "/Downloads")
+	}
+
+	// Set working directory to a subdir named like the app
+	_, err := os.Stat(AppName)
+	if os.IsNotExist(err) {
+		err = os.Mkdir(AppName, 0755)
+	    if err != nil {
+	        logger.LogError("Error setting working directory:", err)
+	    }
+	}
+	os.Chdir(AppName)
+
+	// Create log directory if it does not exist
+	_, err = os.Stat("log")
+	if os.IsNotExist(err) {
+		err = os.Mkdir("log", 0755)
+	    if err != nil {
+	        logger.LogError("Unable to create log directory:", err)
+	    }
+	}
+
+	// Log into the new working directory from now on
+	logger.Reopen()
+
+	// Organizations can send the reports to their own server
+	err = logger.LoadReporterConfig("reporting.yml")
+	if err != nil {
+		logger.LogError("Unable to load the reporting configuration:", err)
+	}
+	// Send the reports queued while offline
+	go logger.FlushReports()
+
+	// The bundled device database is used until a newer one has been downloaded
+	go func() {
+		err := lookup.UpdateDatabase()
+		if err != nil {
+			logger.Log("Unable to update the device database:", err.Error())
+		}
+	}()
+}
+
 func initApp() (bool, error) {
 	Lbl_init_infotext.SetText("Checking internet connection...")
 	status_code, err := get.StatusCode("https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/lookup/codenames.yml")
@@ -103,7 +154,7 @@ func initApp() (bool, error) {
 	if AppVersion != "DEVELOPMENT" {
 		err = selfUpdate(AppVersion)
 		if err != nil {
-			if err.Error() == "Update successful, please restart the application" {
+			if errors.Is(err, errUpdated) {
 				Icon_uptodate.SetResource(theme.MediaReplayIcon())
 				info_dialog := dialog.NewInformation("Update successful", AppName + " has been updated. Please restart the application.", w)
 				info_dialog.SetOnClosed(func() { a.Quit() } )
@@ -135,29 +186,62 @@ func initApp() (bool, error) {
 			logger.LogError("unable to parse " + href + " as URL:", err)
 		}
 		info := widget.NewHyperlink("Show more info on this", u)
-		udev := false
+		// Labels of the privilege strategies
+		strategies := map[string]string{
+			"sudo password": "sudo",
+			"udev rules (no root)": "udev",
+		}
+		strategy := widget.NewRadioGroup([]string{"sudo password", "udev rules (no root)"}, func(selected string) {
+			if selected == "sudo password" {
+				password.Enable()
+			} else {
+				password.Disable()
+			}
+		})
+		strategy.Required = true
+		if udevRulesInstalled() {
+			strategy.SetSelected("udev rules (no root)")
+		} else {
+			strategy.SetSelected("sudo password")
+		}
+		var btn_udev *widget.Button
+		btn_udev = widget.NewButton("Install udev rules", func() {
+			btn_udev.Disable()
+			go func() {
+				defer btn_udev.Enable()
+				// Installing needs root, the password is used if given
+				installer, _ := helpers.NewPrivilege("pkexec", "")
+				if password.Text != "" {
+					installer, _ = helpers.NewPrivilege("sudo", password.Text)
+				}
+				err := installUdevRules(installer)
+				if err != nil {
+					logger.LogError("Unable to install the udev rules:", err)
+					dialog.ShowError(err, w)
+					return
+				}
+				strategy.SetSelected("udev rules (no root)")
+				dialog.ShowInformation("Udev rules installed", "Your devices can now be accessed without root.", w)
+			}()
+		})
 		items := []*widget.FormItem{
+			widget.NewFormItem("Access the device with", strategy),
 			widget.NewFormItem("Sudo password", password),
-			widget.NewFormItem("Why does this app need sudo?", info),
-			widget.NewFormItem("I have setup udev rules for my device", widget.NewCheck("", func(checked bool) {
-				udev = checked
-				if checked {
-					password.Disable()
-				} else {
-					password.Enable()
-				}
-			})),
+			widget.NewFormItem("No udev rules yet?", btn_udev),
+			widget.NewFormItem("Why does this app need root?", info),
 		}
 
-		dialog.ShowForm(AppName + " needs either sudo password or udev rules", "Continue", "Exit", items, func(b bool) {
+		dialog.ShowForm(AppName + " needs root access or udev rules", "Continue", "Exit", items, func(b bool) {
 			if b {
 				Lbl_init_infotext.Text = "Restarting ADB server..."
-				adb.Nosudo = udev
-				adb.Sudopw = password.Text
-				fastboot.Nosudo = udev
-				fastboot.Sudopw = password.Text
-				heimdall.Nosudo = udev
-				heimdall.Sudopw = password.Text
+				p, err := helpers.DevicePrivilege(strategies[strategy.Selected], password.Text)
+				if err != nil {
+					logger.LogError("Unable to set up the privilege strategy:", err)
+					a.Quit()
+					return
+				}
+				helpers.Privileged = p
+				logger.AddSecret(logger.SecretPassword, password.Text)
 
 				finishInitApp()
 			} else {
@@ -176,23 +260,21 @@ func initApp() (bool, error) {
 
 func finishInitApp() (bool, error) {
 	// Detect if the given sudo password is wrong and exit in that case
-	if adb.Sudopw != "" {
-		_, stderr := helpers.Cmd("printf " + adb.Sudopw + " | sudo -S ls")
-		if strings.Contains(stderr, "incorrect password") {
-			logger.Log("sudo password seems to be wrong")
-			wrong_sudo_pw_dialog := dialog.NewError(fmt.Errorf("The sudo password seems to be wrong."), w)
-			wrong_sudo_pw_dialog.SetOnClosed(func() {
-				logger.Log("Exiting...")
-				a.Quit()
-			})
-			wrong_sudo_pw_dialog.Show()
-			return false, fmt.Errorf("wrong sudo password")
-		}
+	err := helpers.Privileged.Check()
+	if err != nil {
+		logger.Log("Unable to get root access with " + helpers.Privileged.Name() + ":", err.Error())
+		wrong_sudo_pw_dialog := dialog.NewError(fmt.Errorf("Unable to get root access with %s: %s", helpers.Privileged.Name(), err.Error()), w)
+		wrong_sudo_pw_dialog.SetOnClosed(func() {
+			logger.Log("Exiting...")
+			a.Quit()
+		})
+		wrong_sudo_pw_dialog.Show()
+		return false, err
 	}
 
 	// Restart the ADB server (as root on linux)
-	err := adb.KillServer()
-	if err != nil && err.Error() != "connection refused" {
+	err = adb.KillServer()
+	if err != nil && !errors.Is(err, errs.ErrConnectionRefused) {
 		Icon_adbserver.SetResource(theme.CancelIcon())
 		return false, err
 	}
@@ -229,6 +311,9 @@ func finishInitApp() (bool, error) {
 	}
 }
 
+// Returned by selfUpdate once the new binary is in place
+var errUpdated = errors.New("Update successful, please restart the application")
+
 func selfUpdate(version string) error {
 	latest, found, err := selfupdate.DetectLatest(context.Background(), selfupdate.ParseSlug("amo13/Anarchy-Droid"))
 	if err != nil {
@@ -251,7 +336,7 @@ func selfUpdate(version string) error {
 		return fmt.Errorf("error occurred while updating binary: %v", err)
 	}
 	logger.Log("Successfully updated to version", latest.Version())
-	return fmt.Errorf("Update successful, please restart the application")
+	return errUpdated
 }
 
 func doDevStuff() {
@@ -261,13 +346,23 @@ func doDevStuff() {
 	}
 
 	var simulate_model string
+	var sim_config string
 
 	flag.StringVar(&simulate_model, "s", "", "Simulate the connection of a device model.")
+	flag.StringVar(&sim_config, "sim-config", "", "YAML file describing the simulated device, its timings and failures.")
 	flag.Parse()
 
-	if simulate_model != "" {
-		// Simulate the connection of the given device model
-		device.D1.Test(simulate_model)
+	if simulate_model != "" || sim_config != "" {
+		// Replace the adb, fastboot and heimdall binaries by a simulated phone
+		// which the device observer picks up like a real connection
+		err := simulateDevice(simulate_model, sim_config)
+		if err != nil {
+			logger.LogError("Unable to simulate the device:", err)
+			return
+		}
+		for device.D1.Codename == "" || device.D1.Scanning {
+			time.Sleep(1 * time.Second)
+		}
 
 		// Wait for the availables struct to be populated
 		time.Sleep(3 * time.Second)
@@ -0,0 +1,175 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"strings"
+	"strconv"
+	"encoding/json"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Entries below this level are dropped
+var MinLevel = LevelInfo
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %s", s)
+	}
+}
+
+// Key/value pairs attached to log entries, e.g. serial, step, command or duration
+type Fields map[string]string
+
+// Added to every entry, e.g. the serial number of the connected device
+var default_fields = Fields{}
+var write_mutex sync.Mutex
+
+// Sets a field for all following entries, removes it if the value is empty
+func SetField(key string, value string) {
+	write_mutex.Lock()
+	defer write_mutex.Unlock()
+	if value == "" {
+		delete(default_fields, key)
+	} else {
+		default_fields[key] = value
+	}
+}
+
+type Entry struct {
+	Time time.Time
+	Level Level
+	Message string
+	Fields Fields
+}
+
+// Level, message and fields on one line, fields sorted by key
+func (e Entry) Text() string {
+	result := strings.ToUpper(e.Level.String()) + " " + e.Message
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := e.Fields[k]
+		if v == "" || strings.ContainsAny(v, " =\"\n\t") {
+			v = strconv.Quote(v)
+		}
+		result = result + " " + k + "=" + v
+	}
+
+	return result
+}
+
+func (e Entry) JSON() ([]byte, error) {
+	m := map[string]string{}
+	for k, v := range e.Fields {
+		m[k] = v
+	}
+	m["time"] = e.Time.Format(time.RFC3339Nano)
+	m["level"] = e.Level.String()
+	m["msg"] = e.Message
+
+	return json.Marshal(m)
+}
+
+// Logs entries with a set of fields
+type Logger struct {
+	fields Fields
+}
+
+func With(fields Fields) *Logger {
+	return &Logger{fields: fields}
+}
+
+// Returns a logger with the fields of both
+func (l *Logger) With(fields Fields) *Logger {
+	merged := Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{fields: merged}
+}
+
+func (l *Logger) Debug(s ...string) {
+	l.log(LevelDebug, strings.Join(s, " "))
+}
+
+func (l *Logger) Info(s ...string) {
+	l.log(LevelInfo, strings.Join(s, " "))
+}
+
+func (l *Logger) Warn(s ...string) {
+	l.log(LevelWarn, strings.Join(s, " "))
+}
+
+// Logs at error level without reporting to sentry, see LogError
+func (l *Logger) Error(s ...string) {
+	l.log(LevelError, strings.Join(s, " "))
+}
+
+func (l *Logger) log(level Level, message string) {
+	if level < MinLevel {
+		return
+	}
+
+	write_mutex.Lock()
+	defer write_mutex.Unlock()
+
+	fields := Fields{}
+	for k, v := range default_fields {
+		fields[k] = v
+	}
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	write(Entry{Time: time.Now(), Level: level, Message: message, Fields: fields})
+}
+
+func Debug(s ...string) {
+	With(nil).Debug(s...)
+}
+
+func Info(s ...string) {
+	With(nil).Info(s...)
+}
+
+func Warn(s ...string) {
+	With(nil).Warn(s...)
+}
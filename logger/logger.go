@@ -3,17 +3,17 @@ package logger
 import (
 	"os"
 	"fmt"
-	"log"
 	"time"
 	"bytes"
-	"strings"
+	"errors"
 	"runtime"
-	"net/http"
     "io/ioutil"
     "math/rand"
 
 	"gopkg.in/yaml.v3"
 	"github.com/getsentry/sentry-go"
+
+	"github.com/amo13/anarchy-droid/device/errs"
 )
 
 // Set to "0" after first report so we can see if the program had to be restarted
@@ -27,71 +27,42 @@ var Consent bool
 var Device_model string
 var Device_codename string
 var LoggedErrors map[string]bool = map[string]bool{}
+// Only write to the log file, e.g. to keep the command line output readable
+var Quiet bool
+// Called with every logged line, e.g. to stream the log to api clients
+var hooks []func(line string)
 
+// Queues a report for the configured reporter
 func Report(params map[string]string) {
-	if params["tracking_consent"] == "false" || !Consent || AppVersion == "DEVELOPMENT" {
+	_, upstream := Reporting.(*MatomoReporter)
+	if params["tracking_consent"] == "false" || !Consent || (upstream && AppVersion == "DEVELOPMENT") {
 		Log("Skipped reporting:")
 		Log(mapToString(params))
 		return
 	}
-
-	req, err := http.NewRequest("GET", "https://stats.anarchy-droid.com/matomo.php", nil)
-	if err != nil {
-		LogError("Could not create http request for reporting", err)
+	if _, none := Reporting.(NoReporter); none {
 		return
 	}
-	q := req.URL.Query()
 
-	q.Add("idsite", "3")
-	q.Add("rec", "1")
-	q.Add("send_image", "0")
-	q.Add("_id", session()["id"])
-	q.Add("new_Visit", newVisit)
-	newVisit = "0"
-	q.Add("ua", userAgent() + " Firefox/" + AppVersion)
-	q.Add("_cvar", "{\"1\":[\"Version\",\"" + AppVersion + "\"],\"2\":[\"Build\",\"" + BuildDate + "\"],\"3\":[\"Device Model\",\"" + Device_model + "\"],\"4\":[\"Device Codename\",\"" + Device_codename + "\"]}")
-	q.Add("e_c", params["category"])
-	if params["action"] != "" {
-		q.Add("e_a", params["action"])
-	}
-	if params["name"] != "" {
-		q.Add("e_n", params["name"])
-	}
-	if params["value"] != "" {
-		q.Add("e_v", params["value"])
+	e := &ReportEvent{
+		Time: time.Now().Format(time.RFC3339),
+		Session: session()["id"],
+		New_visit: newVisit == "1",
+		Version: AppVersion,
+		Build: BuildDate,
+		Os: runtime.GOOS,
+		Model: Device_model,
+		Codename: Device_codename,
+		Params: make(map[string]string),
 	}
-	if params["progress"] != "" {
-		q.Add("url", "https://app/progress/" + params["progress"])
-	}
-	if params["tracking"] != "" {
-		q.Add("url", "https://app/tracking/" + params["tracking"])
-	}
-	
-	// If more parameters are given, add them to the report with their respective values
-	// For this, remove the params taken into account and range over the rest
-	delete(params, "tracking_consent")
-	delete(params, "_id")
-	delete(params, "ua")
-	delete(params, "_cvar")
-	delete(params, "category")
-	delete(params, "action")
-	delete(params, "name")
-	delete(params, "value")
-	delete(params, "progress")
-	delete(params, "tracking")
-
+	newVisit = "0"
 	for k, v := range params {
-		q.Add(k, v)
+		if k != "tracking_consent" {
+			e.Params[k] = Redact(v)
+		}
 	}
 
-	req.URL.RawQuery = q.Encode()
-	client := http.Client{}
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-	if err != nil {
-		LogError("Error submitting the report:", err)
-		return
-	}
+	enqueueReport(e)
 }
 
 func userAgent() string {
@@ -159,13 +130,20 @@ func session() map[string]string {
 	return Sessionmap
 }
 
+// Logs at info level
 func Log(s ...string) {
-	fmt.Println(strings.Join(s[:]," "))
-	appendToLogFile(strings.Join(s[:]," "))
+	Info(s...)
+}
+
+// Registers a function to be called with every logged line
+func AddHook(hook func(line string)) {
+	write_mutex.Lock()
+	defer write_mutex.Unlock()
+	hooks = append(hooks, hook)
 }
 
 func LogError(message string, err error) {
-	if err.Error() != "cancelled" {
+	if !errors.Is(err, errs.ErrCancelled) {
 		// Only send error to sentry once, prevent flooding sentry
 		if LoggedErrors[err.Error()] == false {
 			sentry.WithScope(func(scope *sentry.Scope) {
@@ -174,26 +152,15 @@ func LogError(message string, err error) {
 					scope.SetTag("codename", Device_codename)
 					scope.SetTag("model", Device_model)
 				}
-				sentry.CaptureException(fmt.Errorf(message + " " + err.Error()))
+				sentry.CaptureException(fmt.Errorf("%s", Redact(message + " " + err.Error())))
 			})
 			LoggedErrors[err.Error()] = true
 		}
 
-		Log("ERROR: " + message + " " + err.Error())
+		With(nil).Error(message + " " + err.Error())
 	}
 }
 
-func appendToLogFile(s string) {
-	f, err := os.OpenFile("log/" + AppName + ".log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-	    fmt.Println("Cannot open log file for writing:", err)
-	}
-	defer f.Close()
-
-	log2file := log.New(f, AppName + " ", log.LstdFlags)
-	log2file.Println(s)
-}
-
 // Redefine from helpers to make this package free of internal
 // dependencies so we can include it in every other package
 func mapToString(m map[string]string) string {
@@ -0,0 +1,160 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"regexp"
+	"strings"
+	"crypto/sha256"
+)
+
+// Kinds of sensitive values masked before anything is written or sent
+const (
+	SecretImei = "imei"
+	SecretSerial = "serial"
+	SecretUnlockCode = "unlock_code"
+	SecretPassword = "password"
+	SecretUserPath = "user_path"
+)
+
+var secret_kinds = []string{SecretImei, SecretSerial, SecretUnlockCode, SecretPassword, SecretUserPath}
+
+// Known values, e.g. the serial number of the connected device, mapped to their kind
+var secrets = map[string]string{}
+var secrets_mutex sync.Mutex
+
+// Kinds kept readable in the local log files and on the console,
+// they are still masked in reports, api streams and diagnostics
+var local_allow = map[string]bool{}
+
+type redactPattern struct {
+	kind string
+	re *regexp.Regexp
+	// Number of leading submatches to keep
+	keep int
+}
+
+// Catch sensitive values which have not been registered, e.g. in raw command output
+var redact_patterns = []redactPattern{
+	// printf <password> | sudo -S
+	{SecretPassword, regexp.MustCompile(`(printf\s+)(\S+)(\s*\|\s*sudo\s+-S)`), 1},
+	// fastboot oem unlock <code>, unlock_code: <code>, ?code=<code>
+	{SecretUnlockCode, regexp.MustCompile(`(?i)(oem unlock\s+|unlock_code["\]:= \t\[]+|[?&]code=)([0-9A-Za-z#]{4,})`), 1},
+	// serialno: <serial> from fastboot getvar, [ro.serialno]: [<serial>] from getprop, Serial number: <serial>
+	{SecretSerial, regexp.MustCompile(`(?i)(serial(?:no|\s+number)?["\]]?\s*[:=]\s*["\[]?)([0-9A-Za-z]{4,})`), 1},
+	// 15 digits not part of a longer number
+	{SecretImei, regexp.MustCompile(`(^|[^0-9])([0-9]{15})([^0-9]|$)`), 1},
+	// Home directories reveal the user name
+	{SecretUserPath, regexp.MustCompile(`(/home/|/Users/|(?i:[A-Z]:\\Users\\))([^/\\\s"']+)`), 1},
+}
+
+// Registers a value to be masked wherever it appears
+// Values shorter than 4 characters would mask too much and are ignored
+func AddSecret(kind string, value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < 4 || value == "not found" {
+		return
+	}
+
+	secrets_mutex.Lock()
+	defer secrets_mutex.Unlock()
+	secrets[value] = kind
+}
+
+// Keeps the given kinds readable in the local log files, e.g. to debug with one's own device
+func AllowLocal(kinds ...string) error {
+	secrets_mutex.Lock()
+	defer secrets_mutex.Unlock()
+	for _, kind := range kinds {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+		known := false
+		for _, k := range secret_kinds {
+			if k == kind {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown kind of secret %s, expected one of %s", kind, strings.Join(secret_kinds, ", "))
+		}
+		local_allow[kind] = true
+	}
+
+	return nil
+}
+
+// Masks all sensitive values
+func Redact(s string) string {
+	return redact(s, nil)
+}
+
+func redact(s string, allow map[string]bool) string {
+	if s == "" {
+		return s
+	}
+
+	secrets_mutex.Lock()
+	values := make([]string, 0, len(secrets))
+	for v, kind := range secrets {
+		if !allow[kind] {
+			values = append(values, v)
+		}
+	}
+	kinds := make(map[string]string, len(values))
+	for _, v := range values {
+		kinds[v] = secrets[v]
+	}
+	secrets_mutex.Unlock()
+
+	// Longer values first, e.g. "<imei> <serial>" before each of them
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	for _, v := range values {
+		s = strings.ReplaceAll(s, v, mask(kinds[v], v))
+	}
+
+	for _, p := range redact_patterns {
+		if allow[p.kind] {
+			continue
+		}
+		s = p.re.ReplaceAllStringFunc(s, func(match string) string {
+			sub := p.re.FindStringSubmatch(match)
+			result := strings.Join(sub[1:p.keep + 1], "")
+			if strings.HasPrefix(sub[p.keep + 1], "<") {
+				// Already masked
+				result = result + sub[p.keep + 1]
+			} else {
+				result = result + mask(p.kind, sub[p.keep + 1])
+			}
+			return result + strings.Join(sub[p.keep + 2:], "")
+		})
+	}
+
+	return s
+}
+
+// Serials and IMEIs get a short hash so entries about the same device can still be correlated
+func mask(kind string, value string) string {
+	switch kind {
+	case SecretImei, SecretSerial:
+		return fmt.Sprintf("<%s-%x>", kind, sha256.Sum256([]byte(value)))[:len(kind) + 6] + ">"
+	case SecretUserPath:
+		return "<user>"
+	default:
+		return "<" + kind + ">"
+	}
+}
+
+func (e Entry) redacted(allow map[string]bool) Entry {
+	fields := Fields{}
+	for k, v := range e.Fields {
+		fields[k] = redact(v, allow)
+	}
+	e.Message = redact(e.Message, allow)
+	e.Fields = fields
+	return e
+}
@@ -0,0 +1,429 @@
+package logger
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"bytes"
+	"bufio"
+	"errors"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Reports are queued in a file first and sent by the configured reporter,
+// so reports made while offline are sent as soon as the connection returns
+
+// A progress or tracking report with the context it was made in
+type ReportEvent struct {
+	Time string `json:"time"`
+	Session string `json:"session"`
+	New_visit bool `json:"new_visit,omitempty"`
+	Version string `json:"version"`
+	Build string `json:"build,omitempty"`
+	Os string `json:"os"`
+	Model string `json:"model,omitempty"`
+	Codename string `json:"codename,omitempty"`
+	Params map[string]string `json:"params"`
+}
+
+// Sends reports somewhere, an error keeps the report queued for a later retry
+// unless it is a RejectedError
+type Reporter interface {
+	Send(e *ReportEvent) error
+}
+
+// The server refused the report itself, sending it again would not help
+type RejectedError struct {
+	Status string
+}
+
+func (e *RejectedError) Error() string {
+	return "report rejected: " + e.Status
+}
+
+// Returns an error for unsuccessful responses, a RejectedError for client errors
+// Timeouts and rate limits are worth a retry
+func responseError(server string, resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &RejectedError{Status: server + " responded " + resp.Status}
+	}
+	return fmt.Errorf("%s responded %s", server, resp.Status)
+}
+
+// Upstream statistics of the app
+var Reporting Reporter = &MatomoReporter{Url: "https://stats.anarchy-droid.com/matomo.php", Site_id: "3"}
+
+const ReportQueueFile = "log/report_queue.jsonl"
+// Oldest reports are dropped if the queue grows beyond this
+var MaxQueuedReports = 1000
+// Wait between attempts to send the queued reports
+var ReportRetryInterval = 5 * time.Minute
+
+// Guards the queue file, never held while sending
+var queue_mutex sync.Mutex
+// Only one flush at a time, so that no report is sent twice
+var flush_mutex sync.Mutex
+var flush_requests = make(chan bool, 1)
+var flusher_once sync.Once
+
+// Configuration of the reporter, read from reporting.yml if present:
+//
+//	backend: webhook	# matomo, jsonl, webhook or none
+//	url: https://stats.example.org/anarchy-droid
+//	headers:
+//	  Authorization: Bearer 123
+type ReporterConfig struct {
+	Backend string `yaml:"backend"`
+	Url string `yaml:"url,omitempty"`
+	Site_id string `yaml:"site_id,omitempty"`
+	Path string `yaml:"path,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+func NewReporter(c *ReporterConfig) (Reporter, error) {
+	switch strings.ToLower(c.Backend) {
+	case "matomo":
+		r := &MatomoReporter{Url: c.Url, Site_id: c.Site_id}
+		if r.Url == "" {
+			r.Url = "https://stats.anarchy-droid.com/matomo.php"
+		}
+		if r.Site_id == "" {
+			r.Site_id = "3"
+		}
+		return r, nil
+	case "jsonl":
+		if c.Path == "" {
+			c.Path = "log/reports.jsonl"
+		}
+		return &JSONLReporter{Path: c.Path}, nil
+	case "webhook":
+		if c.Url == "" {
+			return nil, fmt.Errorf("the webhook reporter needs an url")
+		}
+		return &WebhookReporter{Url: c.Url, Headers: c.Headers}, nil
+	case "none", "":
+		return NoReporter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown reporting backend %s", c.Backend)
+	}
+}
+
+// Replaces the reporter if the given file exists
+func LoadReporterConfig(file_path string) error {
+	yamldata, err := ioutil.ReadFile(file_path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	c := &ReporterConfig{}
+	err = yaml.Unmarshal(yamldata, c)
+	if err != nil {
+		return err
+	}
+	r, err := NewReporter(c)
+	if err != nil {
+		return err
+	}
+
+	Log("Sending reports to the " + c.Backend + " backend")
+	Reporting = r
+	return nil
+}
+
+// The upstream statistics server
+type MatomoReporter struct {
+	Url string
+	Site_id string
+}
+
+func (m *MatomoReporter) Send(e *ReportEvent) error {
+	req, err := http.NewRequest("GET", m.Url, nil)
+	if err != nil {
+		return err
+	}
+	q := req.URL.Query()
+
+	q.Add("idsite", m.Site_id)
+	q.Add("rec", "1")
+	q.Add("send_image", "0")
+	q.Add("_id", e.Session)
+	if e.New_visit {
+		q.Add("new_Visit", "1")
+	} else {
+		q.Add("new_Visit", "0")
+	}
+	q.Add("ua", userAgent() + " Firefox/" + e.Version)
+	q.Add("_cvar", "{\"1\":[\"Version\",\"" + e.Version + "\"],\"2\":[\"Build\",\"" + e.Build + "\"],\"3\":[\"Device Model\",\"" + e.Model + "\"],\"4\":[\"Device Codename\",\"" + e.Codename + "\"]}")
+	if t, err := time.Parse(time.RFC3339, e.Time); err == nil {
+		q.Add("cdt", t.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	params := make(map[string]string)
+	for k, v := range e.Params {
+		params[k] = v
+	}
+	q.Add("e_c", params["category"])
+	if params["action"] != "" {
+		q.Add("e_a", params["action"])
+	}
+	if params["name"] != "" {
+		q.Add("e_n", params["name"])
+	}
+	if params["value"] != "" {
+		q.Add("e_v", params["value"])
+	}
+	if params["progress"] != "" {
+		q.Add("url", "https://app/progress/" + params["progress"])
+	}
+	if params["tracking"] != "" {
+		q.Add("url", "https://app/tracking/" + params["tracking"])
+	}
+
+	// If more parameters are given, add them to the report with their respective values
+	// For this, remove the params taken into account and range over the rest
+	delete(params, "_id")
+	delete(params, "ua")
+	delete(params, "_cvar")
+	delete(params, "category")
+	delete(params, "action")
+	delete(params, "name")
+	delete(params, "value")
+	delete(params, "progress")
+	delete(params, "tracking")
+
+	for k, v := range params {
+		q.Add(k, v)
+	}
+
+	req.URL.RawQuery = q.Encode()
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return responseError("matomo", resp)
+}
+
+// Appends the reports to a local file, one JSON object per line
+type JSONLReporter struct {
+	Path string
+}
+
+func (j *JSONLReporter) Send(e *ReportEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Posts each report as JSON to an url, e.g. an organization's own statistics server
+type WebhookReporter struct {
+	Url string
+	Headers map[string]string
+}
+
+func (wh *WebhookReporter) Send(e *ReportEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", wh.Url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return responseError("webhook", resp)
+}
+
+// Drops all reports
+type NoReporter struct{}
+
+func (NoReporter) Send(e *ReportEvent) error {
+	return nil
+}
+
+// Appends the report to the queue file and wakes up the sender
+func enqueueReport(e *ReportEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		LogError("Unable to marshal the report:", err)
+		return
+	}
+
+	queue_mutex.Lock()
+	f, err := os.OpenFile(ReportQueueFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = f.Write(append(data, '\n'))
+		f.Close()
+	}
+	queue_mutex.Unlock()
+	if err != nil {
+		Log("Unable to queue the report:", err.Error())
+		return
+	}
+
+	flusher_once.Do(func() {
+		go flusher()
+	})
+	select {
+	case flush_requests <- true:
+	default:
+	}
+}
+
+// Sends the queued reports whenever a new one is queued and retries periodically
+func flusher() {
+	for {
+		select {
+		case <-flush_requests:
+		case <-time.After(ReportRetryInterval):
+		}
+		FlushReports()
+	}
+}
+
+// Sends the queued reports in order and keeps the ones which could not be sent
+// Rejected reports are dropped, they would block the queue forever
+func FlushReports() error {
+	flush_mutex.Lock()
+	defer flush_mutex.Unlock()
+
+	queue_mutex.Lock()
+	events, err := readQueue()
+	queue_mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	sent := 0
+	for _, e := range events {
+		err = Reporting.Send(e)
+		var rejected *RejectedError
+		if errors.As(err, &rejected) {
+			Log("Dropping a queued report:", Redact(err.Error()))
+		} else if err != nil {
+			Log("Unable to send " + fmt.Sprint(len(events) - sent) + " queued reports, retrying later:", Redact(err.Error()))
+			break
+		}
+		sent = sent + 1
+	}
+	if sent == 0 {
+		return nil
+	}
+
+	// Reports queued while sending were appended after the sent ones
+	queue_mutex.Lock()
+	defer queue_mutex.Unlock()
+	current, err := readQueue()
+	if err != nil {
+		return err
+	}
+	if sent > len(current) {
+		sent = len(current)
+	}
+	return writeQueue(current[sent:])
+}
+
+// Must be called with the queue_mutex held
+func readQueue() ([]*ReportEvent, error) {
+	f, err := os.Open(ReportQueueFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []*ReportEvent{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := &ReportEvent{}
+		// Skip lines truncated by a crash
+		if json.Unmarshal(scanner.Bytes(), e) == nil {
+			events = append(events, e)
+		}
+	}
+
+	if len(events) > MaxQueuedReports {
+		events = events[len(events) - MaxQueuedReports:]
+	}
+	return events, scanner.Err()
+}
+
+// Must be called with the queue_mutex held
+func writeQueue(events []*ReportEvent) error {
+	if len(events) == 0 {
+		err := os.Remove(ReportQueueFile)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+
+	tmp := ReportQueueFile + ".tmp"
+	err := ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, ReportQueueFile)
+}
+
+// Waits at most the given time for the queued reports to be sent, e.g. before exiting
+func WaitForReports(timeout time.Duration) {
+	done := make(chan bool, 1)
+	go func() {
+		FlushReports()
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
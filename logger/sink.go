@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"os"
+	"fmt"
+	"strconv"
+	"path/filepath"
+)
+
+// The log file is rotated once it grows beyond MaxLogSize bytes,
+// keeping MaxLogBackups older files named like the log file plus .1, .2, ...
+var MaxLogSize int64 = 10 * 1024 * 1024
+var MaxLogBackups = 3
+
+var log_file *rotatingFile
+// Optional sink writing one JSON object per line
+var json_file *rotatingFile
+
+// Writes an entry to all sinks, must be called with write_mutex held
+// Hooks always get fully redacted lines, the local sinks keep the allowed kinds readable
+func write(e Entry) {
+	line := e.redacted(local_allow).Text()
+
+	if !Quiet {
+		fmt.Println(e.Time.Format("15:04:05") + " " + line)
+	}
+
+	if log_file == nil {
+		log_file = &rotatingFile{path: "log/" + AppName + ".log"}
+	}
+	err := log_file.write([]byte(AppName + " " + e.Time.Format("2006/01/02 15:04:05") + " " + line + "\n"))
+	if err != nil {
+		fmt.Println("Cannot write to the log file:", err)
+	}
+
+	if json_file != nil {
+		data, err := e.redacted(local_allow).JSON()
+		if err == nil {
+			err = json_file.write(append(data, '\n'))
+		}
+		if err != nil {
+			fmt.Println("Cannot write to the JSON log file:", err)
+		}
+	}
+
+	if len(hooks) > 0 {
+		line = e.redacted(nil).Text()
+	}
+	for _, hook := range hooks {
+		hook(line)
+	}
+}
+
+// Additionally writes every entry as a JSON object to the given file
+func EnableJSON(file_path string) error {
+	err := os.MkdirAll(filepath.Dir(file_path), 0755)
+	if err != nil {
+		return err
+	}
+
+	write_mutex.Lock()
+	defer write_mutex.Unlock()
+	if json_file != nil {
+		json_file.close()
+	}
+	json_file = &rotatingFile{path: file_path}
+	return nil
+}
+
+// Closes the log files so they are opened again relative
+// to the current working directory on the next entry
+func Reopen() {
+	write_mutex.Lock()
+	defer write_mutex.Unlock()
+	if log_file != nil {
+		log_file.close()
+		log_file = nil
+	}
+	if json_file != nil {
+		json_file.close()
+	}
+}
+
+// Kept open between entries and rotated by size
+type rotatingFile struct {
+	path string
+	file *os.File
+	size int64
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) close() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+func (r *rotatingFile) rotate() error {
+	r.close()
+
+	for i := MaxLogBackups; i > 0; i-- {
+		older := r.path + "." + strconv.Itoa(i)
+		newer := r.path + "." + strconv.Itoa(i - 1)
+		if i == 1 {
+			newer = r.path
+		}
+		if i == MaxLogBackups {
+			os.Remove(older)
+		}
+		err := os.Rename(newer, older)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if MaxLogBackups < 1 {
+		os.Remove(r.path)
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) write(data []byte) error {
+	if r.file == nil {
+		err := r.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	if MaxLogSize > 0 && r.size > 0 && r.size + int64(len(data)) > MaxLogSize {
+		err := r.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(data)
+	r.size = r.size + int64(n)
+	return err
+}
@@ -0,0 +1,205 @@
+package lookup
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"embed"
+	"strconv"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/amo13/anarchy-droid/logger"
+)
+
+// The device profiles are bundled with the app, so the devices can be recognized offline
+// Newer profiles are downloaded in the background and cached until the next app update bundles them
+// Increase version.txt with every change to devices.yml
+
+//go:embed devices.yml version.txt
+var bundled embed.FS
+
+var DatabaseUrl = "https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/lookup/"
+var DatabaseCachePath = "bin/lookup"
+
+var database_tables = []string{
+	"devices.yml",
+}
+
+var database_mutex sync.Mutex
+
+// Returns the version of the bundled tables
+func BundledDatabaseVersion() int {
+	content, _ := bundled.ReadFile("version.txt")
+	version, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	return version
+}
+
+// Returns the version of the cached tables, 0 if there are none
+func cachedDatabaseVersion() int {
+	content, err := ioutil.ReadFile(filepath.Join(DatabaseCachePath, "version.txt"))
+	if err != nil {
+		return 0
+	}
+	version, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	return version
+}
+
+// Returns the version of the tables in use and whether they are the bundled ones
+func DatabaseVersion() (int, bool) {
+	if cachedDatabaseVersion() > BundledDatabaseVersion() {
+		return cachedDatabaseVersion(), false
+	}
+	return BundledDatabaseVersion(), true
+}
+
+// Returns the content of a table, from the cache if it is newer than the bundled one
+func readTable(name string) ([]byte, error) {
+	database_mutex.Lock()
+	defer database_mutex.Unlock()
+
+	if cachedDatabaseVersion() > BundledDatabaseVersion() {
+		content, err := ioutil.ReadFile(filepath.Join(DatabaseCachePath, name))
+		if err == nil {
+			return content, nil
+		}
+		logger.Log("Cached " + name + " is missing, using the bundled one:", err.Error())
+	}
+
+	return bundled.ReadFile(name)
+}
+
+// Downloads the tables if a newer version has been published
+// Nothing is cached unless all of them could be downloaded and parsed
+func UpdateDatabase() error {
+	remote, err := fetchTable("version.txt")
+	if err != nil {
+		return err
+	}
+	remote_version, err := strconv.Atoi(strings.TrimSpace(string(remote)))
+	if err != nil {
+		return fmt.Errorf("invalid database version %s", strings.TrimSpace(string(remote)))
+	}
+	current, _ := DatabaseVersion()
+	if remote_version <= current {
+		return nil
+	}
+
+	tables := make(map[string][]byte)
+	for _, name := range database_tables {
+		content, err := fetchTable(name)
+		if err != nil {
+			return err
+		}
+		db, err := ParseDatabase(content)
+		if err != nil {
+			return fmt.Errorf("unable to parse the downloaded %s: %v", name, err)
+		}
+		if len(db.Devices) == 0 {
+			return fmt.Errorf("the downloaded %s is empty", name)
+		}
+		tables[name] = content
+	}
+
+	err = writeTables(tables, remote)
+	if err != nil {
+		return err
+	}
+
+	resetTables()
+	logger.Log("Updated the device database to version " + strconv.Itoa(remote_version))
+	return nil
+}
+
+func writeTables(tables map[string][]byte, version []byte) error {
+	database_mutex.Lock()
+	defer database_mutex.Unlock()
+
+	for name, content := range tables {
+		err := ioutil.WriteFile(filepath.Join(DatabaseCachePath, name), content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	// Written last, so an interrupted update keeps using the previous tables
+	return ioutil.WriteFile(filepath.Join(DatabaseCachePath, "version.txt"), version, 0644)
+}
+
+// Downloads a file of the database, the cached copy is returned if it has not changed
+func fetchTable(name string) ([]byte, error) {
+	err := os.MkdirAll(DatabaseCachePath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	download_path := filepath.Join(DatabaseCachePath, name + ".download")
+	etag_path := filepath.Join(DatabaseCachePath, name + ".etag")
+
+	req, err := http.NewRequest("GET", DatabaseUrl + name, nil)
+	if err != nil {
+		return nil, err
+	}
+	etag, err := ioutil.ReadFile(etag_path)
+	if err == nil {
+		req.Header.Set("If-None-Match", strings.TrimSpace(string(etag)))
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		content, err := ioutil.ReadFile(download_path)
+		if err == nil {
+			return content, nil
+		}
+		// The cached copy is gone, download it again without the etag
+		os.Remove(etag_path)
+		return fetchTable(name)
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s: %s", name, resp.Status)
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(download_path, content, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get("ETag") != "" {
+		ioutil.WriteFile(etag_path, []byte(resp.Header.Get("ETag")), 0644)
+	}
+
+	return content, nil
+}
+
+// Makes the lookups read the tables again
+// The lookup CSV does not change and stays indexed
+func resetTables() {
+	profiles_mutex.Lock()
+	Profiles = nil
+	profiles_mutex.Unlock()
+
+	search_mutex.Lock()
+	search_index = nil
+	search_mutex.Unlock()
+
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+	codenames_index = nil
+	models_index = nil
+	AliasYamlMap = nil
+	CodenameToBrandYamlMap = nil
+	ModelToCodenameYamlMap = nil
+	SupportedYamlMap = nil
+	RecoveryPartitionYamlMap = nil
+	RecoveryKeyCombinationYamlMap = nil
+	BootloaderKeyCombinationYamlMap = nil
+}
@@ -0,0 +1,79 @@
+package lookup
+
+import (
+	"sync"
+	"strings"
+)
+
+// The lookup CSV and the profiles are indexed once, because the observer loop
+// queries them over and over. All keys are lowercase.
+
+// Guards the yaml maps and the indexes below
+var tables_mutex sync.Mutex
+// Guards the download of the lookup CSV
+var csv_mutex sync.Mutex
+
+// Value of each CSV column to the lines containing it
+var csv_index []map[string][]int
+// Codenames of the profiles
+var codenames_index map[string]bool
+// Codename of the profiles to their models
+var models_index map[string][]string
+
+// Returns the lookup CSV and its index, downloading it the first time
+func csvIndex() ([][]string, []map[string][]int, error) {
+	table, err := lookupCsvToTable()
+	if err != nil {
+		return table, nil, err
+	}
+
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+
+	if csv_index != nil {
+		return table, csv_index, nil
+	}
+
+	// Retail Branding, Marketing Name, Device, Model
+	index := make([]map[string][]int, 4)
+	for column := range index {
+		index[column] = make(map[string][]int)
+	}
+	for line := range table {
+		for column := 0; column < len(index) && column < len(table[line]); column++ {
+			key := strings.ToLower(table[line][column])
+			index[column][key] = append(index[column][key], line)
+		}
+	}
+
+	csv_index = index
+	return table, csv_index, nil
+}
+
+// Returns the codenames and models of the profiles
+func profilesIndex() (map[string]bool, map[string][]string, error) {
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+
+	if codenames_index != nil {
+		return codenames_index, models_index, nil
+	}
+
+	db, err := profiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	codenames := make(map[string]bool)
+	models := make(map[string][]string)
+	for codename, p := range db.Devices {
+		codenames[strings.ToLower(codename)] = true
+		for _, model := range p.Models {
+			models[strings.ToLower(codename)] = append(models[strings.ToLower(codename)], strings.ToLower(model))
+		}
+	}
+
+	codenames_index = codenames
+	models_index = models
+	return codenames_index, models_index, nil
+}
@@ -2,6 +2,7 @@ package lookup
 
 import(
 	"fmt"
+	"errors"
 	"strings"
 	"strconv"
 
@@ -12,6 +13,10 @@ import(
 	"github.com/amo13/anarchy-droid/device/fastboot"
 )
 
+// Several devices match the model or codename
+var ErrAmbiguous = errors.New("ambiguous")
+var ErrNotFound = errors.New("not found")
+
 var AliasYamlMap map[string]string
 var CodenameToBrandYamlMap map[string]string
 var ModelToCodenameYamlMap map[string]string
@@ -131,18 +136,23 @@ func modelToCodenameYaml(model string) (string, error) {
 }
 
 func modelToCodenameYamlMap() (map[string]string, error) {
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+
 	if ModelToCodenameYamlMap != nil && len(ModelToCodenameYamlMap) > 0 {
 		return ModelToCodenameYamlMap, nil
 	}
 
-	content, err := helpers.ReadFromURL("https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/lookup/codenames.yml")
+	db, err := profiles()
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := helpers.YamlToDowncaseFlatMap(content)
-	if err != nil {
-		return nil, err
+	m := make(map[string]string)
+	for codename, p := range db.Devices {
+		for _, model := range p.Models {
+			m[strings.ToLower(model)] = codename
+		}
 	}
 
 	ModelToCodenameYamlMap = m
@@ -176,20 +186,12 @@ func CodenamesToModels(codenames []string) ([]string, error) {
 }
 
 func codenameToModelsYaml(codename string) ([]string, error) {
-	m, err := modelToCodenameYamlMap()
+	_, models, err := profilesIndex()
 	if err != nil {
 		return []string{}, err
 	}
 
-	matches := []string{}
-
-	for k, v := range m {
-		if codename == v {
-			matches = append(matches, k)
-		}
-	}
-
-	return helpers.UniqueNonEmptyElementsOfSlice(matches), nil
+	return helpers.UniqueNonEmptyElementsOfSlice(models[strings.ToLower(codename)]), nil
 }
 
 // Try to lookup in yaml first and CSV then
@@ -250,18 +252,23 @@ func codenameToBrandYaml(codename string) (string, error) {
 }
 
 func codenameToBrandYamlMap() (map[string]string, error) {
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+
 	if CodenameToBrandYamlMap != nil && len(CodenameToBrandYamlMap) > 0 {
 		return CodenameToBrandYamlMap, nil
 	}
 
-	content, err := helpers.ReadFromURL("https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/lookup/brands.yml")
+	db, err := profiles()
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := helpers.YamlToDowncaseFlatMap(content)
-	if err != nil {
-		return nil, err
+	m := make(map[string]string)
+	for codename, p := range db.Devices {
+		if p.Brand != "" {
+			m[strings.ToLower(codename)] = p.Brand
+		}
 	}
 
 	CodenameToBrandYamlMap = m
@@ -289,18 +296,23 @@ func IsSupported(codename string) (bool, error) {
 }
 
 func supportedYamlMap() (map[string]string, error) {
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+
 	if SupportedYamlMap != nil && len(SupportedYamlMap) > 0 {
 		return SupportedYamlMap, nil
 	}
 
-	content, err := helpers.ReadFromURL("https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/lookup/supported.yml")
+	db, err := profiles()
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := helpers.YamlToDowncaseFlatMap(content)
-	if err != nil {
-		return nil, err
+	m := make(map[string]string)
+	for codename, p := range db.Devices {
+		if p.Supported != nil {
+			m[strings.ToLower(codename)] = strconv.FormatBool(*p.Supported)
+		}
 	}
 
 	SupportedYamlMap = m
@@ -321,18 +333,23 @@ func recoveryPartitionYaml(codename string) (string, error) {
 }
 
 func recoveryPartitionYamlMap() (map[string]string, error) {
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+
 	if RecoveryPartitionYamlMap != nil && len(RecoveryPartitionYamlMap) > 0 {
 		return RecoveryPartitionYamlMap, nil
 	}
 
-	content, err := helpers.ReadFromURL("https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/lookup/recovery_partition_names.yml")
+	db, err := profiles()
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := helpers.YamlToFlatMap(content)
-	if err != nil {
-		return nil, err
+	m := make(map[string]string)
+	for codename, p := range db.Devices {
+		if p.Recovery_partition != "" {
+			m[codename] = p.Recovery_partition
+		}
 	}
 
 	RecoveryPartitionYamlMap = m
@@ -353,18 +370,29 @@ func recoveryKeyCombinationYaml(codename_or_brand string) (string, error) {
 }
 
 func recoveryKeyCombinationYamlMap() (map[string]string, error) {
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+
 	if RecoveryKeyCombinationYamlMap != nil && len(RecoveryKeyCombinationYamlMap) > 0 {
 		return RecoveryKeyCombinationYamlMap, nil
 	}
 
-	content, err := helpers.ReadFromURL("https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/lookup/recovery_key_combinations.yml")
+	db, err := profiles()
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := helpers.YamlToFlatMap(content)
-	if err != nil {
-		return nil, err
+	// Given for brands or codenames
+	m := make(map[string]string)
+	for brand, b := range db.Brands {
+		if b.Recovery_keys != "" {
+			m[brand] = b.Recovery_keys
+		}
+	}
+	for codename, p := range db.Devices {
+		if p.Recovery_keys != "" {
+			m[strings.ToLower(codename)] = p.Recovery_keys
+		}
 	}
 
 	RecoveryKeyCombinationYamlMap = m
@@ -385,18 +413,29 @@ func bootloaderKeyCombinationYaml(codename_or_brand string) (string, error) {
 }
 
 func bootloaderKeyCombinationYamlMap() (map[string]string, error) {
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+
 	if BootloaderKeyCombinationYamlMap != nil && len(BootloaderKeyCombinationYamlMap) > 0 {
 		return BootloaderKeyCombinationYamlMap, nil
 	}
 
-	content, err := helpers.ReadFromURL("https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/lookup/bootloader_key_combinations.yml")
+	db, err := profiles()
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := helpers.YamlToFlatMap(content)
-	if err != nil {
-		return nil, err
+	// Given for brands or codenames
+	m := make(map[string]string)
+	for brand, b := range db.Brands {
+		if b.Bootloader_keys != "" {
+			m[brand] = b.Bootloader_keys
+		}
+	}
+	for codename, p := range db.Devices {
+		if p.Bootloader_keys != "" {
+			m[strings.ToLower(codename)] = p.Bootloader_keys
+		}
 	}
 
 	BootloaderKeyCombinationYamlMap = m
@@ -417,18 +456,23 @@ func aliasYaml(codename string) (string, error) {
 }
 
 func aliasYamlMap() (map[string]string, error) {
+	tables_mutex.Lock()
+	defer tables_mutex.Unlock()
+
 	if AliasYamlMap != nil && len(AliasYamlMap) > 0 {
 		return AliasYamlMap, nil
 	}
 
-	content, err := helpers.ReadFromURL("https://raw.githubusercontent.com/amo13/Anarchy-Droid/master/lookup/aliases.yml")
+	db, err := profiles()
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := helpers.YamlToDowncaseFlatMap(content)
-	if err != nil {
-		return nil, err
+	m := make(map[string]string)
+	for codename, p := range db.Devices {
+		if len(p.Aliases) > 0 {
+			m[strings.ToLower(codename)] = p.Aliases[0]
+		}
 	}
 
 	AliasYamlMap = m
@@ -438,34 +482,29 @@ func aliasYamlMap() (map[string]string, error) {
 // Check if the given model name already is the codename
 func IsCodename(model string) (bool, error) {
 	// Check in codename yaml
-	cm, err := modelToCodenameYamlMap()
+	codenames, _, err := profilesIndex()
 	if err != nil {
 		return false, err
 	}
-
-	for _, v := range cm {
-		if strings.ToLower(v) == strings.ToLower(model) {
-			return true, nil
-		}
+	if codenames[strings.ToLower(model)] {
+		return true, nil
 	}
 
 	// Check in Device Lookup CSV if the model has got no entry in the YAML
+	cm, err := modelToCodenameYamlMap()
+	if err != nil {
+		return false, err
+	}
 	if cm[strings.ToLower(model)] != "" {
 		return false, nil
 	}
 
-	table, err := lookupCsvToTable()
+	_, index, err := csvIndex()
 	if err != nil {
 		return false, err
 	}
 
-	for line := range table {
-		if strings.ToLower(table[line][2]) == strings.ToLower(model) {
-			return true, nil
-		}
-	}
-
-	return false, nil
+	return len(index[2][strings.ToLower(model)]) > 0, nil
 }
 
 func dlDeviceLookupCsv() error {
@@ -477,7 +516,11 @@ func dlDeviceLookupCsv() error {
 	return nil
 }
 
+// Downloads and reads the CSV once
 func lookupCsvToTable() ([][]string, error) {
+	csv_mutex.Lock()
+	defer csv_mutex.Unlock()
+
 	if DeviceLookupCsvLines != nil {
 		return DeviceLookupCsvLines, nil
 	}
@@ -492,19 +535,20 @@ func lookupCsvToTable() ([][]string, error) {
 		return make([][]string, 0), err
 	}
 
-	return table, nil
+	DeviceLookupCsvLines = table
+	return DeviceLookupCsvLines, nil
 }
 
 func queryDeviceLookupCsvTable(item string, match_in_column int, lookup_from_column int) ([]string, error) {
-	table, err := lookupCsvToTable()
+	table, index, err := csvIndex()
 	if err != nil {
 		return []string{}, err
 	}
 
 	matches := make([]string, 0)
 
-	for line := range table {
-		if strings.ToLower(table[line][match_in_column]) == strings.ToLower(item) {
+	for _, line := range index[match_in_column][strings.ToLower(item)] {
+		if lookup_from_column < len(table[line]) {
 			matches = append(matches, strings.ToLower(table[line][lookup_from_column]))
 		}
 	}
@@ -536,7 +580,7 @@ func modelToCodenameCsv(model string) (string, error) {
 	result, err := helpers.PrefixOfAll(matches)
 	if err != nil {
 		// Triggers if ambiguous
-		return "", err
+		return "", ErrAmbiguous
 	}
 
 	// Look for matches in the ADB props and/or fastboot vars of the device
@@ -567,7 +611,7 @@ func modelToCodenameCsv(model string) (string, error) {
 			result, err = helpers.PrefixOfAll(matchedmatches)
 			if err != nil {
 				// Triggers if ambiguous
-				return "", err
+				return "", ErrAmbiguous
 			}
 		} else if fastboot.State() == "connected" {
 			// look for a match in the fastboot vars
@@ -592,7 +636,7 @@ func modelToCodenameCsv(model string) (string, error) {
 			result, err = helpers.PrefixOfAll(matchedmatches)
 			if err != nil {
 				// Triggers if ambiguous
-				return "", err
+				return "", ErrAmbiguous
 			}
 		} else {
 			logger.Log("Unable to query adb or fastboot for props or vars to check for matches with one of the codename candidates")
@@ -602,7 +646,7 @@ func modelToCodenameCsv(model string) (string, error) {
 
 	if result == "" {
 		if len(matches) > 1 {
-			return "", fmt.Errorf("ambiguous model " + model + " could be at least " + matches[0] + " and " + matches[1])
+			return "", fmt.Errorf("%w model %s could be at least %s and %s", ErrAmbiguous, model, matches[0], matches[1])
 		} else {
 			return "", fmt.Errorf("Unable to lookup codename for model %s with CSV", model)
 		}
@@ -664,14 +708,14 @@ func CodenameToNameCsv(codename string) (string, error) {
 	}
 
 	if len(candidates) == 0 {
-		return "", fmt.Errorf("not found")
+		return "", ErrNotFound
 	} else if len(candidates) == 1 {
 		return candidates[0], nil
 	} else {
 		r, err := helpers.PrefixOfAll(candidates)
 		if err != nil {
 			// Triggers if ambiguous
-			return "", err
+			return "", ErrAmbiguous
 		}
 		return r, nil
 	}
@@ -726,11 +770,11 @@ func codenameToBrandCsv(codename string) (string, error) {
 	}
 
 	if len(matches) == 0 {
-		return "", fmt.Errorf("Brand of " + codename + " not found")
+		return "", fmt.Errorf("Brand of %s %w", codename, ErrNotFound)
 	} else if len(matches) == 1 {
 		return matches[0], nil
 	} else {
-		return "", fmt.Errorf("Brand of " + codename + " is ambiguous: at least " + matches[0] + " and " + matches[1] + " are matching.")
+		return "", fmt.Errorf("Brand of %s is %w: at least %s and %s are matching.", codename, ErrAmbiguous, matches[0], matches[1])
 	}
 }
 
@@ -0,0 +1,164 @@
+package lookup
+
+import (
+	"os"
+	"strings"
+	"io/ioutil"
+
+	"github.com/amo13/anarchy-droid/logger"
+)
+
+// Corrections made by the user, applied on top of the device profiles
+// The file has the format of devices.yml, only the fields to change are needed:
+//
+//	devices:
+//	  potter:
+//	    models: [Moto G5 Plus XT1687]
+//	    recovery_partition: recovery
+//	    recovery: flash
+//	  A6020:
+//	    unlock_method: oem_unlock
+//	brands:
+//	  motorola:
+//	    bootloader_keys: "..."
+var OverridesPath = "overrides.yml"
+
+// Returns the overrides, nil if there are none
+func loadOverrides() (*Database, error) {
+	content, err := ioutil.ReadFile(OverridesPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ParseDatabase(content)
+}
+
+// Changes the profiles according to the overrides
+func (db *Database) apply(o *Database) error {
+	for name, ob := range o.Brands {
+		b := db.Brands[name]
+		if b == nil {
+			b = &BrandProfile{}
+			db.Brands[name] = b
+		}
+		b.Unlock_method = overrideString(b.Unlock_method, ob.Unlock_method)
+		b.Unlock_data = overrideString(b.Unlock_data, ob.Unlock_data)
+		b.Recovery_keys = overrideString(b.Recovery_keys, ob.Recovery_keys)
+		b.Bootloader_keys = overrideString(b.Bootloader_keys, ob.Bootloader_keys)
+		b.Notes = overrideString(b.Notes, ob.Notes)
+	}
+
+	for _, codename := range o.codenames() {
+		op := o.Devices[codename]
+		p := db.find(codename)
+		if p == nil {
+			p = &DeviceProfile{Codename: codename}
+			db.Devices[codename] = p
+		}
+
+		// A model can only belong to one codename
+		for _, model := range op.Models {
+			for _, other := range db.Devices {
+				other.Models = removeFold(other.Models, model)
+			}
+			p.Models = append(p.Models, model)
+		}
+		for _, alias := range op.Aliases {
+			p.Aliases = append(removeFold(p.Aliases, alias), alias)
+		}
+		p.Brand = overrideString(p.Brand, op.Brand)
+		if op.Recovery_partition != "" && op.Recovery == "" {
+			// Derived from the partition again
+			p.Recovery = ""
+		}
+		p.Recovery_partition = overrideString(p.Recovery_partition, op.Recovery_partition)
+		p.Recovery = overrideString(p.Recovery, op.Recovery)
+		p.Unlock_method = overrideString(p.Unlock_method, op.Unlock_method)
+		p.Unlock_data = overrideString(p.Unlock_data, op.Unlock_data)
+		p.Recovery_keys = overrideString(p.Recovery_keys, op.Recovery_keys)
+		p.Bootloader_keys = overrideString(p.Bootloader_keys, op.Bootloader_keys)
+		p.Notes = overrideString(p.Notes, op.Notes)
+		if op.Supported != nil {
+			p.Supported = op.Supported
+		}
+		if op.Ab != nil {
+			p.Ab = op.Ab
+		}
+	}
+
+	for _, p := range db.Devices {
+		p.brand = db.Brands[strings.ToLower(p.Brand)]
+	}
+	return db.Validate()
+}
+
+func overrideString(value string, override string) string {
+	if override != "" {
+		return override
+	}
+	return value
+}
+
+// Removes s from the slice, ignoring the case
+func removeFold(slice []string, s string) []string {
+	result := []string{}
+	for _, e := range slice {
+		if !strings.EqualFold(e, s) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Returns the codename saved for the model by SaveModelOverride, empty if there is none
+func ModelOverride(model string) (string, error) {
+	o, err := loadOverrides()
+	if err != nil || o == nil {
+		return "", err
+	}
+
+	for codename, p := range o.Devices {
+		for _, m := range p.Models {
+			if strings.EqualFold(m, model) {
+				return codename, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Remembers the codename chosen by the user for a model, e.g. when it was ambiguous
+func SaveModelOverride(model string, codename string) error {
+	o, err := loadOverrides()
+	if err != nil {
+		return err
+	}
+	if o == nil {
+		o = &Database{Brands: make(map[string]*BrandProfile), Devices: make(map[string]*DeviceProfile)}
+	}
+
+	for _, p := range o.Devices {
+		p.Models = removeFold(p.Models, model)
+	}
+	p := o.Devices[codename]
+	if p == nil {
+		p = &DeviceProfile{Codename: codename}
+		o.Devices[codename] = p
+	}
+	p.Models = append(p.Models, model)
+
+	content, err := o.marshal("# Local corrections of the device profiles, see lookup/overrides.go")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(OverridesPath, content, 0644)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Saved " + codename + " as the codename of " + model + " in " + OverridesPath)
+	resetTables()
+	return nil
+}
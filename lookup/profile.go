@@ -0,0 +1,435 @@
+package lookup
+
+import (
+	"fmt"
+	"bytes"
+	"sort"
+	"sync"
+	"errors"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/device/quirks"
+)
+
+// Everything known about the devices, read from devices.yml:
+//
+//	brands:
+//	  motorola:
+//	    recovery_keys: "Now use the VOLUME-DOWN button..."
+//	devices:
+//	  potter:
+//	    brand: motorola
+//	    models: [Moto G5 Plus, XT1685]
+//	    supported: true
+//	    recovery_partition: recovery
+//	    recovery: flash
+//
+// How the bootloader is unlocked is declared in device/quirks,
+// unlock_method and unlock_data override it with the constants defined there
+
+const (
+	// TWRP is booted temporarily with fastboot boot
+	RecoveryBoot = "boot"
+	// TWRP is written to the recovery partition
+	RecoveryFlash = "flash"
+)
+
+type DeviceProfile struct {
+	Codename string `yaml:"-"`
+	Brand string `yaml:"brand,omitempty"`
+	// Model names as reported by the device or sold in stores
+	Models []string `yaml:"models,omitempty"`
+	// Other codenames the roms and TWRP of the device can be found under
+	Aliases []string `yaml:"aliases,omitempty"`
+	// Unknown if not set
+	Supported *bool `yaml:"supported,omitempty"`
+	Recovery_partition string `yaml:"recovery_partition,omitempty"`
+	// boot or flash, depends on the partition if not set
+	Recovery string `yaml:"recovery,omitempty"`
+	// Override the ones of the brand
+	Unlock_method string `yaml:"unlock_method,omitempty"`
+	Unlock_data string `yaml:"unlock_data,omitempty"`
+	Recovery_keys string `yaml:"recovery_keys,omitempty"`
+	Bootloader_keys string `yaml:"bootloader_keys,omitempty"`
+	// Unknown if not set
+	Ab *bool `yaml:"ab,omitempty"`
+	Notes string `yaml:"notes,omitempty"`
+
+	brand *BrandProfile
+}
+
+// Defaults for all devices of a brand
+type BrandProfile struct {
+	Unlock_method string `yaml:"unlock_method,omitempty"`
+	Unlock_data string `yaml:"unlock_data,omitempty"`
+	Recovery_keys string `yaml:"recovery_keys,omitempty"`
+	Bootloader_keys string `yaml:"bootloader_keys,omitempty"`
+	Notes string `yaml:"notes,omitempty"`
+}
+
+type Database struct {
+	Brands map[string]*BrandProfile `yaml:"brands,omitempty"`
+	Devices map[string]*DeviceProfile `yaml:"devices,omitempty"`
+}
+
+var Profiles *Database
+var profiles_mutex sync.Mutex
+
+// Returns the profile of the codename or one of its aliases
+func Profile(codename string) (*DeviceProfile, error) {
+	db, err := profiles()
+	if err != nil {
+		return nil, err
+	}
+
+	p := db.find(codename)
+	if p == nil {
+		return nil, fmt.Errorf("no profile for %s: %w", codename, ErrNotFound)
+	}
+	return p, nil
+}
+
+func ProfileOfBrand(brand string) (*BrandProfile, error) {
+	db, err := profiles()
+	if err != nil {
+		return nil, err
+	}
+
+	b := db.Brands[strings.ToLower(brand)]
+	if b == nil {
+		return nil, fmt.Errorf("no profile for brand %s: %w", brand, ErrNotFound)
+	}
+	return b, nil
+}
+
+// Returns the unlock method and unlock data source overriding the quirks,
+// the brand is used if the codename is unknown. Empty if not overridden
+func UnlockOverride(codename string, brand string) (string, string, error) {
+	p, err := Profile(codename)
+	if err == nil {
+		method, data := p.UnlockOverride()
+		if method != "" || data != "" || p.brand != nil {
+			return method, data, nil
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return "", "", err
+	}
+
+	b, err := ProfileOfBrand(brand)
+	if errors.Is(err, ErrNotFound) {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+	return b.Unlock_method, b.Unlock_data, nil
+}
+
+func (p *DeviceProfile) UnlockOverride() (string, string) {
+	method, data := p.Unlock_method, p.Unlock_data
+	if p.brand != nil {
+		if method == "" {
+			method = p.brand.Unlock_method
+		}
+		if data == "" {
+			data = p.brand.Unlock_data
+		}
+	}
+	return method, data
+}
+
+func (p *DeviceProfile) RecoveryKeys() string {
+	if p.Recovery_keys == "" && p.brand != nil {
+		return p.brand.Recovery_keys
+	}
+	return p.Recovery_keys
+}
+
+func (p *DeviceProfile) BootloaderKeys() string {
+	if p.Bootloader_keys == "" && p.brand != nil {
+		return p.brand.Bootloader_keys
+	}
+	return p.Bootloader_keys
+}
+
+// True if the support state is unknown
+func (p *DeviceProfile) IsSupported() bool {
+	return p.Supported == nil || *p.Supported
+}
+
+// True if TWRP is booted with fastboot instead of written to a partition
+func (p *DeviceProfile) BootsRecovery() bool {
+	if p.Recovery != "" {
+		return p.Recovery == RecoveryBoot
+	}
+	return p.Recovery_partition == "" || strings.ToLower(p.Recovery_partition) == "boot"
+}
+
+func (db *Database) find(codename string) *DeviceProfile {
+	if p := db.Devices[codename]; p != nil {
+		return p
+	}
+	for c, p := range db.Devices {
+		if strings.EqualFold(c, codename) {
+			return p
+		}
+	}
+	for _, p := range db.Devices {
+		for _, alias := range p.Aliases {
+			if strings.EqualFold(alias, codename) {
+				return p
+			}
+		}
+	}
+
+	return nil
+}
+
+func profiles() (*Database, error) {
+	profiles_mutex.Lock()
+	defer profiles_mutex.Unlock()
+
+	if Profiles != nil {
+		return Profiles, nil
+	}
+
+	content, err := readTable("devices.yml")
+	if err != nil {
+		return nil, err
+	}
+	db, err := ParseDatabase(content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Broken overrides must not prevent the detection
+	o, err := loadOverrides()
+	if err != nil {
+		logger.LogError("Unable to read " + OverridesPath + ", ignoring it:", err)
+	} else if o != nil {
+		err = db.apply(o)
+		if err != nil {
+			logger.LogError("Unable to apply " + OverridesPath + ", ignoring it:", err)
+			db, _ = ParseDatabase(content)
+		}
+	}
+
+	Profiles = db
+	return Profiles, nil
+}
+
+// Parses and validates the content of devices.yml
+func ParseDatabase(content []byte) (*Database, error) {
+	db := &Database{}
+	err := yaml.Unmarshal(content, db)
+	if err != nil {
+		return nil, err
+	}
+	if db.Brands == nil {
+		db.Brands = make(map[string]*BrandProfile)
+	}
+	if db.Devices == nil {
+		db.Devices = make(map[string]*DeviceProfile)
+	}
+
+	for codename, p := range db.Devices {
+		if p == nil {
+			p = &DeviceProfile{}
+			db.Devices[codename] = p
+		}
+		p.Codename = codename
+		p.brand = db.Brands[strings.ToLower(p.Brand)]
+	}
+
+	err = db.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Returns an error listing all problems found
+func (db *Database) Validate() error {
+	problems := []string{}
+
+	for name, b := range db.Brands {
+		if b == nil {
+			problems = append(problems, "brand " + name + " is empty")
+			continue
+		}
+		if name != strings.ToLower(name) {
+			problems = append(problems, "brand " + name + " is not lowercase")
+		}
+		if !isOneOf(b.Unlock_method, quirks.UnlockMethods) {
+			problems = append(problems, "brand " + name + " has an unknown unlock_method " + b.Unlock_method)
+		}
+		if !isOneOf(b.Unlock_data, quirks.UnlockDataSources) {
+			problems = append(problems, "brand " + name + " has an unknown unlock_data " + b.Unlock_data)
+		}
+	}
+
+	models := make(map[string]string)
+	for _, codename := range db.codenames() {
+		p := db.Devices[codename]
+		if p.Brand != strings.ToLower(p.Brand) {
+			problems = append(problems, codename + ": brand " + p.Brand + " is not lowercase")
+		}
+		if !isOneOf(p.Unlock_method, quirks.UnlockMethods) {
+			problems = append(problems, codename + ": unknown unlock_method " + p.Unlock_method)
+		}
+		if !isOneOf(p.Unlock_data, quirks.UnlockDataSources) {
+			problems = append(problems, codename + ": unknown unlock_data " + p.Unlock_data)
+		}
+		if !isOneOf(p.Recovery, []string{"", RecoveryBoot, RecoveryFlash}) {
+			problems = append(problems, codename + ": recovery must be boot or flash, not " + p.Recovery)
+		}
+		if p.Recovery == RecoveryBoot && p.Recovery_partition != "" && strings.ToLower(p.Recovery_partition) != "boot" {
+			problems = append(problems, codename + ": recovery is booted but a recovery_partition is given")
+		}
+		for _, alias := range p.Aliases {
+			if strings.EqualFold(alias, codename) {
+				problems = append(problems, codename + ": alias of itself")
+			}
+		}
+		for _, model := range p.Models {
+			other, found := models[strings.ToLower(model)]
+			if found && other != codename {
+				problems = append(problems, codename + ": model " + model + " is also listed for " + other)
+			}
+			models[strings.ToLower(model)] = codename
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid device database:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+func (db *Database) codenames() []string {
+	codenames := make([]string, 0, len(db.Devices))
+	for codename := range db.Devices {
+		codenames = append(codenames, codename)
+	}
+	sort.Strings(codenames)
+	return codenames
+}
+
+func isOneOf(s string, values []string) bool {
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the profiles from the separate flat yaml files used before devices.yml
+func MigrateTables(dir string) (*Database, error) {
+	tables := make(map[string]map[string]string)
+	for _, name := range []string{"aliases.yml", "bootloader_key_combinations.yml", "brands.yml", "codenames.yml", "recovery_key_combinations.yml", "recovery_partition_names.yml", "supported.yml"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]string)
+		err = yaml.Unmarshal(content, &m)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %v", name, err)
+		}
+		tables[name] = m
+	}
+
+	db := &Database{Brands: make(map[string]*BrandProfile), Devices: make(map[string]*DeviceProfile)}
+	device := func(codename string) *DeviceProfile {
+		if db.Devices[codename] == nil {
+			db.Devices[codename] = &DeviceProfile{Codename: codename}
+		}
+		return db.Devices[codename]
+	}
+
+	brands := make(map[string]bool)
+	for _, brand := range MainstreamBrands {
+		brands[brand] = true
+	}
+	for codename, brand := range tables["brands.yml"] {
+		brands[strings.ToLower(brand)] = true
+		device(codename).Brand = strings.ToLower(brand)
+	}
+
+	// The key combinations are given for brands or codenames
+	for _, name := range []string{"recovery_key_combinations.yml", "bootloader_key_combinations.yml"} {
+		for key, keys := range tables[name] {
+			if brands[strings.ToLower(key)] {
+				b := db.Brands[strings.ToLower(key)]
+				if b == nil {
+					b = &BrandProfile{}
+					db.Brands[strings.ToLower(key)] = b
+				}
+				if name == "recovery_key_combinations.yml" {
+					b.Recovery_keys = keys
+				} else {
+					b.Bootloader_keys = keys
+				}
+			} else if name == "recovery_key_combinations.yml" {
+				device(key).Recovery_keys = keys
+			} else {
+				device(key).Bootloader_keys = keys
+			}
+		}
+	}
+
+	for model, codename := range tables["codenames.yml"] {
+		p := device(codename)
+		p.Models = append(p.Models, model)
+	}
+	for codename, alias := range tables["aliases.yml"] {
+		p := device(codename)
+		if !strings.EqualFold(alias, codename) {
+			p.Aliases = append(p.Aliases, alias)
+		}
+	}
+	for codename, supported := range tables["supported.yml"] {
+		s := strings.ToLower(supported) == "true"
+		device(codename).Supported = &s
+	}
+	for codename, partition := range tables["recovery_partition_names.yml"] {
+		p := device(codename)
+		p.Recovery_partition = partition
+		if strings.ToLower(partition) == "boot" {
+			p.Recovery = RecoveryBoot
+		} else {
+			p.Recovery = RecoveryFlash
+		}
+	}
+
+	for _, p := range db.Devices {
+		sort.Strings(p.Models)
+		sort.Strings(p.Aliases)
+		p.brand = db.Brands[p.Brand]
+	}
+
+	return db, db.Validate()
+}
+
+// Returns the database in the format of devices.yml
+func (db *Database) Marshal() ([]byte, error) {
+	return db.marshal("# Device profiles, see lookup/profile.go for the fields")
+}
+
+func (db *Database) marshal(header string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(header + "\n---\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(db)
+	if err != nil {
+		return nil, err
+	}
+	enc.Close()
+	return buf.Bytes(), nil
+}
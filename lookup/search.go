@@ -0,0 +1,319 @@
+package lookup
+
+import (
+	"sort"
+	"sync"
+	"strings"
+	"unicode"
+
+	"github.com/amo13/anarchy-droid/logger"
+)
+
+// Finds devices by whatever the user remembers of them, e.g. "moto g5 plus", "oneplus 6t" or "sm-g900f"
+// The marketing names, models and codenames of the device profiles and the lookup CSV are indexed
+
+type Candidate struct {
+	Brand string
+	// Marketing name
+	Name string
+	Codename string
+	Models []string
+	// Higher is better, 100 for an exact codename
+	Score int
+}
+
+// Label for pickers, e.g. "Motorola Moto G (5) Plus (potter)"
+func (c Candidate) String() string {
+	name := c.Name
+	if !strings.HasPrefix(strings.ToLower(name), strings.ToLower(c.Brand)) {
+		name = c.Brand + " " + name
+	}
+	return strings.TrimSpace(name) + " (" + c.Codename + ")"
+}
+
+type searchEntry struct {
+	candidate Candidate
+	// Tokens of the brand, name, models and codename
+	tokens []string
+	name_tokens []string
+	// Names, models and codename without separators
+	compacts []string
+}
+
+var search_index []*searchEntry
+var search_mutex sync.Mutex
+
+// Returns up to limit candidates, the best match first
+func Search(query string, limit int) ([]Candidate, error) {
+	index, err := searchIndex()
+	if err != nil {
+		return []Candidate{}, err
+	}
+
+	query_tokens := tokenize(query)
+	if len(query_tokens) == 0 {
+		return []Candidate{}, nil
+	}
+	query_compact := strings.Join(query_tokens, "")
+
+	results := []Candidate{}
+	for _, e := range index {
+		score := e.score(query_tokens, query_compact)
+		if score > 0 {
+			c := e.candidate
+			c.Score = score
+			results = append(results, c)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if len(results[i].Name) != len(results[j].Name) {
+			return len(results[i].Name) < len(results[j].Name)
+		}
+		return results[i].String() < results[j].String()
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (e *searchEntry) score(query_tokens []string, query_compact string) int {
+	if query_compact == e.compacts[0] {
+		return 100
+	}
+	for _, c := range e.compacts[1:] {
+		if query_compact == c {
+			return 95
+		}
+	}
+
+	total := 0.0
+	for _, q := range query_tokens {
+		best := 0.0
+		for _, t := range e.tokens {
+			s := tokenSimilarity(q, t)
+			if s > best {
+				best = s
+			}
+		}
+		total = total + best
+	}
+	ratio := total / float64(len(query_tokens))
+	if ratio < 0.75 {
+		return 0
+	}
+
+	// Prefer "Moto G (5) Plus" over "Moto G (5) Plus Special Edition"
+	extra := 0
+	for _, t := range e.name_tokens {
+		matched := false
+		for _, q := range query_tokens {
+			if tokenSimilarity(q, t) > 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			extra++
+		}
+	}
+
+	score := int(90 * ratio) - 3 * extra
+	if score < 1 {
+		score = 1
+	}
+	return score
+}
+
+// Returns 1 for equal tokens, less for prefixes and typos and 0 otherwise
+// Numbers need to match exactly, a G5 is not a G6
+func tokenSimilarity(q string, t string) float64 {
+	if q == t {
+		return 1
+	}
+	if isNumber(q) || isNumber(t) {
+		return 0
+	}
+	if len(q) >= 2 && strings.HasPrefix(t, q) {
+		return 0.8
+	}
+	if len(q) >= 4 && len(t) >= 4 {
+		d := levenshtein(q, t)
+		if d <= 1 {
+			return 0.7
+		} else if d <= 2 && len(q) >= 7 {
+			return 0.5
+		}
+	}
+	return 0
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Splits into lowercase runs of letters and digits, "SM-G900F" becomes sm, g, 900, f
+func tokenize(s string) []string {
+	tokens := []string{}
+	current := []rune{}
+	last_digit := false
+	for _, r := range strings.ToLower(s) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(current) > 0 {
+				tokens = append(tokens, string(current))
+				current = []rune{}
+			}
+			continue
+		}
+		if len(current) > 0 && unicode.IsDigit(r) != last_digit {
+			tokens = append(tokens, string(current))
+			current = []rune{}
+		}
+		current = append(current, r)
+		last_digit = unicode.IsDigit(r)
+	}
+	if len(current) > 0 {
+		tokens = append(tokens, string(current))
+	}
+
+	return tokens
+}
+
+func compact(s string) string {
+	return strings.Join(tokenize(s), "")
+}
+
+// Number of single character edits to turn a into b
+func levenshtein(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	previous := make([]int, len(rb) + 1)
+	current := make([]int, len(rb) + 1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j] + 1, current[j-1] + 1), previous[j-1] + cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Builds the index once, the lookup CSV is skipped if it cannot be downloaded
+func searchIndex() ([]*searchEntry, error) {
+	search_mutex.Lock()
+	defer search_mutex.Unlock()
+
+	if search_index != nil {
+		return search_index, nil
+	}
+
+	db, err := profiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// One entry per codename and marketing name
+	entries := make(map[string]*searchEntry)
+	by_codename := make(map[string]*searchEntry)
+	index := []*searchEntry{}
+	add := func(brand string, name string, codename string, models ...string) {
+		codename = trimCodenameSuffix(strings.TrimSpace(codename))
+		if codename == "" {
+			return
+		}
+		key := strings.ToLower(codename + "|" + name)
+		e := entries[key]
+		if e == nil {
+			e = &searchEntry{candidate: Candidate{Brand: brand, Name: name, Codename: codename}}
+			entries[key] = e
+			index = append(index, e)
+		}
+		if by_codename[strings.ToLower(codename)] == nil {
+			by_codename[strings.ToLower(codename)] = e
+		}
+		for _, model := range models {
+			if model != "" {
+				e.candidate.Models = append(e.candidate.Models, model)
+			}
+		}
+	}
+
+	table, err := lookupCsvToTable()
+	if err != nil {
+		logger.Log("Searching the device profiles only, the lookup CSV is not available:", err.Error())
+	}
+	for _, line := range table {
+		if len(line) < 4 || strings.EqualFold(line[2], "Device") {
+			continue
+		}
+		name := line[1]
+		if name == "" {
+			name = line[3]
+		}
+		add(line[0], name, strings.ToLower(line[2]), line[3])
+	}
+
+	// The models of the profiles are added to the marketing name from the CSV
+	for _, codename := range db.codenames() {
+		p := db.Devices[codename]
+		if e := by_codename[strings.ToLower(codename)]; e != nil {
+			e.candidate.Models = append(e.candidate.Models, p.Models...)
+		} else if len(p.Models) > 0 {
+			add(strings.Title(p.Brand), p.Models[0], codename, p.Models...)
+		} else {
+			add(strings.Title(p.Brand), codename, codename)
+		}
+	}
+
+	for _, e := range index {
+		c := e.candidate
+		e.name_tokens = tokenize(c.Name)
+		e.tokens = append(append(tokenize(c.Brand), e.name_tokens...), tokenize(c.Codename)...)
+		// The codename comes first, see score
+		e.compacts = []string{compact(c.Codename), compact(c.Name), compact(c.Brand + c.Name)}
+		for _, model := range c.Models {
+			e.tokens = append(e.tokens, tokenize(model)...)
+			e.compacts = append(e.compacts, compact(model))
+		}
+	}
+
+	search_index = index
+	return search_index, nil
+}
+
+// Removes trailing _ds, _cdma and similar from the codename
+func trimCodenameSuffix(codename string) string {
+	for _, suffix := range CodenameSuffixes {
+		if strings.HasSuffix(codename, suffix) {
+			return codename[:len(codename)-len(suffix)]
+		}
+	}
+	return codename
+}
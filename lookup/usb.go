@@ -0,0 +1,79 @@
+package lookup
+
+import (
+	"sort"
+	"strings"
+)
+
+// USB vendor ids used by the devices of each brand, in bootloader and download modes as well
+// Several brands share the ids of their chip makers or parent companies
+var UsbVendorIds = map[string][]string{
+	"acer": {"0502"},
+	"alcatel": {"1bbb"},
+	"archos": {"0e79"},
+	"asus": {"0b05"},
+	"blu": {"0e8d"},
+	"bq": {"2a47"},
+	"dell": {"413c"},
+	"essential": {"2e17"},
+	"fairphone": {"2ae5"},
+	"google": {"18d1"},
+	"htc": {"0bb4"},
+	"huawei": {"12d1"},
+	"kyocera": {"0482"},
+	"leeco": {"2b0e"},
+	"lenovo": {"17ef"},
+	"lg": {"1004"},
+	"meizu": {"2a45"},
+	"motorola": {"22b8"},
+	"nokia": {"0421", "2e04"},
+	"nvidia": {"0955"},
+	"oneplus": {"2a70"},
+	"oppo": {"22d9"},
+	"realme": {"22d9"},
+	"redmi": {"2717"},
+	"samsung": {"04e8"},
+	"sharp": {"04dd"},
+	"sony": {"0fce"},
+	"vivo": {"2d95"},
+	"xiaomi": {"2717"},
+	"yu": {"05c6"},
+	"zte": {"19d2"},
+	"zuk": {"2b4c"},
+	// Generic bootloader, download and emergency modes of many brands
+	"qualcomm": {"05c6"},
+	"mediatek": {"0e8d"},
+}
+
+// Returns all known vendor ids, sorted and without duplicates
+func AllUsbVendorIds() []string {
+	seen := make(map[string]bool)
+	ids := []string{}
+	for _, vids := range UsbVendorIds {
+		for _, vid := range vids {
+			if !seen[vid] {
+				seen[vid] = true
+				ids = append(ids, vid)
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Returns the brands using the given vendor id, sorted
+func UsbVendorIdToBrands(vid string) []string {
+	vid = strings.ToLower(vid)
+	brands := []string{}
+	for brand, vids := range UsbVendorIds {
+		for _, v := range vids {
+			if v == vid {
+				brands = append(brands, brand)
+			}
+		}
+	}
+	sort.Strings(brands)
+
+	return brands
+}
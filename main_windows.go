@@ -4,8 +4,6 @@ import (
 	"os"
 	"time"
 	"syscall"
-	"strings"
-	"runtime"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -13,7 +11,6 @@ import (
 	"golang.org/x/sys/windows"
 
 	"github.com/amo13/anarchy-droid/logger"
-	"github.com/amo13/anarchy-droid/helpers"
 
 	"github.com/getsentry/sentry-go"
 )
@@ -92,6 +89,17 @@ func main() {
 	// Flush buffered events before the program terminates.
 	// Set the timeout to the maximum duration the program can afford to wait.
 	defer sentry.Flush(5 * time.Second)
+	defer logger.WaitForReports(5 * time.Second)
+
+	// Run headless if a subcommand is given
+	// Output is only visible if the app has been built as a console app
+	if isCliCommand(os.Args[1:]) {
+		setupWorkingDirectory()
+		code := runCli(os.Args[1:])
+		sentry.Flush(5 * time.Second)
+		logger.WaitForReports(5 * time.Second)
+		os.Exit(code)
+	}
 
 	a = app.NewWithID("com.anarchy-droid")
 	a.SetIcon(resourceIconPng)
@@ -105,33 +113,7 @@ func main() {
 	active_screen = "initScreen"
 	w.SetContent(initScreen())
 
-	// On MacOS, move into the user's Downloads folder
-	// to prevent being either inside the read-only
-	// application package or inside a jail folder
-	if runtime.GOOS == "darwin" {
-		u, _ := helpers.Cmd("whoami")
-		u = strings.ReplaceAll(u, "\n", "")
-		os.Chdir("/Users/" + u + "/Downloads")
-	}
-
-	// Set working directory to a subdir named like the app
-	_, err = os.Stat(AppName)
-	if os.IsNotExist(err) {
-		err = os.Mkdir(AppName, 0755)
-	    if err != nil {
-	        logger.LogError("Error setting working directory:", err)
-	    }
-	}
-	os.Chdir(AppName)
-
-	// Create log directory if it does not exist
-	_, err = os.Stat("log")
-	if os.IsNotExist(err) {
-		err = os.Mkdir("log", 0755)
-	    if err != nil {
-	        logger.LogError("Unable to create log directory:", err)
-	    }
-	}
+	setupWorkingDirectory()
 
 	go func() {
 		go logger.Report(map[string]string{"progress":"Setup App"})
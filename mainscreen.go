@@ -16,10 +16,12 @@ import(
 	"github.com/amo13/anarchy-droid/lookup"
 	"github.com/amo13/anarchy-droid/logger"
 	"github.com/amo13/anarchy-droid/helpers"
+	"github.com/amo13/anarchy-droid/device/usb"
 )
 
 var last_codename string	// Used in IsNewDevice() to help call ReloadRoms() when a new device (codename) is connected
 var Candidates *widget.Select 	// Used for user prompt in ambiguous cases for codename
+var Chk_remember_candidate *widget.Check 	// Saves the answer to the prompt in the overrides
 
 func mainScreen() fyne.CanvasObject {
 	initAllWidgets()
@@ -46,6 +48,7 @@ func initAllWidgets() {
 	initHelptabWidgets()
 	// For device selection dialog:
 	Candidates = widget.NewSelect([]string{}, func(string){})
+	Chk_remember_candidate = widget.NewCheck("Remember my choice for this model", func(bool){})
 }
 
 func setDefaults() {
@@ -57,6 +60,28 @@ func setDefaults() {
 	Candidates.PlaceHolder = "Select your device"
 }
 
+// Collects the flashing options from the widgets
+func guiFlashOptions() *FlashOptions {
+	return &FlashOptions{
+		User_rom: Chk_user_rom.Checked,
+		User_twrp: Chk_user_twrp.Checked,
+		Skip_unlock: Chk_skipunlock.Checked,
+		Skip_wipe_data: Chk_skipwipedata.Checked,
+		Skip_flash_twrp: Chk_skipflashtwrp.Checked,
+		Copy_partitions: Chk_copypartitions.Checked,
+		Reboot_after_installation: Chk_reboot_after_installation.Checked,
+		Gapps: Select_gapps.Selected,
+		Opengapps_version: Select_opengapps_version.Selected,
+		Opengapps_variant: Select_opengapps_variant.Selected,
+		Fdroid: Chk_fdroid.Checked,
+		Aurora: Chk_aurora.Checked,
+		Playstore: Chk_playstore.Checked,
+		Sigspoof: Chk_sigspoof.Checked,
+		Gsync: Chk_gsync.Checked,
+		Swype: Chk_swype.Checked,
+	}
+}
+
 // Helper function to open a web browser at given url
 func OpenWebBrowser(href string) {
 	u, err := url.Parse(href)
@@ -121,14 +146,14 @@ func updateMainScreen() {
 		return
 	}
 
-	if device.D1.State != "disconnected" {
+	if device.D1.State != "disconnected" && !device.IsUsbOnly(device.D1.State) {
 		if device.D1.Codename_ambiguous {
 			// Already reset the ambiguity marker to prevent
 			// further dialogs from popping up
 			device.D1.Codename_ambiguous = false
 
 			// Prompt the user to select their device model
-			cc, err := lookup.ModelToCodenameCandidates(device.D1.Model)
+			cc, err := device.D1.CodenameCandidates()
 			if err != nil {
 				logger.LogError("Error retrieving codename candidates from model " + device.D1.Model, err)
 				return
@@ -150,10 +175,19 @@ func updateMainScreen() {
 
 			Candidates.Options = helpers.UniqueNonEmptyElementsOfSlice(mc)
 
-			candidates_dialog := dialog.NewCustom("Select your device model", "OK", Candidates, w)
+			reported_model := device.D1.Model
+			candidates_dialog := dialog.NewCustom("Select your device model", "OK", container.NewVBox(Candidates, Chk_remember_candidate), w)
 			candidates_dialog.SetOnClosed(func() {
 				device.D1.Model = Candidates.Selected
+				// The choice of the user beats the detection
+				codename, err := lookup.ModelToCodename(Candidates.Selected)
+				if err == nil && codename != "" {
+					device.D1.Codename = codename
+				}
 				device.D1.ReadMissingProps()
+				if Chk_remember_candidate.Checked {
+					rememberCandidate(reported_model, device.D1.Codename)
+				}
 			})
 			candidates_dialog.Show()
 
@@ -192,10 +226,14 @@ func updateMainScreen() {
 
 			return
 		}
+	} else if device.IsUsbOnly(device.D1.State) {
+		Lbl_device_detection.SetText("Device not accessible")
 	} else {
 		Lbl_device_detection.SetText("No device connected")
 	}
 
+	offerResume()
+
 	switch device.D1.State {
 	case "unauthorized":
 		Lbl_instructions.SetText("Device unauthorized!\n\nPlease ALLOW and hit OK on your device screen.")
@@ -207,7 +245,9 @@ func updateMainScreen() {
 		Lbl_instructions.SetText("Device in sideload mode.\n\nPlease wait for it to finish.")
 	case "heimdall", "fastboot":
 		Lbl_instructions.SetText("Please reboot your device to Android.")
-	case "recovery", "android", "simulation":
+	case usb.ModeEdl, usb.ModeMtkPreloader, usb.ModeSamsungDownload, usb.ModeNoPermission, usb.ModeDebuggingOff:
+		Lbl_instructions.SetText(usb.Instructions[device.D1.State])
+	case "recovery", "android":
 		deviceRecognized()
 	default:
 		Lbl_instructions.SetText("Unknown device connection state.")
@@ -226,6 +266,8 @@ func deviceRecognized() {
 		return
 	}
 
+	updateUpdateButton()
+
 	if device.D1.IsSupported {
 		if !device.D1.IsUnlocked && (device.D1.IsBrandUnlockable || Chk_skipunlock.Checked) {	// unlock needed and feasible
 			if get.A1.User.Twrp.Img.Href != "" {	// got TWRP image
@@ -305,4 +347,16 @@ func deviceRecognized() {
 	} else {
 		Lbl_instructions.SetText("Unfortunately, " + AppName + " does not support your device.")
 	}
-}
\ No newline at end of file
+}
+
+// Saves the codename chosen in the candidates dialog so the user is not asked again
+func rememberCandidate(model string, codename string) {
+	if model == "" || codename == "" {
+		return
+	}
+
+	err := lookup.SaveModelOverride(model, codename)
+	if err != nil {
+		logger.LogError("Unable to remember " + codename + " for " + model + ":", err)
+	}
+}
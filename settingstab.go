@@ -21,6 +21,7 @@ import(
 // Left side
 var Lbl_android_version *widget.Label
 var Select_rom *widget.Select
+var Select_build *widget.Select
 var Radio_rom_source *widget.RadioGroup
 var Chk_user_rom *widget.Check
 var Lbl_user_rom *widget.Label
@@ -54,11 +55,86 @@ func registerRomChoice() {
 		}
 	}
 
+	populateBuildChoices()
 	displayFileNameAndAndroidVersion()
 	selectOpenGappsVersion()
 	setGappsSelectability()	
 }
 
+// Build labels mapped to their items for the build picker
+var build_choices map[string]*get.Item
+
+// Offer all builds of the chosen rom with the latest one preselected
+// Keep a previously chosen older build if it is still in the list
+func populateBuildChoices() {
+	previous := Select_build.Selected
+	rom := get.A1.User.Rom
+	build_choices = make(map[string]*get.Item)
+	options := make([]string, 0)
+
+	if !Chk_user_rom.Checked && Radio_rom_source.Selected == "Official Releases" {
+		for i, build := range rom.Builds {
+			label := buildLabel(build)
+			if build_choices[label] != nil {
+				label = label + " (" + build.Filename + ")"
+			}
+			if i == 0 && build.Href == rom.Href {
+				// The latest build is the rom item itself
+				build_choices[label] = rom
+			} else {
+				build_choices[label] = build
+			}
+			options = append(options, label)
+		}
+	}
+
+	Select_build.Options = options
+	if len(options) > 1 {
+		Select_build.Enable()
+	} else {
+		Select_build.Disable()
+	}
+
+	// Set the selection directly to avoid triggering changedBuild
+	if previous != "" && build_choices[previous] != nil {
+		Select_build.Selected = previous
+		get.A1.User.Rom = build_choices[previous]
+	} else if len(options) > 0 {
+		Select_build.Selected = options[0]
+	} else {
+		Select_build.Selected = ""
+	}
+	Select_build.Refresh()
+}
+
+func buildLabel(build *get.Item) string {
+	label := build.Filename
+	if build.Date != "" {
+		label = build.Date
+		if build.Version != "" {
+			label = label + "  " + build.Version
+		}
+	}
+	if build.Size > 0 {
+		label = label + fmt.Sprintf("  (%d MB)", build.Size / 1000000)
+	}
+
+	return label
+}
+
+func changedBuild(label string) {
+	if label == "" || build_choices[label] == nil {
+		return
+	}
+
+	get.A1.User.Rom = build_choices[label]
+	logger.Log("Build chosen: " + get.A1.User.Rom.Filename)
+
+	displayFileNameAndAndroidVersion()
+	selectOpenGappsVersion()
+	setGappsSelectability()
+}
+
 // Prevent changing Gapps selection for roms providing Gapps or MicroG
 // Except for LineageOSMicroG if LineageOS is also available
 func setGappsSelectability() {
@@ -138,6 +214,7 @@ func compileSelectableRomList() []string {
 func chkUserRomChanged(checked bool) {
 	if checked {
 		Select_rom.Disable()
+		Select_build.Disable()
 		Radio_rom_source.Disable()
 		Lbl_android_version.SetText("")
 		Dialog_user_rom := dialog.NewFileOpen(userRomSelected, w)
@@ -220,6 +297,8 @@ func ReloadRoms() {
 	Lbl_user_rom.SetText("Reloading available roms...")
 
 	Select_rom.Disable()
+	Select_build.Disable()
+	Select_build.Selected = ""
 
 	get.A1 = get.NewAvailable()
 	err := get.A1.Populate(device.D1.Codename)
@@ -525,6 +604,7 @@ func initSettingstabWidgets() {
 	// Left side
 	Lbl_android_version = widget.NewLabel("")
 	Select_rom = widget.NewSelect([]string{}, changedRom)
+	Select_build = widget.NewSelect([]string{}, changedBuild)
 	Radio_rom_source = widget.NewRadioGroup([]string{"Archive", "Official Releases"}, changedRomSource)
 	Radio_rom_source.Horizontal = true
 	Chk_user_rom = widget.NewCheck("Provide your own rom zip file", chkUserRomChanged)
@@ -545,6 +625,8 @@ func setDefaultsSettingstab() {
 	Radio_rom_source.SetSelected("Official Releases")
 	Select_rom.PlaceHolder = "Select rom"
 	Select_rom.Disable()
+	Select_build.PlaceHolder = "Latest build"
+	Select_build.Disable()
 	Chk_fdroid.SetChecked(true)
 	Chk_aurora.SetChecked(false)
 	Chk_aurora.Enable()
@@ -567,7 +649,7 @@ func settingstab() fyne.CanvasObject {
 
 	box_source := Radio_rom_source
 
-	leftside := container.NewVBox(box_labels, box_rom, box_source, Chk_user_rom, Lbl_user_rom)
+	leftside := container.NewVBox(box_labels, box_rom, Select_build, box_source, Chk_user_rom, Lbl_user_rom)
 	leftcard := widget.NewCard("", "", leftside)
 
 	// Right side
@@ -1,12 +1,18 @@
 package main
 
 import(
+	"strconv"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/widget"
+	"fyne.io/fyne/v2/dialog"
 
+	"github.com/amo13/anarchy-droid/get"
+	"github.com/amo13/anarchy-droid/device"
 	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/flashplan"
 )
 
 var ReadyToStart bool
@@ -15,19 +21,95 @@ var ReadyToStart bool
 // Left side
 
 var Btn_start *widget.Button
+var Btn_update *widget.Button
 var Chk_gotbackups *widget.Check
 var Lbl_device_detection *widget.Label
 var Lbl_brand_codename *widget.Label
 
 func btnStartClicked() {
 	go func() {
-		err := prepareFlash()
+		w.SetContent(flashingScreen())
+		active_screen = "flashingScreen"
+
+		err := prepareFlash(guiFlashOptions(), &guiFlashUi{start_over: true})
 		if err != nil {
 			logger.LogError("prepareFlash() failed:", err)
 		}
 	}()
 }
 
+func btnUpdateClicked() {
+	go func() {
+		w.SetContent(flashingScreen())
+		active_screen = "flashingScreen"
+
+		err := prepareUpdate(guiFlashOptions(), &guiFlashUi{start_over: true})
+		if err != nil {
+			logger.LogError("prepareUpdate() failed:", err)
+		}
+	}()
+}
+
+// Serial number of the last device resuming has been offered for
+var resume_offered string
+
+// Offer to resume an interrupted installation once the device is connected again
+func offerResume() {
+	serial := device.D1.SerialNumber
+	if serial == "" || serial == resume_offered || device.D1.Flashing || !flashplan.HasCheckpoint(serial) {
+		return
+	}
+	resume_offered = serial
+
+	checkpoint, err := flashplan.LoadCheckpoint(serial)
+	if err != nil {
+		logger.LogError("Unable to load the checkpoint of " + serial + ":", err)
+		return
+	}
+
+	message := "The installation on this device has been interrupted after step " + strconv.Itoa(checkpoint.Completed) + " of " + strconv.Itoa(len(checkpoint.Plan.Steps)) + ".\n\nDo you want to resume it?"
+	dialog.ShowConfirm("Resume installation?", message, func(resume bool) {
+		if !resume {
+			err := flashplan.RemoveCheckpoint(serial)
+			if err != nil {
+				logger.LogError("Unable to remove the checkpoint:", err)
+			}
+			return
+		}
+
+		go func() {
+			w.SetContent(flashingScreen())
+			active_screen = "flashingScreen"
+
+			err := resumeFlash(checkpoint, &guiFlashUi{start_over: true})
+			if err != nil {
+				logger.LogError("resumeFlash() failed:", err)
+			}
+		}()
+	}, w)
+}
+
+// Show the update button if a newer build of the installed rom is available
+func updateUpdateButton() {
+	candidate := device.D1.UpdateCandidate()
+	if candidate == nil || get.A1.User.Twrp.Img.Href == "" {
+		Btn_update.Hide()
+		return
+	}
+
+	label := "Update " + device.D1.InstalledRom
+	if candidate.Date != "" {
+		label = label + " to " + candidate.Date
+	}
+	Btn_update.SetText(label)
+	if Chk_gotbackups.Checked {
+		Btn_update.Enable()
+	} else {
+		Btn_update.Disable()
+	}
+	Btn_update.Show()
+}
+
 func chkGotbackupsChanged(value bool) {
 	updateMainScreen()
 }
@@ -41,6 +123,7 @@ var initial_instructions = "Welcome to " + AppName + "!\n\nOn your connected And
 
 func initStarttabWidgets() {
 	Btn_start = widget.NewButton("Start", btnStartClicked)
+	Btn_update = widget.NewButton("Update", btnUpdateClicked)
 	Chk_gotbackups = widget.NewCheck("I've got backups of all I need", chkGotbackupsChanged)
 	Lbl_device_detection = widget.NewLabel("")
 	Lbl_brand_codename = widget.NewLabel("")
@@ -55,12 +138,13 @@ func setDefaultsStarttab() {
 	Lbl_brand_codename.Alignment = fyne.TextAlignCenter
 	Lbl_instructions.SetText(initial_instructions)
 	Btn_start.Disable()
+	Btn_update.Hide()
 }
 
 func starttab() fyne.CanvasObject {
 	// Left side
 	empty := widget.NewLabel("")
-	leftside := container.NewVBox(Btn_start, Chk_gotbackups, empty, Lbl_device_detection, Lbl_brand_codename)
+	leftside := container.NewVBox(Btn_start, Btn_update, Chk_gotbackups, empty, Lbl_device_detection, Lbl_brand_codename)
 	leftcard := widget.NewCard("", "", leftside)
 
 	// Right side
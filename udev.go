@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/amo13/anarchy-droid/device"
+	"github.com/amo13/anarchy-droid/lookup"
+	"github.com/amo13/anarchy-droid/logger"
+	"github.com/amo13/anarchy-droid/helpers"
+	"github.com/amo13/anarchy-droid/device/usb"
+
+	"os"
+	"fmt"
+	"flag"
+	"strings"
+	"runtime"
+	"io/ioutil"
+)
+
+// Udev rules give the logged in user access to android devices on linux, so no root is needed
+
+const UdevRulesPath = "/etc/udev/rules.d/51-android.rules"
+const udevRulesHeader = "# Generated by " + AppName
+
+// Returns the rules for the vendor ids of all known brands and the connected devices
+func generateUdevRules() string {
+	lines := []string{
+		udevRulesHeader + ", generate it again instead of editing it",
+		"",
+		`SUBSYSTEM!="usb", GOTO="anarchy_droid_end"`,
+		`ENV{DEVTYPE}!="usb_device", GOTO="anarchy_droid_end"`,
+		"",
+	}
+	known := make(map[string]bool)
+	for _, vid := range lookup.AllUsbVendorIds() {
+		known[vid] = true
+		lines = append(lines, "# " + strings.Join(lookup.UsbVendorIdToBrands(vid), ", "))
+		lines = append(lines, udevRule(vid, ""))
+	}
+
+	// Connected devices of unknown vendors
+	devices, err := usb.List()
+	if err != nil {
+		logger.Log("Unable to list the usb devices:", err.Error())
+	}
+	for _, d := range devices {
+		if known[d.Vendor_id] {
+			continue
+		}
+		connected := device.D1.SerialNumber != "" && d.Serial == device.D1.SerialNumber
+		if connected || d.HasInterface(usb.InterfaceAdb) || d.HasInterface(usb.InterfaceFastboot) {
+			lines = append(lines, "# Connected " + d.Manufacturer + " " + d.Product)
+			lines = append(lines, udevRule(d.Vendor_id, d.Product_id))
+		}
+	}
+
+	lines = append(lines, "", `LABEL="anarchy_droid_end"`)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func udevRule(vid string, pid string) string {
+	rule := `ATTR{idVendor}=="` + vid + `", `
+	if pid != "" {
+		rule = rule + `ATTR{idProduct}=="` + pid + `", `
+	}
+	return rule + `MODE="0660", TAG+="uaccess"`
+}
+
+// True if rules generated by this app are installed
+func udevRulesInstalled() bool {
+	content, err := ioutil.ReadFile(UdevRulesPath)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(string(content), udevRulesHeader)
+}
+
+// Installs the rules with root privileges and reloads udev
+// Installing needs root even if the rules are meant to make root unnecessary, pkexec is used then
+func installUdevRules(p helpers.Privilege) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("udev rules are only needed on linux")
+	}
+	if p.Name() == "udev" {
+		p = helpers.Pkexec{}
+	}
+
+	tmp, err := ioutil.TempFile("", "51-android-*.rules")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(generateUdevRules())
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	commands := [][]string{
+		{"install", "-m", "0644", tmp.Name(), UdevRulesPath},
+		{"udevadm", "control", "--reload-rules"},
+		{"udevadm", "trigger", "--subsystem-match=usb"},
+	}
+	for _, c := range commands {
+		_, stderr, err := helpers.CmdAs(p, c[0], c[1:]...)
+		if err != nil {
+			return fmt.Errorf("%s failed: %s", strings.Join(c, " "), strings.TrimSpace(stderr + " " + err.Error()))
+		}
+	}
+	logger.Log("Installed udev rules to " + UdevRulesPath + " with " + p.Name())
+
+	helpers.Cmd("udevadm", "settle", "--timeout=5")
+	return checkUdevAccess()
+}
+
+// Returns an error if a connected android device still cannot be accessed without root
+func checkUdevAccess() error {
+	devices, err := usb.List()
+	if err != nil {
+		return err
+	}
+
+	denied := []string{}
+	for _, d := range devices {
+		android := len(lookup.UsbVendorIdToBrands(d.Vendor_id)) > 0 || d.HasInterface(usb.InterfaceAdb) || d.HasInterface(usb.InterfaceFastboot)
+		if android && !d.Accessible() {
+			denied = append(denied, d.String())
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("still no access to %s, try to reconnect the device", strings.Join(denied, ", "))
+	}
+
+	return nil
+}
+
+func cliUdev(args []string) error {
+	fs := flag.NewFlagSet("udev", flag.ContinueOnError)
+	output := fs.String("o", "", "Write the rules to this file instead of printing them")
+	install := fs.Bool("install", false, "Install the rules to " + UdevRulesPath + " and reload udev")
+	privilege := fs.String("privilege", "sudo", "How to get root for installing: pkexec or sudo")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *install {
+		p, err := helpers.NewPrivilege(*privilege, "")
+		if err != nil {
+			return err
+		}
+		err = installUdevRules(p)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Udev rules installed, devices are accessible without root")
+		return nil
+	}
+
+	if *output != "" {
+		return ioutil.WriteFile(*output, []byte(generateUdevRules()), 0644)
+	}
+	fmt.Print(generateUdevRules())
+	return nil
+}
@@ -8,6 +8,9 @@ import(
 	"github.com/amo13/anarchy-droid/logger"
 	"github.com/amo13/anarchy-droid/device"
 	"github.com/amo13/anarchy-droid/device/adb"
+	"github.com/amo13/anarchy-droid/device/errs"
+
+	"errors"
 )
 
 var Center_flashing_box *fyne.Container
@@ -41,7 +44,7 @@ func sonyUnlockScreen() fyne.CanvasObject {
 			input,
 			widget.NewButton("Unlock and continue", func() {
 				if input.Text != "" {
-					unlockStep(input.Text)
+					guiUnlockStep(input.Text)
 				}
 			}),
 		),
@@ -61,17 +64,18 @@ func motorolaUnlockScreen() fyne.CanvasObject {
 		defer Btn_first_unlock_step.Enable()
 		defer Btn_first_unlock_step.SetText("Open unlock guide")
 		unlock_data, err := device.D1.GetUnlockData()
-		if err != nil && err.Error() != "unlocked" {
+		if errors.Is(err, errs.ErrAlreadyUnlocked) {
+			logger.Log("Bootloader is already unlocked")
+			device.D1.IsUnlocked = true
+			w.SetContent(flashingScreen())
+			Lbl_flashing_instructions.SetText("Your bootloader is already unlocked.")
+			gui_unlock_code <- ""
+		} else if err != nil {
 			logger.LogError("Error during retrieval of unlock data:", err)
 			// What now?
-		} else if err.Error() != "unlocked" {
+		} else {
 			logger.Log("Unlock data is:", unlock_data)
 			OpenWebBrowser("https://help.anarchy-droid.com/unlock-motorola/?code=" + unlock_data)
-		} else {
-			logger.Log("Bootloader is already unlocked")
-			w.SetContent(flashingScreen())
-			Lbl_flashing_instructions.SetText("Your bootloader is already unlocked.")
-			bootTwrpStep()
 		}
 		}()
 	})
@@ -84,7 +88,7 @@ func motorolaUnlockScreen() fyne.CanvasObject {
 		container.NewGridWithColumns(2, input,
 		widget.NewButton("Unlock and continue", func() {
 			if input.Text != "" {
-				unlockStep(input.Text)
+				guiUnlockStep(input.Text)
 			}
 		}),
 	))
@@ -109,7 +113,7 @@ func fairphoneUnlockScreen() fyne.CanvasObject {
 			btn_open_fairphone_website,
 			widget.NewLabelWithStyle("Once you are done:", fyne.TextAlignCenter ,fyne.TextStyle{}),
 			widget.NewButton("Continue", func() {
-				unlockStep("")
+				guiUnlockStep("")
 			}),
 		),
 	)